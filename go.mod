@@ -3,6 +3,7 @@ module github.com/mullerpeter/databricks-grafana
 go 1.20
 
 require (
+	github.com/apache/arrow/go/v12 v12.0.1
 	github.com/databricks/databricks-sql-go v1.4.0
 	github.com/grafana/grafana-plugin-sdk-go v0.176.0
 )
@@ -12,7 +13,6 @@ require (
 	github.com/BurntSushi/toml v1.2.1 // indirect
 	github.com/andybalholm/brotli v1.0.4 // indirect
 	github.com/apache/arrow/go/arrow v0.0.0-20211112161151-bc219186db40 // indirect
-	github.com/apache/arrow/go/v12 v12.0.1 // indirect
 	github.com/apache/thrift v0.17.0 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cenkalti/backoff/v4 v4.2.1 // indirect
@@ -2,6 +2,8 @@ package main
 
 import (
 	"os"
+	// Timezones of dashboards are resolved even if the host has no timezone database
+	_ "time/tzdata"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/databricks/databricks-sql-go/auth"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// doAPIRequest sends a request to the Databricks REST API of the workspace and decodes
+// the JSON response into result, unless it is nil.
+func doAPIRequest(ctx context.Context, httpClient *http.Client, hostname string, authenticator auth.Authenticator, method string, path string, body interface{}, result interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&payload).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("https://%s%s", hostname, path), &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	err = authenticator.Authenticate(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			ErrorCode string `json:"error_code"`
+			Message   string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("Databricks API request %s failed with status %d: %s", path, resp.StatusCode, apiErr.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// apiRequest sends a request to the Databricks REST API of the workspace of the
+// datasource, see doAPIRequest.
+func (d *Datasource) apiRequest(ctx context.Context, authenticator auth.Authenticator, method string, path string, body interface{}, result interface{}) error {
+	httpClient := &http.Client{Transport: d.connection.transport, Timeout: 30 * time.Second}
+	hostname := strings.TrimPrefix(strings.TrimPrefix(d.connection.hostname, "https://"), "http://")
+	return doAPIRequest(ctx, httpClient, hostname, authenticator, method, path, body, result)
+}
@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"io"
+	"strconv"
+	"time"
+)
+
+// newArrowField creates a nullable field matching the type of the Arrow column. Lists,
+// maps and structs are converted to JSON, other types without a frame equivalent to
+// strings.
+func newArrowField(field arrow.Field, conversion conversionOptions) *data.Field {
+	if (field.Type.ID() == arrow.DECIMAL128 && conversion.decimalAsString) || (field.Type.ID() == arrow.INT64 && conversion.bigintAsString) {
+		return data.NewField(field.Name, nil, []*string{})
+	}
+	switch field.Type.ID() {
+	case arrow.BOOL:
+		return data.NewField(field.Name, nil, []*bool{})
+	case arrow.INT8, arrow.INT16, arrow.INT32, arrow.INT64:
+		return data.NewField(field.Name, nil, []*int64{})
+	case arrow.FLOAT32, arrow.FLOAT64, arrow.DECIMAL128:
+		return data.NewField(field.Name, nil, []*float64{})
+	case arrow.DATE32, arrow.DATE64, arrow.TIMESTAMP:
+		return data.NewField(field.Name, nil, []*time.Time{})
+	case arrow.LIST, arrow.LARGE_LIST, arrow.MAP, arrow.STRUCT:
+		return data.NewField(field.Name, nil, []*json.RawMessage{})
+	case arrow.INTERVAL_MONTHS, arrow.DURATION:
+		return conversion.newIntervalField(field.Name)
+	default:
+		return data.NewField(field.Name, nil, []*string{})
+	}
+}
+
+// appendArrowColumn appends the values of an Arrow column to the field created by
+// newArrowField for it.
+func appendArrowColumn(field *data.Field, column arrow.Array, conversion conversionOptions) {
+	for i := 0; i < column.Len(); i++ {
+		if column.IsNull(i) {
+			field.Append(nil)
+			continue
+		}
+		switch c := column.(type) {
+		case *array.Boolean:
+			v := c.Value(i)
+			field.Append(&v)
+		case *array.Int8:
+			v := int64(c.Value(i))
+			field.Append(&v)
+		case *array.Int16:
+			v := int64(c.Value(i))
+			field.Append(&v)
+		case *array.Int32:
+			v := int64(c.Value(i))
+			field.Append(&v)
+		case *array.Int64:
+			if field.Type() == data.FieldTypeNullableString {
+				v := strconv.FormatInt(c.Value(i), 10)
+				field.Append(&v)
+				continue
+			}
+			v := c.Value(i)
+			field.Append(&v)
+		case *array.Float32:
+			v := float64(c.Value(i))
+			field.Append(&v)
+		case *array.Float64:
+			v := c.Value(i)
+			field.Append(&v)
+		case *array.Decimal128:
+			scale := c.DataType().(*arrow.Decimal128Type).Scale
+			if field.Type() == data.FieldTypeNullableString {
+				v := c.Value(i).ToString(scale)
+				field.Append(&v)
+				continue
+			}
+			v := c.Value(i).ToFloat64(scale)
+			field.Append(&v)
+		case *array.Date32:
+			v := c.Value(i).ToTime()
+			field.Append(&v)
+		case *array.Date64:
+			v := c.Value(i).ToTime()
+			field.Append(&v)
+		case *array.Timestamp:
+			timestampType := c.DataType().(*arrow.TimestampType)
+			v := c.Value(i).ToTime(timestampType.Unit)
+			// Timestamps without timezone are TIMESTAMP_NTZ values
+			if timestampType.TimeZone == "" {
+				v = conversion.ntzTime(v)
+			}
+			field.Append(&v)
+		case *array.MonthInterval:
+			field.Append(conversion.intervalValue(int64(c.Value(i)), 0))
+		case *array.Duration:
+			unit := c.DataType().(*arrow.DurationType).Unit
+			field.Append(conversion.intervalValue(0, time.Duration(c.Value(i))*unit.Multiplier()))
+		case *array.String:
+			v := c.Value(i)
+			field.Append(&v)
+		default:
+			if field.Type() == data.FieldTypeNullableJSON {
+				b, err := json.Marshal(c.GetOneForMarshal(i))
+				if err == nil {
+					v := json.RawMessage(b)
+					field.Append(&v)
+					continue
+				}
+			}
+			v := c.ValueStr(i)
+			field.Append(&v)
+		}
+	}
+}
+
+// arrowStreamFields reads an Arrow IPC stream and converts its record batches to
+// frame fields.
+func arrowStreamFields(r io.Reader, conversion conversionOptions) ([]*data.Field, error) {
+	reader, err := ipc.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Arrow stream: %w", err)
+	}
+	defer reader.Release()
+
+	schema := reader.Schema()
+	fields := make([]*data.Field, 0, len(schema.Fields()))
+	for _, field := range schema.Fields() {
+		fields = append(fields, newArrowField(field, conversion))
+	}
+	for reader.Next() {
+		record := reader.Record()
+		for i, column := range record.Columns() {
+			appendArrowColumn(fields[i], column, conversion)
+		}
+	}
+	if err := reader.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"sync"
+	"time"
+)
+
+// asyncResultTTL is the time the result of an async query is kept once it finished,
+// results not fetched within it are dropped.
+const asyncResultTTL = 10 * time.Minute
+
+const (
+	asyncStatusRunning = "running"
+)
+
+// asyncQuery is a query executed in the background.
+type asyncQuery struct {
+	done     chan struct{}
+	response backend.DataResponse
+	finished time.Time
+}
+
+// asyncQueries holds the queries executed asynchronously. Async queries return a handle
+// right away, which is polled by the frontend until the result is available, so queries
+// can run longer than the timeout of the Grafana data proxy.
+type asyncQueries struct {
+	mu      sync.Mutex
+	queries map[string]*asyncQuery
+}
+
+func newAsyncQueries() *asyncQueries {
+	return &asyncQueries{queries: make(map[string]*asyncQuery)}
+}
+
+// newAsyncHandle returns a random handle for an async query.
+func newAsyncHandle() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// add registers a running query and drops expired results.
+func (a *asyncQueries) add(key string) *asyncQuery {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, q := range a.queries {
+		if !q.finished.IsZero() && time.Since(q.finished) > asyncResultTTL {
+			delete(a.queries, k)
+		}
+	}
+	q := &asyncQuery{done: make(chan struct{})}
+	a.queries[key] = q
+	return q
+}
+
+func (a *asyncQueries) finish(q *asyncQuery, response backend.DataResponse) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	q.response = response
+	q.finished = time.Now()
+	close(q.done)
+}
+
+// result returns the response of the query if it finished, which is removed, or a frame
+// stating that the query is still running.
+func (a *asyncQueries) result(key string, handle string) backend.DataResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	q, ok := a.queries[key]
+	if !ok {
+		return backend.DataResponse{Error: fmt.Errorf("the async query %s was not found, it may have expired or the datasource settings changed", handle)}
+	}
+	select {
+	case <-q.done:
+		delete(a.queries, key)
+		return q.response
+	default:
+		return asyncRunningResponse(handle)
+	}
+}
+
+// asyncRunningResponse returns an empty frame carrying the handle of the running query.
+func asyncRunningResponse(handle string) backend.DataResponse {
+	frame := data.NewFrame("response")
+	frame.SetMeta(&data.FrameMeta{
+		Custom: map[string]interface{}{
+			"asyncHandle": handle,
+			"asyncStatus": asyncStatusRunning,
+		},
+	})
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// startAsyncQuery executes the query in the background and returns its handle. The
+// query uses its own pools and isn't bound to the context of the request, it can be
+// cancelled using its handle like any other running query.
+func (d *Datasource) startAsyncQuery(pools *requestPools, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) backend.DataResponse {
+	handle, err := newAsyncHandle()
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	key := runningQueryKey(pCtx, handle)
+	q := d.asyncQueries.add(key)
+
+	asyncPools := d.poolsForRequest(pCtx, pools.headers)
+	ctx, cancel := d.withDrainDeadline(context.Background())
+	ctx, done := d.running.register(ctx, key)
+	go func() {
+		defer cancel()
+		defer done()
+		defer asyncPools.release()
+		logger.Info("Async Query started", "handle", handle)
+		response := d.runQuery(ctx, asyncPools, pCtx, query, qm)
+		logger.Info("Async Query finished", "handle", handle, "err", response.Error)
+		d.asyncQueries.finish(q, response)
+	}()
+	return asyncRunningResponse(handle)
+}
@@ -0,0 +1,307 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// azureDatabricksResourceId is the well known application ID of the Azure Databricks
+// resource, used as scope when requesting Azure AD tokens.
+const azureDatabricksResourceId = "2ff814a6-3304-4ab8-85cb-cd0e6f879c1d"
+
+// tokenExpiryDelta is subtracted from the token lifetime so that tokens are
+// refreshed shortly before they actually expire.
+const tokenExpiryDelta = 1 * time.Minute
+
+// azureInstanceMetadataTokenURL is the token endpoint of the Azure Instance Metadata
+// Service, available on Azure VMs, VM scale sets and AKS nodes.
+const azureInstanceMetadataTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+type tokenResponseBody struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// ExpiresIn is a number for OAuth endpoints, but a string for the Azure
+	// Instance Metadata Service.
+	ExpiresIn json.Number `json:"expires_in"`
+}
+
+// tokenProvider fetches access tokens used to authenticate against Databricks.
+type tokenProvider interface {
+	// fetchToken requests a new access token and returns it together with the time
+	// at which it should be refreshed.
+	fetchToken() (string, time.Time, error)
+}
+
+// tokenAuthenticator implements auth.Authenticator of the databricks sql driver. It
+// caches the token of the provider and refreshes it once it is about to expire or
+// after it was invalidated, i.e. because Databricks rejected it.
+type tokenAuthenticator struct {
+	provider tokenProvider
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newTokenAuthenticator(provider tokenProvider) *tokenAuthenticator {
+	return &tokenAuthenticator{provider: provider}
+}
+
+func (a *tokenAuthenticator) Authenticate(r *http.Request) error {
+	token, err := a.getToken()
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return nil
+}
+
+func (a *tokenAuthenticator) getToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Now().Before(a.expiry) {
+		return a.token, nil
+	}
+
+	token, expiry, err := a.provider.fetchToken()
+	if err != nil {
+		logger.Error("Token Refresh Error", "err", err)
+		return "", err
+	}
+
+	a.token = token
+	a.expiry = expiry
+
+	return a.token, nil
+}
+
+// invalidate drops the cached token, so the next request fetches a new one.
+func (a *tokenAuthenticator) invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+}
+
+// credentials holds the authentication settings of a datasource or credential mapping.
+type credentials struct {
+	AuthenticationMethod string
+	TenantId             string
+	ClientId             string
+	Token                string
+	ClientSecret         string
+	ServiceAccountKey    string
+}
+
+// newTokenProvider creates the token provider for the authentication method. It returns
+// nil for plain personal access tokens, which can be passed to the driver directly.
+// Requests to the workspace use the transport of the connection, so they are sent
+// through the configured proxies and trust the configured CA.
+func newTokenProvider(hostname string, transport http.RoundTripper, creds credentials) (tokenProvider, error) {
+	switch creds.AuthenticationMethod {
+	case "m2m":
+		return newOAuthM2MTokenProvider(hostname, transport, creds.ClientId, creds.ClientSecret), nil
+	case "azure":
+		return newAzureADTokenProvider(transport, creds.TenantId, creds.ClientId, creds.ClientSecret), nil
+	case "azure-msi":
+		return newManagedIdentityTokenProvider(creds.ClientId), nil
+	case "gcp":
+		provider, err := newGoogleIdTokenProvider(hostname, transport, creds.ServiceAccountKey)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	default:
+		if isSecretReference(creds.Token) {
+			return newSecretReferenceTokenProvider(creds.Token), nil
+		}
+		return nil, nil
+	}
+}
+
+// staticTokenProvider provides a token that never expires, i.e. a personal access token.
+type staticTokenProvider struct {
+	token string
+}
+
+func (p *staticTokenProvider) fetchToken() (string, time.Time, error) {
+	if p.token == "" {
+		return "", time.Time{}, fmt.Errorf("no access token configured")
+	}
+	return p.token, time.Now().Add(24 * time.Hour), nil
+}
+
+// clientCredentialsTokenProvider acquires OAuth access tokens using the client
+// credentials flow.
+type clientCredentialsTokenProvider struct {
+	tokenURL     string
+	clientId     string
+	clientSecret string
+	scope        string
+	// credentialsInBody sends the client credentials as form parameters
+	// instead of using HTTP basic authentication.
+	credentialsInBody bool
+	httpClient        *http.Client
+}
+
+// newOAuthM2MTokenProvider creates a token provider for a Databricks service principal
+// using the workspace OIDC token endpoint, requested using the transport of the
+// connection to the workspace.
+func newOAuthM2MTokenProvider(hostname string, transport http.RoundTripper, clientId string, clientSecret string) *clientCredentialsTokenProvider {
+	return &clientCredentialsTokenProvider{
+		tokenURL:     fmt.Sprintf("https://%s/oidc/v1/token", hostname),
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		scope:        "all-apis",
+		httpClient:   &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+// newAzureADTokenProvider creates a token provider for an Azure AD service principal
+// requesting tokens for the Azure Databricks resource, requested using the transport of
+// the connection to the workspace.
+func newAzureADTokenProvider(transport http.RoundTripper, tenantId string, clientId string, clientSecret string) *clientCredentialsTokenProvider {
+	return &clientCredentialsTokenProvider{
+		tokenURL:          fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", url.PathEscape(tenantId)),
+		clientId:          clientId,
+		clientSecret:      clientSecret,
+		scope:             fmt.Sprintf("%s/.default", azureDatabricksResourceId),
+		credentialsInBody: true,
+		httpClient:        &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+func (p *clientCredentialsTokenProvider) fetchToken() (string, time.Time, error) {
+	logger.Info("Refreshing OAuth access token", "tokenURL", p.tokenURL)
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("scope", p.scope)
+	if p.credentialsInBody {
+		form.Set("client_id", p.clientId)
+		form.Set("client_secret", p.clientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if !p.credentialsInBody {
+		req.SetBasicAuth(url.QueryEscape(p.clientId), url.QueryEscape(p.clientSecret))
+	}
+
+	return doTokenRequest(p.httpClient, req)
+}
+
+// doTokenRequest executes a token request and returns the access token together with
+// the time at which it should be refreshed.
+func doTokenRequest(httpClient *http.Client, req *http.Request) (string, time.Time, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token request failed with status %d", resp.StatusCode)
+	}
+
+	var body tokenResponseBody
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if body.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token response did not contain an access token")
+	}
+	expiresIn, err := body.ExpiresIn.Int64()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid token expiry: %w", err)
+	}
+
+	return body.AccessToken, time.Now().Add(time.Duration(expiresIn)*time.Second - tokenExpiryDelta), nil
+}
+
+// managedIdentityTokenProvider acquires tokens using the Azure managed identity of the
+// host Grafana is running on, so no secret has to be stored in the datasource settings.
+type managedIdentityTokenProvider struct {
+	// clientId selects a user assigned identity, the system assigned identity is
+	// used if empty.
+	clientId   string
+	httpClient *http.Client
+}
+
+func newManagedIdentityTokenProvider(clientId string) *managedIdentityTokenProvider {
+	return &managedIdentityTokenProvider{
+		clientId:   clientId,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *managedIdentityTokenProvider) fetchToken() (string, time.Time, error) {
+	logger.Info("Refreshing Azure managed identity access token")
+
+	req, err := p.newTokenRequest()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return doTokenRequest(p.httpClient, req)
+}
+
+// newTokenRequest creates the token request for the managed identity endpoint. App
+// Service and Container Apps expose the endpoint via IDENTITY_ENDPOINT, all other
+// hosts use the Instance Metadata Service.
+func (p *managedIdentityTokenProvider) newTokenRequest() (*http.Request, error) {
+	query := url.Values{}
+	query.Set("resource", azureDatabricksResourceId)
+	if p.clientId != "" {
+		query.Set("client_id", p.clientId)
+	}
+
+	identityEndpoint := os.Getenv("IDENTITY_ENDPOINT")
+	identityHeader := os.Getenv("IDENTITY_HEADER")
+	useIdentityEndpoint := identityEndpoint != "" && identityHeader != ""
+
+	tokenURL := azureInstanceMetadataTokenURL
+	query.Set("api-version", "2018-02-01")
+	if useIdentityEndpoint {
+		tokenURL = identityEndpoint
+		query.Set("api-version", "2019-08-01")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s?%s", tokenURL, query.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	if useIdentityEndpoint {
+		req.Header.Set("X-IDENTITY-HEADER", identityHeader)
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+	return req, nil
+}
+
+var authErrorRgx = regexp.MustCompile(`(?i)\b(401|403)\b|unauthorized|invalid access token|token expired`)
+
+// isAuthError reports whether the error returned by the driver was caused by rejected
+// credentials.
+func isAuthError(err error) bool {
+	return err != nil && authErrorRgx.MatchString(err.Error())
+}
+
+// oauthPassThruToken returns the OAuth access token of the signed-in Grafana user
+// forwarded by Grafana when OAuth pass-through is enabled on the datasource.
+func oauthPassThruToken(headers backend.ForwardHTTPHeaders) string {
+	authHeader := headers.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName)
+	token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	return token
+}
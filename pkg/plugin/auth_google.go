@@ -0,0 +1,209 @@
+package plugin
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// googleMetadataIdentityURL is the identity token endpoint of the GCE metadata server,
+// which is also served to GKE workloads using workload identity.
+const googleMetadataIdentityURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/identity"
+
+type googleServiceAccountKey struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyId string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// googleIdTokenProvider provides Google ID tokens issued for the workspace URL, used
+// to authenticate against Databricks on Google Cloud. Tokens are issued either for a
+// service account key or for the workload identity provided by the metadata server.
+type googleIdTokenProvider struct {
+	audience   string
+	key        *googleServiceAccountKey
+	privateKey *rsa.PrivateKey
+	httpClient *http.Client
+}
+
+// newGoogleIdTokenProvider creates a token provider for the given workspace, requesting
+// tokens using the transport of the connection to the workspace. If no service account
+// key is provided the workload identity of the host is used.
+func newGoogleIdTokenProvider(hostname string, transport http.RoundTripper, serviceAccountKey string) (*googleIdTokenProvider, error) {
+	a := &googleIdTokenProvider{
+		audience:   fmt.Sprintf("https://%s", hostname),
+		httpClient: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+	if strings.TrimSpace(serviceAccountKey) == "" {
+		return a, nil
+	}
+
+	key := new(googleServiceAccountKey)
+	err := json.Unmarshal([]byte(serviceAccountKey), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the service account key: %w", err)
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode the service account private key")
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the service account private key: %w", err)
+	}
+	privateKey, ok := parsedKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("service account private key is not an RSA key")
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	a.key = key
+	a.privateKey = privateKey
+	return a, nil
+}
+
+func (a *googleIdTokenProvider) fetchToken() (string, time.Time, error) {
+	logger.Info("Refreshing Google ID token", "audience", a.audience)
+
+	var token string
+	var err error
+	if a.key != nil {
+		token, err = a.serviceAccountIdToken()
+	} else {
+		token, err = a.metadataIdToken()
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiry, err := jwtExpiry(token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiry.Add(-tokenExpiryDelta), nil
+}
+
+// metadataIdToken fetches an ID token for the workload identity from the metadata server.
+func (a *googleIdTokenProvider) metadataIdToken() (string, error) {
+	query := url.Values{}
+	query.Set("audience", a.audience)
+	query.Set("format", "full")
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s?%s", googleMetadataIdentityURL, query.Encode()), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("identity token request failed with status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// serviceAccountIdToken exchanges a self-signed JWT of the service account for an ID token.
+func (a *googleIdTokenProvider) serviceAccountIdToken() (string, error) {
+	now := time.Now()
+	assertion, err := signJWT(a.privateKey, a.key.PrivateKeyId, map[string]interface{}{
+		"iss":             a.key.ClientEmail,
+		"sub":             a.key.ClientEmail,
+		"aud":             a.key.TokenURI,
+		"target_audience": a.audience,
+		"iat":             now.Unix(),
+		"exp":             now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+
+	resp, err := a.httpClient.PostForm(a.key.TokenURI, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("identity token request failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		IdToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	if body.IdToken == "" {
+		return "", fmt.Errorf("token response did not contain an ID token")
+	}
+	return body.IdToken, nil
+}
+
+// signJWT creates a RS256 signed JWT with the given claims.
+func signJWT(privateKey *rsa.PrivateKey, keyId string, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyId})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// jwtExpiry returns the expiry of a JWT without verifying its signature.
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("invalid JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid JWT payload: %w", err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid JWT payload: %w", err)
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Defaults used if the circuit breaker is not configured.
+const (
+	defaultCircuitBreakerThreshold = 5
+	defaultCircuitBreakerCooldown  = 30 * time.Second
+)
+
+// circuitBreaker stops sending queries to an unavailable warehouse. After threshold
+// consecutive failures it opens and rejects queries until the cooldown passed, then a
+// single query is let through to probe whether the warehouse recovered.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// newCircuitBreaker returns nil if the circuit breaker is disabled using a negative
+// threshold. Zero values use the defaults.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold < 0 {
+		return nil
+	}
+	if threshold == 0 {
+		threshold = defaultCircuitBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultCircuitBreakerCooldown
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns an error if the circuit is open and the query must not be executed.
+// Otherwise the returned function has to be called once the query finished, so a probe
+// ending without the result of a statement, i.e. because it was cancelled or failed
+// before reaching the warehouse, lets the next query probe.
+func (b *circuitBreaker) allow() (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return func() {}, nil
+	}
+	if remaining := time.Until(b.openUntil); remaining > 0 || b.probing {
+		if remaining < 0 {
+			remaining = 0
+		}
+		return nil, fmt.Errorf("datasource unavailable: the last %d queries failed to reach the Databricks warehouse, retry in %s", b.failures, remaining.Round(time.Second))
+	}
+	b.probing = true
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.probing = false
+	}, nil
+}
+
+// record updates the state with the result of a statement executed on the warehouse.
+// Only errors indicating that the warehouse is unavailable count as failures, errors of
+// the query itself don't. Cancelled statements are ignored, their outcome is unknown.
+func (b *circuitBreaker) record(err error) {
+	if b == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !isTransientError(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		logger.Warn("Circuit breaker opened", "failures", b.failures, "cooldown", b.cooldown)
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"regexp"
+	"strings"
+)
+
+var (
+	// queryTableRgx matches the first table a statement selects from, i.e.
+	// FROM catalog.schema.table.
+	queryTableRgx = regexp.MustCompile("(?is)\\bFROM\\s+((?:`[^`]+`|\\w+)(?:\\s*\\.\\s*(?:`[^`]+`|\\w+)){0,2})")
+	// identifierPartRgx matches the parts of a qualified identifier.
+	identifierPartRgx = regexp.MustCompile("`[^`]+`|\\w+")
+)
+
+// unitTag is the Unity Catalog column tag whose value is used as unit of the field,
+// i.e. bytes or percent.
+const unitTag = "unit"
+
+// columnMetadata is the description and unit of a column of a table.
+type columnMetadata struct {
+	description string
+	unit        string
+}
+
+// queryTable returns the parts of the name of the first table the statement selects
+// from, or nil if there is none.
+func queryTable(statement string) []string {
+	match := queryTableRgx.FindStringSubmatch(statement)
+	if match == nil {
+		return nil
+	}
+	return splitIdentifier(match[1])
+}
+
+// columnMetadata looks up the comments and the unit tags of the columns of the first
+// table the statement selects from, by lower case column name. Tags are only available
+// for tables in Unity Catalog, errors looking them up are ignored.
+func (d *Datasource) columnMetadata(ctx context.Context, db *sql.DB, executor statementExecutor, statement string) (map[string]columnMetadata, error) {
+	table := queryTable(statement)
+	if table == nil {
+		return nil, nil
+	}
+	quoted := make([]string, len(table))
+	for i, part := range table {
+		quoted[i] = quoteIdentifier(part)
+	}
+
+	metadata := make(map[string]columnMetadata)
+	describe := fmt.Sprintf("DESCRIBE TABLE %s", strings.Join(quoted, "."))
+	var columns *data.Frame
+	_, err := d.execute(ctx, db, describe, func() error {
+		var err error
+		columns, err = executor.query(ctx, describe, -1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range frameRows(columns) {
+		if len(row) < 3 || row[0] == "" || strings.HasPrefix(row[0], "#") {
+			continue
+		}
+		metadata[strings.ToLower(row[0])] = columnMetadata{description: row[2]}
+	}
+
+	// information_schema of the catalog of the table, the current one if not qualified
+	conditions := []string{"table_name = " + quoteString(table[len(table)-1]), "tag_name = " + quoteString(unitTag)}
+	informationSchema := "information_schema"
+	switch len(table) {
+	case 3:
+		informationSchema = quoteIdentifier(table[0]) + ".information_schema"
+		conditions = append(conditions, "schema_name = "+quoteString(table[1]))
+	case 2:
+		conditions = append(conditions, "schema_name = "+quoteString(table[0]))
+	default:
+		conditions = append(conditions, "schema_name = current_schema()")
+	}
+	tagsQuery := fmt.Sprintf("SELECT column_name, tag_value FROM %s.column_tags WHERE %s", informationSchema, strings.Join(conditions, " AND "))
+	var tags *data.Frame
+	_, err = d.execute(ctx, db, tagsQuery, func() error {
+		var err error
+		tags, err = executor.query(ctx, tagsQuery, -1)
+		return err
+	})
+	if err != nil {
+		logger.Info("Column Tags Error", "err", err)
+		return metadata, nil
+	}
+	for _, row := range frameRows(tags) {
+		if len(row) < 2 {
+			continue
+		}
+		column := metadata[strings.ToLower(row[0])]
+		column.unit = row[1]
+		metadata[strings.ToLower(row[0])] = column
+	}
+	return metadata, nil
+}
+
+// frameRows returns the values of the rows of the frame as strings, null values as
+// empty strings.
+func frameRows(frame *data.Frame) [][]string {
+	rows, _ := frame.RowLen()
+	values := make([][]string, rows)
+	for row := range values {
+		values[row] = make([]string, len(frame.Fields))
+		for i, field := range frame.Fields {
+			if v, ok := field.ConcreteAt(row); ok {
+				values[row][i] = fmt.Sprint(v)
+			}
+		}
+	}
+	return values
+}
+
+// applyColumnMetadata sets the descriptions and units of the fields of the frame, unless
+// they are already set.
+func applyColumnMetadata(frame *data.Frame, metadata map[string]columnMetadata) {
+	for _, field := range frame.Fields {
+		column, ok := metadata[strings.ToLower(field.Name)]
+		if !ok {
+			continue
+		}
+		if field.Config == nil {
+			field.Config = &data.FieldConfig{}
+		}
+		if field.Config.Description == "" {
+			field.Config.Description = column.description
+		}
+		if field.Config.Unit == "" {
+			field.Config.Unit = column.unit
+		}
+	}
+}
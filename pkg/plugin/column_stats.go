@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxTopValues is the approximate number of distinct values up to which the most
+// frequent values of a column are returned.
+const maxTopValues = 20
+
+// columnValue is a value of a column with the number of rows having it.
+type columnValue struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// columnStats are quick statistics of a column, computed with a single aggregation
+// over the table or a sample of it. Min and max are returned as strings, null values
+// are omitted.
+type columnStats struct {
+	Column        string   `json:"column"`
+	Type          string   `json:"type,omitempty"`
+	RowCount      int64    `json:"rowCount"`
+	NullCount     int64    `json:"nullCount"`
+	NullFraction  *float64 `json:"nullFraction,omitempty"`
+	DistinctCount int64    `json:"distinctCount"`
+	Min           *string  `json:"min,omitempty"`
+	Max           *string  `json:"max,omitempty"`
+	// TopValues are the most frequent values, only set for columns with few distinct
+	// values.
+	TopValues        []columnValue `json:"topValues,omitempty"`
+	SamplePercent    int           `json:"samplePercent,omitempty"`
+	SuggestedFilters []string      `json:"suggestedFilters"`
+}
+
+// profileColumn computes the statistics of the column of the table, given as
+// [catalog, schema, table], [schema, table] or [table]. The distinct count is
+// approximated with approx_count_distinct. If samplePercent is set, only a sample of
+// the table is scanned and counts are not scaled. The statements are only executed if
+// check allows them.
+func profileColumn(ctx context.Context, db *sql.DB, table []string, column string, samplePercent int, check func(string) error) (*columnStats, error) {
+	quoted := make([]string, len(table))
+	for i, part := range table {
+		quoted[i] = quoteIdentifier(part)
+	}
+	from := strings.Join(quoted, ".")
+	if samplePercent > 0 {
+		from += fmt.Sprintf(" TABLESAMPLE (%d PERCENT)", samplePercent)
+	}
+	c := quoteIdentifier(column)
+
+	statement := fmt.Sprintf(
+		"SELECT typeof(MIN(%[1]s)), CAST(MIN(%[1]s) AS STRING), CAST(MAX(%[1]s) AS STRING), approx_count_distinct(%[1]s), COUNT(*), COUNT(%[1]s) FROM %[2]s",
+		c, from,
+	)
+	err := check(statement)
+	if err != nil {
+		return nil, err
+	}
+	_, rows, err := queryNullStrings(ctx, db, statement)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no statistics returned for column %s", column)
+	}
+	row := rows[0]
+	stats := &columnStats{
+		Column:           column,
+		Type:             row[0].String,
+		SamplePercent:    samplePercent,
+		SuggestedFilters: make([]string, 0),
+	}
+	if row[1].Valid {
+		stats.Min = &row[1].String
+	}
+	if row[2].Valid {
+		stats.Max = &row[2].String
+	}
+	stats.DistinctCount, _ = strconv.ParseInt(row[3].String, 10, 64)
+	stats.RowCount, _ = strconv.ParseInt(row[4].String, 10, 64)
+	nonNull, _ := strconv.ParseInt(row[5].String, 10, 64)
+	stats.NullCount = stats.RowCount - nonNull
+	if stats.RowCount > 0 {
+		fraction := float64(stats.NullCount) / float64(stats.RowCount)
+		stats.NullFraction = &fraction
+	}
+
+	if stats.DistinctCount > 0 && stats.DistinctCount <= maxTopValues {
+		statement = fmt.Sprintf(
+			"SELECT CAST(%[1]s AS STRING), COUNT(*) FROM %[2]s WHERE %[1]s IS NOT NULL GROUP BY %[1]s ORDER BY 2 DESC LIMIT %[3]d",
+			c, from, maxTopValues,
+		)
+		var values [][]string
+		err = check(statement)
+		if err == nil {
+			values, err = queryStrings(ctx, db, statement)
+		}
+		if err != nil {
+			logger.Info("Column Top Values Error", "err", err)
+		}
+		for _, value := range values {
+			count, _ := strconv.ParseInt(value[1], 10, 64)
+			stats.TopValues = append(stats.TopValues, columnValue{Value: value[0], Count: count})
+		}
+	}
+
+	stats.SuggestedFilters = suggestedFilters(stats, c)
+	return stats, nil
+}
+
+// suggestedFilters returns filter conditions on the column matching its values, most
+// selective first.
+func suggestedFilters(stats *columnStats, column string) []string {
+	filters := make([]string, 0)
+	if len(stats.TopValues) > 0 {
+		filters = append(filters, column+" = "+typedLiteral(stats.Type, stats.TopValues[0].Value))
+		if len(stats.TopValues) > 1 {
+			literals := make([]string, len(stats.TopValues))
+			for i, value := range stats.TopValues {
+				literals[i] = typedLiteral(stats.Type, value.Value)
+			}
+			filters = append(filters, column+" IN ("+strings.Join(literals, ", ")+")")
+		}
+	}
+	if stats.Min != nil && stats.Max != nil && orderedType(stats.Type) {
+		filters = append(filters, fmt.Sprintf("%s BETWEEN %s AND %s", column, typedLiteral(stats.Type, *stats.Min), typedLiteral(stats.Type, *stats.Max)))
+	}
+	if stats.NullCount > 0 {
+		filters = append(filters, column+" IS NOT NULL")
+	}
+	return filters
+}
+
+// orderedType reports whether ranges of values of the type are meaningful filters.
+func orderedType(typeName string) bool {
+	switch {
+	case typeName == "date", strings.HasPrefix(typeName, "timestamp"), strings.HasPrefix(typeName, "decimal"):
+		return true
+	}
+	switch typeName {
+	case "tinyint", "smallint", "int", "bigint", "float", "double":
+		return true
+	}
+	return false
+}
+
+// typedLiteral renders the value, formatted as string, as SQL literal of the type
+// returned by typeof.
+func typedLiteral(typeName string, value string) string {
+	switch {
+	case typeName == "date":
+		return "DATE " + quoteString(value)
+	case strings.HasPrefix(typeName, "timestamp"):
+		return "TIMESTAMP " + quoteString(value)
+	case orderedType(typeName), typeName == "boolean":
+		return value
+	}
+	return quoteString(value)
+}
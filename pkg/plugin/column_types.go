@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field types columns can be converted to by the column type overrides of a query.
+const (
+	columnTypeTime    = "time"
+	columnTypeNumber  = "number"
+	columnTypeString  = "string"
+	columnTypeBoolean = "boolean"
+)
+
+// epochMillisecondsThreshold is the smallest epoch treated as milliseconds when numbers
+// are converted to time, smaller ones are seconds. It is 1973 in milliseconds and the
+// year 5138 in seconds.
+const epochMillisecondsThreshold = 1e11
+
+// validateColumnTypes checks that the column type overrides of a query are supported.
+func validateColumnTypes(columnTypes map[string]string) error {
+	for column, columnType := range columnTypes {
+		switch strings.ToLower(columnType) {
+		case columnTypeTime, columnTypeNumber, columnTypeString, columnTypeBoolean:
+		default:
+			return fmt.Errorf("unsupported type %q of column %q, expected %s, %s, %s or %s", columnType, column, columnTypeTime, columnTypeNumber, columnTypeString, columnTypeBoolean)
+		}
+	}
+	return nil
+}
+
+// applyColumnTypes converts the columns of the frame to the field types of the
+// validated overrides by column name. Values which can't be converted are replaced by
+// null and reported as notices.
+func applyColumnTypes(frame *data.Frame, columnTypes map[string]string) {
+	invalid := make(invalidValues)
+	for i, field := range frame.Fields {
+		columnType, ok := columnTypes[field.Name]
+		if !ok {
+			continue
+		}
+		var convert func(v interface{}) (interface{}, error)
+		var converted *data.Field
+		switch strings.ToLower(columnType) {
+		case columnTypeTime:
+			converted = data.NewField(field.Name, field.Labels, make([]*time.Time, field.Len()))
+			convert = toTime
+		case columnTypeNumber:
+			converted = data.NewField(field.Name, field.Labels, make([]*float64, field.Len()))
+			convert = toNumber
+		case columnTypeString:
+			converted = data.NewField(field.Name, field.Labels, make([]*string, field.Len()))
+			convert = toString
+		default:
+			converted = data.NewField(field.Name, field.Labels, make([]*bool, field.Len()))
+			convert = toBoolean
+		}
+		converted.Config = field.Config
+		for row := 0; row < field.Len(); row++ {
+			v, ok := field.ConcreteAt(row)
+			if !ok {
+				continue
+			}
+			value, err := convert(v)
+			if err != nil {
+				invalid.add(field.Name, err)
+				continue
+			}
+			converted.SetConcrete(row, value)
+		}
+		frame.Fields[i] = converted
+	}
+	frame.AppendNotices(invalid.notices()...)
+}
+
+// toTime converts timestamps, epochs in seconds or milliseconds and numeric strings.
+func toTime(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return epochTime(f), nil
+		}
+		return parseTimestamp(v)
+	default:
+		f, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		return epochTime(f.(float64)), nil
+	}
+}
+
+func epochTime(epoch float64) time.Time {
+	if math.Abs(epoch) >= epochMillisecondsThreshold {
+		return time.UnixMilli(int64(epoch)).UTC()
+	}
+	seconds, fraction := math.Modf(epoch)
+	return time.Unix(int64(seconds), int64(fraction*1e9)).UTC()
+}
+
+// toNumber converts numbers, numeric strings, booleans and timestamps, which are
+// converted to epoch milliseconds.
+func toNumber(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return float64(1), nil
+		}
+		return float64(0), nil
+	case time.Time:
+		return float64(v.UnixMilli()), nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", v)
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("%T can't be converted to number", v)
+	}
+}
+
+func toString(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case json.RawMessage:
+		return string(v), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// toBoolean converts booleans, numbers, which are true unless zero, and strings like
+// true, false, 1 or 0.
+func toBoolean(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", v)
+		}
+		return b, nil
+	default:
+		f, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		return f.(float64) != 0, nil
+	}
+}
@@ -0,0 +1,255 @@
+package plugin
+
+import (
+	"database/sql"
+	"fmt"
+	dbsql "github.com/databricks/databricks-sql-go"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/grafana/grafana-plugin-sdk-go/build"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connectionSettings holds the options shared by all connection pools of a datasource
+// instance, independent of the credentials used.
+type connectionSettings struct {
+	hostname  string
+	port      int
+	path      string
+	transport http.RoundTripper
+	// downloadTransport is used to download results from presigned cloud storage URLs.
+	// It uses the proxies of transport, but not its TLS settings for the workspace.
+	downloadTransport http.RoundTripper
+	catalog           string
+	schema            string
+	// sessionParams are set on every session opened, i.e. timezone or ansi_mode.
+	sessionParams map[string]string
+	pool          poolSettings
+	// queryTimeout is enforced by the warehouse in addition to the context deadline.
+	queryTimeout time.Duration
+	// warehouses maps the names of additional warehouses to their HTTP path.
+	warehouses     map[string]string
+	userAgentEntry string
+	// cloudFetch downloads large results from cloud storage instead of fetching them
+	// through the connection.
+	cloudFetch         bool
+	maxDownloadThreads int
+}
+
+// defaultMaxDownloadThreads is the number of result files downloaded in parallel if
+// cloud fetch is enabled.
+const defaultMaxDownloadThreads = 10
+
+// downloadThreads returns the number of result files downloaded in parallel.
+func (c connectionSettings) downloadThreads() int {
+	if c.maxDownloadThreads <= 0 {
+		return defaultMaxDownloadThreads
+	}
+	return c.maxDownloadThreads
+}
+
+// warehouse is an additional SQL warehouse queries can select by name.
+type warehouse struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+func newWarehouseMap(warehouses []warehouse) map[string]string {
+	paths := make(map[string]string, len(warehouses))
+	for _, w := range warehouses {
+		if w.Name == "" || w.Path == "" {
+			continue
+		}
+		paths[w.Name] = strings.TrimPrefix(w.Path, "/")
+	}
+	return paths
+}
+
+// forWarehouse returns the connection settings of the named warehouse, an empty name
+// selects the default warehouse.
+func (c connectionSettings) forWarehouse(name string) (connectionSettings, error) {
+	if name == "" {
+		return c, nil
+	}
+	path, ok := c.warehouses[name]
+	if !ok {
+		return connectionSettings{}, fmt.Errorf("unknown warehouse %q, check the warehouses configured in the datasource settings", name)
+	}
+	c.path = path
+	return c, nil
+}
+
+// userAgentProduct identifies queries of the plugin in the Databricks query history.
+const userAgentProduct = "grafana-databricks"
+
+// newUserAgentEntry returns the user agent entry sent to Databricks, consisting of the
+// plugin name and version followed by the custom entry of the datasource settings.
+func newUserAgentEntry(custom string) string {
+	version := "dev"
+	if info, err := build.GetBuildInfo(); err == nil && info.Version != "" {
+		version = info.Version
+	}
+	entry := fmt.Sprintf("%s/%s", userAgentProduct, version)
+	if custom = strings.TrimSpace(custom); custom != "" {
+		entry = fmt.Sprintf("%s %s", entry, custom)
+	}
+	return entry
+}
+
+// defaultConnMaxIdleTime is used if no idle time is configured.
+const defaultConnMaxIdleTime = 6 * time.Hour
+
+// defaultMaxIdleConns is the default of database/sql.
+const defaultMaxIdleConns = 2
+
+// poolSettings configures the limits of a connection pool. Zero values keep the
+// defaults, negative values disable the respective limit.
+type poolSettings struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
+}
+
+func newPoolSettings(datasourceSettings *DatasourceSettings) poolSettings {
+	return poolSettings{
+		maxOpenConns:    datasourceSettings.MaxOpenConns,
+		maxIdleConns:    datasourceSettings.MaxIdleConns,
+		connMaxLifetime: time.Duration(datasourceSettings.ConnMaxLifetime) * time.Second,
+		connMaxIdleTime: time.Duration(datasourceSettings.ConnMaxIdleTime) * time.Second,
+	}
+}
+
+// idleConns returns the number of idle connections kept by the pool.
+func (p poolSettings) idleConns() int {
+	if p.maxIdleConns == 0 {
+		return defaultMaxIdleConns
+	}
+	return p.maxIdleConns
+}
+
+func (p poolSettings) apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.maxOpenConns)
+	db.SetMaxIdleConns(p.idleConns())
+	if p.connMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.connMaxLifetime)
+	}
+	switch {
+	case p.connMaxIdleTime > 0:
+		db.SetConnMaxIdleTime(p.connMaxIdleTime)
+	case p.connMaxIdleTime == 0:
+		db.SetConnMaxIdleTime(defaultConnMaxIdleTime)
+	}
+}
+
+// openDB opens a connection pool to the configured warehouse using the authenticator.
+func (c connectionSettings) openDB(authenticator auth.Authenticator) (*sql.DB, error) {
+	connector, err := dbsql.NewConnector(
+		dbsql.WithServerHostname(c.hostname),
+		dbsql.WithPort(c.port),
+		dbsql.WithHTTPPath(c.path),
+		dbsql.WithAuthenticator(authenticator),
+		dbsql.WithTransport(c.transport),
+		dbsql.WithInitialNamespace(c.catalog, c.schema),
+		dbsql.WithSessionParams(c.sessionParameters()),
+		dbsql.WithTimeout(c.queryTimeout),
+		dbsql.WithUserAgentEntry(c.userAgentEntry),
+		dbsql.WithCloudFetch(c.cloudFetch),
+		dbsql.WithMaxDownloadThreads(c.downloadThreads()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	c.pool.apply(db)
+	return db, nil
+}
+
+// poolSet holds the connection pools opened with one set of credentials, one for each
+// warehouse used. The pool of the default warehouse is opened right away, pools of
+// additional warehouses on first use.
+type poolSet struct {
+	connection    connectionSettings
+	authenticator *tokenAuthenticator
+
+	mu  sync.Mutex
+	dbs map[string]*sql.DB
+}
+
+func newPoolSet(connection connectionSettings, authenticator *tokenAuthenticator) (*poolSet, error) {
+	p := &poolSet{
+		connection:    connection,
+		authenticator: authenticator,
+		dbs:           make(map[string]*sql.DB),
+	}
+	_, err := p.db("")
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// db returns the pool of the named warehouse, an empty name selects the default warehouse.
+func (p *poolSet) db(warehouse string) (*sql.DB, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if db, ok := p.dbs[warehouse]; ok {
+		return db, nil
+	}
+	connection, err := p.connection.forWarehouse(warehouse)
+	if err != nil {
+		return nil, err
+	}
+	db, err := connection.openDB(p.authenticator)
+	if err != nil {
+		return nil, err
+	}
+	p.dbs[warehouse] = db
+	return db, nil
+}
+
+// all returns the pools opened so far by warehouse name.
+func (p *poolSet) all() map[string]*sql.DB {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dbs := make(map[string]*sql.DB, len(p.dbs))
+	for warehouse, db := range p.dbs {
+		dbs[warehouse] = db
+	}
+	return dbs
+}
+
+// contains reports whether db is one of the pools of the set.
+func (p *poolSet) contains(db *sql.DB) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pool := range p.dbs {
+		if pool == db {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *poolSet) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for warehouse, db := range p.dbs {
+		err := db.Close()
+		if err != nil {
+			logger.Info("DB Close Error", "warehouse", warehouse, "err", err)
+		}
+	}
+}
+
+// sessionParameters returns a copy of the configured session parameters, the driver
+// keeps a reference to the map and would otherwise share it between pools.
+func (c connectionSettings) sessionParameters() map[string]string {
+	params := make(map[string]string, len(c.sessionParams))
+	for key, value := range c.sessionParams {
+		params[key] = value
+	}
+	return params
+}
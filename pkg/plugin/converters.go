@@ -0,0 +1,340 @@
+package plugin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timezones TIMESTAMP_NTZ values can be interpreted in.
+const (
+	ntzTimezoneUTC       = "utc"
+	ntzTimezoneDashboard = "dashboard"
+)
+
+// conversionOptions control how Databricks types without a direct frame equivalent are
+// converted.
+type conversionOptions struct {
+	// decimalAsString keeps DECIMAL values as strings, float64 only has a precision of
+	// about 15 significant digits.
+	decimalAsString bool
+	// ntzTimezone is the configured timezone of TIMESTAMP_NTZ values, either
+	// ntzTimezoneUTC or ntzTimezoneDashboard.
+	ntzTimezone string
+	// ntzLocation is the location TIMESTAMP_NTZ values are interpreted in, nil for UTC.
+	ntzLocation *time.Location
+	// intervalFormat is the format INTERVAL values are converted to, either
+	// intervalFormatSeconds or intervalFormatISO.
+	intervalFormat string
+	// bigintAsString converts BIGINT values to strings, the frontend represents numbers
+	// as float64, so identifiers above 2^53 would be rounded.
+	bigintAsString bool
+}
+
+func newConversionOptions(settings *DatasourceSettings) conversionOptions {
+	return conversionOptions{
+		decimalAsString: settings.DecimalAsString,
+		ntzTimezone:     strings.ToLower(settings.TimestampNtzTimezone),
+	}
+}
+
+// inLocation returns the options for a query rendered in the location of the dashboard,
+// nil for UTC.
+func (o conversionOptions) inLocation(location *time.Location) conversionOptions {
+	if o.ntzTimezone == ntzTimezoneDashboard {
+		o.ntzLocation = location
+	}
+	return o
+}
+
+// ntzTime interprets the wall clock of a TIMESTAMP_NTZ value, which is parsed as UTC,
+// in the location of the options.
+func (o conversionOptions) ntzTime(t time.Time) time.Time {
+	if o.ntzLocation == nil {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), o.ntzLocation)
+}
+
+// converters returns the converters used to read the rows returned by the driver.
+// Values which couldn't be converted are added to invalid.
+func (o conversionOptions) converters(invalid invalidValues) []sqlutil.Converter {
+	converters := []sqlutil.Converter{dateConverter(invalid), complexTypeConverter, o.intervalConverter(), booleanConverter, tinyintConverter, smallintConverter}
+	if !o.decimalAsString {
+		converters = append(converters, decimalConverter)
+	}
+	if o.bigintAsString {
+		converters = append(converters, bigintConverter)
+	}
+	return converters
+}
+
+// invalidValues collects the values which couldn't be converted by column name. They
+// are replaced by null instead of failing the query, and reported as notices.
+type invalidValues map[string]*invalidColumn
+
+type invalidColumn struct {
+	count int
+	// err is the error of the first invalid value.
+	err error
+}
+
+func (v invalidValues) add(column string, err error) {
+	if c, ok := v[column]; ok {
+		c.count++
+		return
+	}
+	v[column] = &invalidColumn{count: 1, err: err}
+}
+
+// notices returns a warning for every column with invalid values.
+func (v invalidValues) notices() []data.Notice {
+	columns := make([]string, 0, len(v))
+	for column := range v {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	notices := make([]data.Notice, 0, len(columns))
+	for _, column := range columns {
+		c := v[column]
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("%d values of column %q couldn't be converted and were replaced by null: %s", c.count, column, c.err),
+		})
+	}
+	return notices
+}
+
+// timestampLayouts are the layouts of the dates and timestamps returned by Databricks.
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02"}
+
+// timestampRgx matches dates and timestamps with years outside of 0000 to 9999, which
+// Databricks renders with sign, i.e. +10000-01-01 or -0044-03-15 12:00:00.
+var timestampRgx = regexp.MustCompile(`^([+-]?\d{4,})-(\d{2})-(\d{2})(?:[T ](\d{2}):(\d{2}):(\d{2})(\.\d{1,9})?)?(Z|[+-]\d{2}:\d{2})?$`)
+
+// parseTimestamp parses a date or timestamp, with or without fractional seconds and
+// timezone. Values without timezone are parsed as UTC.
+func parseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range timestampLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	match := timestampRgx.FindStringSubmatch(value)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+	parts := make([]int, 6)
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(strings.TrimPrefix(match[i+1], "+"))
+	}
+	var nanoseconds int
+	if match[7] != "" {
+		fraction := match[7][1:] + strings.Repeat("0", 9-len(match[7][1:]))
+		nanoseconds, _ = strconv.Atoi(fraction)
+	}
+	location := time.UTC
+	if match[8] != "" && match[8] != "Z" {
+		offset, err := time.Parse("-07:00", match[8])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+		}
+		_, seconds := offset.Zone()
+		location = time.FixedZone("", seconds)
+	}
+	return time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], nanoseconds, location), nil
+}
+
+// dateConverter converts Databricks DATE columns to timestamps. Invalid values are
+// replaced by null and added to invalid.
+func dateConverter(invalid invalidValues) sqlutil.Converter {
+	return sqlutil.Converter{
+		Name:          "Databricks date to timestamp converter",
+		InputScanType: reflect.TypeOf(sql.NullString{}),
+		InputTypeName: "DATE",
+		FrameConverter: sqlutil.FrameConverter{
+			FieldType: data.FieldTypeNullableTime,
+			ConvertWithColumn: func(n interface{}, column sql.ColumnType) (interface{}, error) {
+				v := n.(*sql.NullString)
+
+				if !v.Valid {
+					return (*time.Time)(nil), nil
+				}
+
+				date, err := parseTimestamp(v.String)
+				if err != nil {
+					invalid.add(column.Name(), err)
+					return (*time.Time)(nil), nil
+				}
+				return &date, nil
+			},
+		},
+	}
+}
+
+// complexTypes are the Databricks types converted to JSON fields.
+var complexTypes = map[string]bool{"ARRAY": true, "MAP": true, "STRUCT": true, "VARIANT": true}
+
+// complexTypeConverter converts Databricks ARRAY, MAP, STRUCT and VARIANT columns, which
+// the driver returns as JSON strings, to JSON fields. The driver doesn't know the
+// VARIANT type and reports it as USER_DEFINED if the warehouse doesn't send it as
+// string.
+var complexTypeConverter = sqlutil.Converter{
+	Name:           "Databricks complex type to JSON converter",
+	InputScanType:  reflect.TypeOf(sql.NullString{}),
+	InputTypeRegex: regexp.MustCompile(`^(ARRAY|MAP|STRUCT|VARIANT|USER_DEFINED)$`),
+	FrameConverter: sqlutil.FrameConverter{
+		FieldType: data.FieldTypeNullableJSON,
+		ConverterFunc: func(n interface{}) (interface{}, error) {
+			v := n.(*sql.NullString)
+
+			if !v.Valid {
+				return (*json.RawMessage)(nil), nil
+			}
+
+			value := jsonValue(v.String)
+			return &value, nil
+		},
+	},
+}
+
+// jsonValue returns the JSON of a complex type value. Values which aren't valid JSON are
+// returned as JSON string, so they can still be displayed.
+func jsonValue(value string) json.RawMessage {
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	b, _ := json.Marshal(value)
+	return b
+}
+
+// jsonField converts a string field of complex type values to a JSON field.
+func jsonField(field *data.Field) *data.Field {
+	converted := data.NewFieldFromFieldType(data.FieldTypeNullableJSON, field.Len())
+	converted.Name = field.Name
+	converted.Labels = field.Labels
+	for i := 0; i < field.Len(); i++ {
+		if v, ok := field.ConcreteAt(i); ok {
+			value := jsonValue(v.(string))
+			converted.Set(i, &value)
+		}
+	}
+	return converted
+}
+
+// bigintConverter converts Databricks BIGINT columns to strings.
+var bigintConverter = sqlutil.Converter{
+	Name:          "Databricks bigint to string converter",
+	InputScanType: reflect.TypeOf(sql.NullInt64{}),
+	InputTypeName: "BIGINT",
+	FrameConverter: sqlutil.FrameConverter{
+		FieldType: data.FieldTypeNullableString,
+		ConverterFunc: func(n interface{}) (interface{}, error) {
+			v := n.(*sql.NullInt64)
+
+			if !v.Valid {
+				return (*string)(nil), nil
+			}
+
+			s := strconv.FormatInt(v.Int64, 10)
+			return &s, nil
+		},
+	},
+}
+
+// booleanConverter converts Databricks BOOLEAN columns to nullable bool fields. The
+// driver doesn't report the nullability of columns, so the default converter would fail
+// on null values.
+var booleanConverter = sqlutil.Converter{
+	Name:          "Databricks boolean converter",
+	InputScanType: reflect.TypeOf(sql.NullBool{}),
+	InputTypeName: "BOOLEAN",
+	FrameConverter: sqlutil.FrameConverter{
+		FieldType: data.FieldTypeNullableBool,
+		ConverterFunc: func(n interface{}) (interface{}, error) {
+			v := n.(*sql.NullBool)
+
+			if !v.Valid {
+				return (*bool)(nil), nil
+			}
+
+			b := v.Bool
+			return &b, nil
+		},
+	},
+}
+
+// tinyintConverter converts Databricks TINYINT columns to nullable int8 fields.
+var tinyintConverter = sqlutil.Converter{
+	Name:          "Databricks tinyint converter",
+	InputScanType: reflect.TypeOf(sql.NullInt16{}),
+	InputTypeName: "TINYINT",
+	FrameConverter: sqlutil.FrameConverter{
+		FieldType: data.FieldTypeNullableInt8,
+		ConverterFunc: func(n interface{}) (interface{}, error) {
+			v := n.(*sql.NullInt16)
+
+			if !v.Valid {
+				return (*int8)(nil), nil
+			}
+
+			i := int8(v.Int16)
+			return &i, nil
+		},
+	},
+}
+
+// smallintConverter converts Databricks SMALLINT columns to nullable int16 fields.
+var smallintConverter = sqlutil.Converter{
+	Name:          "Databricks smallint converter",
+	InputScanType: reflect.TypeOf(sql.NullInt16{}),
+	InputTypeName: "SMALLINT",
+	FrameConverter: sqlutil.FrameConverter{
+		FieldType: data.FieldTypeNullableInt16,
+		ConverterFunc: func(n interface{}) (interface{}, error) {
+			v := n.(*sql.NullInt16)
+
+			if !v.Valid {
+				return (*int16)(nil), nil
+			}
+
+			i := v.Int16
+			return &i, nil
+		},
+	},
+}
+
+// decimalConverter converts Databricks DECIMAL columns, which the driver returns as
+// strings, to floats.
+var decimalConverter = sqlutil.Converter{
+	Name:          "Databricks decimal to float converter",
+	InputScanType: reflect.TypeOf(sql.NullString{}),
+	InputTypeName: "DECIMAL",
+	FrameConverter: sqlutil.FrameConverter{
+		FieldType: data.FieldTypeNullableFloat64,
+		ConverterFunc: func(n interface{}) (interface{}, error) {
+			v := n.(*sql.NullString)
+
+			if !v.Valid {
+				return (*float64)(nil), nil
+			}
+
+			f, err := strconv.ParseFloat(v.String, 64)
+			if err != nil {
+				return (*float64)(nil), err
+			}
+			return &f, nil
+		},
+	},
+}
@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"strings"
+)
+
+// credentialMapping maps Grafana organizations and users to dedicated Databricks
+// credentials, so one datasource can enforce org or user scoped data access. Grafana
+// does not pass team memberships to plugins, therefore mappings match on the org ID,
+// the org role and the login of the user. Empty fields match everything.
+//
+// Secrets of a mapping are stored in the secure json data using the keys
+// credentialMappings.<index>.token and credentialMappings.<index>.clientSecret.
+type credentialMapping struct {
+	OrgId                int64    `json:"orgId"`
+	Roles                []string `json:"roles"`
+	Users                []string `json:"users"`
+	AuthenticationMethod string   `json:"authenticationMethod"`
+	TenantId             string   `json:"tenantId"`
+	ClientId             string   `json:"clientId"`
+}
+
+// matches reports whether the mapping applies to the org and user of the request.
+func (m credentialMapping) matches(pCtx backend.PluginContext) bool {
+	if m.OrgId != 0 && m.OrgId != pCtx.OrgID {
+		return false
+	}
+	if len(m.Roles) > 0 && (pCtx.User == nil || !containsFold(m.Roles, pCtx.User.Role)) {
+		return false
+	}
+	if len(m.Users) > 0 && (pCtx.User == nil || !containsFold(m.Users, pCtx.User.Login)) {
+		return false
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// credentialMappingPool holds the connection pools opened for a credential mapping.
+type credentialMappingPool struct {
+	mapping credentialMapping
+	pools   *poolSet
+}
+
+func newCredentialMappingPool(connection connectionSettings, mapping credentialMapping, index int, secureJSONData map[string]string) (*credentialMappingPool, error) {
+	secretKey := func(name string) string {
+		return secureJSONData[fmt.Sprintf("credentialMappings.%d.%s", index, name)]
+	}
+	creds := credentials{
+		AuthenticationMethod: mapping.AuthenticationMethod,
+		TenantId:             mapping.TenantId,
+		ClientId:             mapping.ClientId,
+		Token:                secretKey("token"),
+		ClientSecret:         secretKey("clientSecret"),
+		ServiceAccountKey:    secretKey("serviceAccountKey"),
+	}
+	provider, err := newTokenProvider(connection.hostname, connection.transport, creds)
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		provider = &staticTokenProvider{token: creds.Token}
+	}
+	pools, err := newPoolSet(connection, newTokenAuthenticator(provider))
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentialMappingPool{
+		mapping: mapping,
+		pools:   pools,
+	}, nil
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"time"
+)
+
+// limitableKeywords are the leading keywords of queries a LIMIT can be appended to.
+var limitableKeywords = []string{"SELECT", "WITH", "FROM", "VALUES", "TABLE"}
+
+// maxDataPointsInterval returns the interval of the query, increased if needed so the
+// time range is split into at most MaxDataPoints buckets by the time window macros.
+func maxDataPointsInterval(query backend.DataQuery) time.Duration {
+	if query.MaxDataPoints <= 0 {
+		return query.Interval
+	}
+	interval := query.TimeRange.Duration() / time.Duration(query.MaxDataPoints)
+	// Intervals are rendered in whole seconds
+	interval = interval.Round(time.Second)
+	if interval > query.Interval {
+		return interval
+	}
+	return query.Interval
+}
+
+// roundTimeRange extends the time range to multiples of the interval, the start is
+// rounded down and the end up, so queries of a relative time range issued shortly after
+// each other use the same time range.
+func roundTimeRange(timeRange backend.TimeRange, interval time.Duration) backend.TimeRange {
+	from := timeRange.From.Truncate(interval)
+	to := timeRange.To.Truncate(interval)
+	if to.Before(timeRange.To) {
+		to = to.Add(interval)
+	}
+	return backend.TimeRange{From: from, To: to}
+}
+
+// limitStatement appends a LIMIT to a query which doesn't limit its result itself.
+// Statements other than queries are returned unchanged.
+func limitStatement(statement string, limit int64) string {
+	if limit <= 0 || !isLimitable(statement) || hasTopLevelKeyword(statement, "LIMIT") {
+		return statement
+	}
+	// The LIMIT is added on a new line, so it isn't part of a trailing comment
+	return fmt.Sprintf("%s\nLIMIT %d", statement, limit)
+}
+
+// isLimitable reports whether the statement is a query, which can be limited or used as
+// subquery.
+func isLimitable(statement string) bool {
+	keyword := statementKeyword(statement)
+	for _, limitable := range limitableKeywords {
+		if keyword == limitable {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaOnlyStatement wraps a query so it returns the columns of its result without any
+// rows, which the warehouse resolves without scanning data. Statements other than
+// queries are returned unchanged.
+func schemaOnlyStatement(statement string) string {
+	if !isLimitable(statement) {
+		return statement
+	}
+	return fmt.Sprintf("SELECT * FROM (\n%s\n) AS schema_only\nLIMIT 0", statement)
+}
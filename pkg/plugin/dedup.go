@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"sync"
+	"time"
+)
+
+// defaultDedupWindow is the time the result of a query is shared with identical queries
+// once it finished, if not configured.
+const defaultDedupWindow = 5 * time.Second
+
+// dedupDeadlineTolerance is the time the deadline of a query can exceed the deadline of a
+// running execution it joins, so identical queries of a dashboard arriving at slightly
+// different times share the execution.
+const dedupDeadlineTolerance = time.Second
+
+// sharedQuery is a query execution shared by identical queries.
+type sharedQuery struct {
+	done     chan struct{}
+	response backend.DataResponse
+	finished time.Time
+	// deadline is the deadline of the query starting the execution, zero if it has none.
+	deadline time.Time
+	// waiters is the number of queries waiting for the result, the execution is
+	// cancelled once all of them gave up.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// queryDeduplicator executes identical queries, i.e. panels of a dashboard using the
+// same SQL and time range, only once and shares the result with all of them. Results are
+// also shared with identical queries arriving within the dedup window after the
+// execution finished.
+type queryDeduplicator struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	queries map[string]*sharedQuery
+}
+
+// newQueryDeduplicator creates a deduplicator for the configured window in seconds.
+// Zero uses the default window, a negative value disables deduplication and nil is
+// returned.
+func newQueryDeduplicator(windowSeconds int) *queryDeduplicator {
+	if windowSeconds < 0 {
+		return nil
+	}
+	window := time.Duration(windowSeconds) * time.Second
+	if window == 0 {
+		window = defaultDedupWindow
+	}
+	return &queryDeduplicator{window: window, queries: make(map[string]*sharedQuery)}
+}
+
+// dedupKey identifies identical queries. Queries are only shared between requests of
+// the same org and user, which use the same credentials. The ids of the query, its
+// dashboard and panel are ignored.
+func dedupKey(pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) (string, error) {
+	qm.QueryId = ""
+	qm.DashboardUid = ""
+	qm.PanelId = 0
+	b, err := json.Marshal(struct {
+		Query         queryModel
+		From          time.Time
+		To            time.Time
+		Interval      time.Duration
+		MaxDataPoints int64
+	}{qm, query.TimeRange.From, query.TimeRange.To, query.Interval, query.MaxDataPoints})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return runningQueryKey(pCtx, hex.EncodeToString(sum[:])), nil
+}
+
+// do returns the result of run, sharing it with identical queries. run is executed with
+// a context which is cancelled once all queries waiting for the result are done. Queries
+// only join a running execution whose deadline isn't earlier than their own. Every
+// query receives its own copy of the frames, so their metadata can be modified.
+func (q *queryDeduplicator) do(ctx context.Context, key string, run func(ctx context.Context) backend.DataResponse) backend.DataResponse {
+	q.mu.Lock()
+	for k, shared := range q.queries {
+		if !shared.finished.IsZero() && time.Since(shared.finished) > q.window {
+			delete(q.queries, k)
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	shared, ok := q.queries[key]
+	if ok && !shared.accepts(deadline) {
+		// The running execution would be cancelled before the deadline of the query, i.e.
+		// it was started by an alert evaluation with a shorter timeout. A new execution
+		// replaces it for identical queries arriving later.
+		ok = false
+	}
+	if ok {
+		logger.Debug("Sharing result of identical query", "key", key)
+	} else {
+		shared = &sharedQuery{done: make(chan struct{}), deadline: deadline}
+		var runCtx context.Context
+		runCtx, shared.cancel = context.WithCancel(context.Background())
+		q.queries[key] = shared
+		go func() {
+			response := run(runCtx)
+			q.mu.Lock()
+			shared.response = response
+			shared.finished = time.Now()
+			close(shared.done)
+			q.mu.Unlock()
+			shared.cancel()
+		}()
+	}
+	shared.waiters++
+	q.mu.Unlock()
+
+	select {
+	case <-shared.done:
+		q.mu.Lock()
+		shared.waiters--
+		q.mu.Unlock()
+		return copyResponse(shared.response)
+	case <-ctx.Done():
+		q.mu.Lock()
+		shared.waiters--
+		if shared.waiters == 0 {
+			shared.cancel()
+			// Identical queries arriving later must not receive the cancelled result
+			if q.queries[key] == shared {
+				delete(q.queries, key)
+			}
+		}
+		q.mu.Unlock()
+		return backend.DataResponse{Error: fmt.Errorf("query was cancelled: %w", ctx.Err())}
+	}
+}
+
+// accepts reports whether a query with the deadline can wait for the result of the
+// execution, which is the case once it finished or if the execution isn't cancelled
+// before the deadline of the query.
+func (s *sharedQuery) accepts(deadline time.Time) bool {
+	if !s.finished.IsZero() || s.deadline.IsZero() {
+		return true
+	}
+	return !deadline.IsZero() && !deadline.After(s.deadline.Add(dedupDeadlineTolerance))
+}
+
+// copyResponse returns the response with copies of its frames and their metadata. The
+// fields are shared, they must not be modified.
+func copyResponse(response backend.DataResponse) backend.DataResponse {
+	if response.Frames == nil {
+		return response
+	}
+	frames := make(data.Frames, len(response.Frames))
+	for i, frame := range response.Frames {
+		frames[i] = copyFrame(frame)
+	}
+	response.Frames = frames
+	return response
+}
+
+// copyFrame returns a copy of the frame and its metadata sharing the fields.
+func copyFrame(frame *data.Frame) *data.Frame {
+	if frame == nil {
+		return nil
+	}
+	c := *frame
+	c.Fields = append([]*data.Field(nil), frame.Fields...)
+	if frame.Meta != nil {
+		meta := *frame.Meta
+		meta.Notices = append([]data.Notice(nil), frame.Meta.Notices...)
+		meta.Stats = append([]data.QueryStat(nil), frame.Meta.Stats...)
+		c.Meta = &meta
+	}
+	return &c
+}
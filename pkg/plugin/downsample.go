@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"strings"
+	"time"
+)
+
+// downsampleAggregations are the aggregate functions values can be downsampled with.
+var downsampleAggregations = []string{"avg", "sum", "min", "max", "count"}
+
+// downsampleStatement wraps a query so its rows are aggregated into windows of the
+// interval. The schema of the query is fetched first: the first time column is
+// truncated to the window, numeric columns are aggregated and all other columns are
+// grouped by, so they remain available as labels.
+func (d *Datasource) downsampleStatement(ctx context.Context, db *sql.DB, executor statementExecutor, statement string, interval time.Duration, aggregation string) (string, error) {
+	if aggregation == "" {
+		aggregation = "avg"
+	}
+	aggregation = strings.ToLower(aggregation)
+	supported := false
+	for _, a := range downsampleAggregations {
+		supported = supported || a == aggregation
+	}
+	if !supported {
+		return "", fmt.Errorf("unsupported aggregation %q, expected one of %s", aggregation, strings.Join(downsampleAggregations, ", "))
+	}
+	if !isLimitable(statement) {
+		return "", fmt.Errorf("only queries can be downsampled")
+	}
+
+	subquery := fmt.Sprintf("(\n%s\n) AS downsampled", statement)
+	var schema *data.Frame
+	_, err := d.execute(ctx, db, statement, func() error {
+		var err error
+		schema, err = executor.query(ctx, schemaOnlyStatement(statement), 0)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	seconds := int64(interval.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	var columns, groups []string
+	timeFound, valueFound := false, false
+	for _, field := range schema.Fields {
+		column := quoteIdentifier(field.Name)
+		switch {
+		case !timeFound && field.Type().Time():
+			timeFound = true
+			columns = append(columns, fmt.Sprintf("%s AS %s", timeGroupExpression(column, time.Duration(seconds)*time.Second, nil), column))
+			groups = append(groups, fmt.Sprint(len(columns)))
+		case field.Type().Numeric():
+			valueFound = true
+			columns = append(columns, fmt.Sprintf("%s(%s) AS %s", aggregation, column, column))
+		default:
+			columns = append(columns, column)
+			groups = append(groups, fmt.Sprint(len(columns)))
+		}
+	}
+	if !timeFound || !valueFound {
+		return "", fmt.Errorf("downsampling requires a time column and at least one numeric column")
+	}
+
+	return fmt.Sprintf("SELECT %s\nFROM %s\nGROUP BY %s\nORDER BY 1", strings.Join(columns, ", "), subquery, strings.Join(groups, ", ")), nil
+}
+
+// quoteIdentifier renders a column name as quoted identifier.
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
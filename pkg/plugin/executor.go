@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/databricks/databricks-sql-go/driverctx"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+)
+
+// statementExecutor executes the statements of a query, either using the driver or the
+// Statement Execution API.
+type statementExecutor interface {
+	// exec executes a statement, discarding its result.
+	exec(ctx context.Context, statement string) error
+	// query executes a statement and returns its result as frame. At most maxRows rows
+	// are fetched, if the result has more rows a notice is added to the frame. A negative
+	// value fetches all rows.
+	query(ctx context.Context, statement string, maxRows int64) (*data.Frame, error)
+}
+
+// driverExecutor executes statements on a connection pool of the driver. Statements are
+// not prepared: the driver only prepares them on the client, every execution of a
+// prepared statement still submits the complete SQL text, so caching them wouldn't save
+// any work on the warehouse.
+type driverExecutor struct {
+	db         *sql.DB
+	conversion conversionOptions
+}
+
+func (e driverExecutor) exec(ctx context.Context, statement string) error {
+	_, err := e.db.ExecContext(driverctx.NewContextWithQueryIdCallback(ctx, func(id string) {
+		statementStarted(ctx, id)
+	}), statement)
+	return err
+}
+
+func (e driverExecutor) query(ctx context.Context, statement string, maxRows int64) (*data.Frame, error) {
+	var queryId string
+	rows, err := e.db.QueryContext(driverctx.NewContextWithQueryIdCallback(ctx, func(id string) {
+		queryId = id
+		statementStarted(ctx, id)
+	}), statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invalid := make(invalidValues)
+	frame, err := sqlutil.FrameFromRows(rows, maxRows, e.conversion.converters(invalid)...)
+	if err != nil {
+		logger.Info("FrameFromRows", "err", err)
+		return nil, err
+	}
+	frame.AppendNotices(invalid.notices()...)
+	frameStats(frame).QueryId = queryId
+	return frame, nil
+}
+
+// executor returns the executor for the queries on the named warehouse. db is the
+// connection pool selected for the warehouse.
+func (d *Datasource) executor(pools *requestPools, warehouse string, db *sql.DB, conversion conversionOptions) (statementExecutor, error) {
+	if d.statementAPI == nil {
+		return driverExecutor{db: db, conversion: conversion}, nil
+	}
+	connection, err := d.connection.forWarehouse(warehouse)
+	if err != nil {
+		return nil, err
+	}
+	warehouseId := warehouseIdFromPath(connection.path)
+	if warehouseId == "" {
+		return nil, fmt.Errorf("the Statement Execution API requires the HTTP Path of a SQL warehouse, got %q", connection.path)
+	}
+	return &statementAPIExecutor{
+		client:        d.statementAPI,
+		authenticator: pools.authenticator(warehouse),
+		warehouseId:   warehouseId,
+		conversion:    conversion,
+		catalog:       d.statementAPI.catalog,
+		schema:        d.statementAPI.schema,
+	}, nil
+}
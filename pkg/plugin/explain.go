@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// planErrorRgx matches plans returned by EXPLAIN for statements which failed to
+// analyze, Databricks returns those as plan instead of failing the statement.
+var planErrorRgx = regexp.MustCompile(`Error occurred during query planning|AnalysisException|ParseException`)
+
+// unexplainedKeywords are the leading keywords of statements EXPLAIN doesn't support.
+var unexplainedKeywords = []string{"SET", "RESET", "USE"}
+
+type validateRequestBody struct {
+	RawSqlQuery string                 `json:"rawSqlQuery"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	Warehouse   string                 `json:"warehouse"`
+	// From and To are the time range used for macros in epoch milliseconds, defaulting
+	// to the last hour.
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+	// IntervalMs is the interval used for macros, defaulting to one minute.
+	IntervalMs int64 `json:"intervalMs"`
+	// Timezone the time macros are rendered in, UTC if empty.
+	Timezone             string `json:"timezone"`
+	FiscalYearStartMonth int    `json:"fiscalYearStartMonth"`
+	DashboardUid         string `json:"dashboardUid"`
+}
+
+// dataQuery returns the query the macros of the request are expanded for.
+func (b validateRequestBody) dataQuery() backend.DataQuery {
+	to := time.Now()
+	if b.To > 0 {
+		to = time.UnixMilli(b.To)
+	}
+	from := to.Add(-time.Hour)
+	if b.From > 0 {
+		from = time.UnixMilli(b.From)
+	}
+	interval := time.Minute
+	if b.IntervalMs > 0 {
+		interval = time.Duration(b.IntervalMs) * time.Millisecond
+	}
+	return backend.DataQuery{
+		TimeRange: backend.TimeRange{From: from, To: to},
+		Interval:  interval,
+	}
+}
+
+type statementValidation struct {
+	Statement string `json:"statement"`
+	Plan      string `json:"plan,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type validateResponseBody struct {
+	Valid      bool                  `json:"valid"`
+	Error      string                `json:"error,omitempty"`
+	Statements []statementValidation `json:"statements"`
+}
+
+// validateQuery handles the resource request validating a query without executing it.
+// Every statement is run with EXPLAIN, which reports syntax and analysis errors, i.e.
+// unknown tables or columns, and returns the plan without scanning data.
+func (d *Datasource) validateQuery(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, pools *requestPools) error {
+	var body validateRequestBody
+	err := json.Unmarshal(req.Body, &body)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+
+	response := validateResponseBody{Statements: make([]statementValidation, 0)}
+	err = d.explain(ctx, pools, body, &response)
+	if err != nil {
+		response.Error = err.Error()
+	}
+	response.Valid = response.Error == ""
+	for _, statement := range response.Statements {
+		if statement.Error != "" {
+			response.Valid = false
+		}
+	}
+
+	jsonBody, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
+
+// explain prepares the query like it would be executed and explains its statements.
+// Errors of single statements are added to the response, errors preventing the
+// validation are returned.
+func (d *Datasource) explain(ctx context.Context, pools *requestPools, body validateRequestBody, response *validateResponseBody) error {
+	queryString, err := d.expandQuery(pools.pCtx, body)
+	if err != nil {
+		return err
+	}
+	err = d.checkStatements(queryString)
+	if err != nil {
+		return err
+	}
+
+	timeout := requestTimeout(ctx, d.queryTimeout)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	db, err := pools.db(body.Warehouse)
+	if err != nil {
+		return err
+	}
+	executor, err := d.executor(pools, body.Warehouse, db, d.conversion)
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range splitStatements(queryString) {
+		validation := statementValidation{Statement: strings.TrimSpace(statement)}
+		if isUnexplained(statement) {
+			response.Statements = append(response.Statements, validation)
+			continue
+		}
+		explainStatement := statement
+		if statementKeyword(statement) != "EXPLAIN" {
+			explainStatement = "EXPLAIN " + statement
+		}
+		frame, err := executor.query(ctx, explainStatement, -1)
+		if err != nil {
+			validation.Error = contextError(ctx, timeout, err).Error()
+		} else {
+			validation.Plan = framePlan(frame)
+			if planErrorRgx.MatchString(validation.Plan) {
+				validation.Error = validation.Plan
+				validation.Plan = ""
+			}
+		}
+		response.Statements = append(response.Statements, validation)
+	}
+	return nil
+}
+
+// expandQuery replaces the macros and parameters of the query of the request.
+func (d *Datasource) expandQuery(pCtx backend.PluginContext, body validateRequestBody) (string, error) {
+	options, err := d.macroOptions(body.Timezone, body.FiscalYearStartMonth)
+	if err != nil {
+		return "", err
+	}
+	options.variables = globalVariables(pCtx, body.DashboardUid)
+	queryString, err := replaceMacros(body.RawSqlQuery, body.dataQuery(), options)
+	if err != nil {
+		return "", err
+	}
+	return bindParameters(queryString, body.Parameters)
+}
+
+func isUnexplained(statement string) bool {
+	keyword := statementKeyword(statement)
+	for _, unexplained := range unexplainedKeywords {
+		if keyword == unexplained {
+			return true
+		}
+	}
+	return false
+}
+
+// framePlan joins the string values of the first column of the EXPLAIN result.
+func framePlan(frame *data.Frame) string {
+	if len(frame.Fields) == 0 {
+		return ""
+	}
+	field := frame.Fields[0]
+	lines := make([]string, 0, field.Len())
+	for i := 0; i < field.Len(); i++ {
+		switch v := field.At(i).(type) {
+		case string:
+			lines = append(lines, v)
+		case *string:
+			if v != nil {
+				lines = append(lines, *v)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"strconv"
+)
+
+// flattenStructs replaces JSON fields of objects, i.e. STRUCT columns, by one field per
+// nested key named in dot notation, i.e. device.id and device.firmware. Arrays are kept
+// as JSON. The type of the fields is inferred from the values, fields mixing types are
+// kept as JSON.
+func flattenStructs(frame *data.Frame) {
+	var fields []*data.Field
+	for _, field := range frame.Fields {
+		flattened, ok := flattenField(field)
+		if !ok {
+			fields = append(fields, field)
+			continue
+		}
+		fields = append(fields, flattened...)
+	}
+	frame.Fields = fields
+}
+
+// flattenField returns the flattened fields of a JSON field of objects, or false if the
+// field contains other values.
+func flattenField(field *data.Field) ([]*data.Field, bool) {
+	if field.Type() != data.FieldTypeNullableJSON && field.Type() != data.FieldTypeJSON {
+		return nil, false
+	}
+	paths := objectPaths{seen: make(map[string]bool)}
+	rows := make([]map[string]json.RawMessage, field.Len())
+	for row := 0; row < field.Len(); row++ {
+		v, ok := field.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		raw := bytes.TrimSpace(v.(json.RawMessage))
+		if len(raw) == 0 || raw[0] != '{' {
+			return nil, false
+		}
+		rows[row] = make(map[string]json.RawMessage)
+		if err := flattenObject(field.Name+".", raw, rows[row], &paths); err != nil {
+			return nil, false
+		}
+	}
+	if len(paths.order) == 0 {
+		return nil, false
+	}
+
+	fields := make([]*data.Field, 0, len(paths.order))
+	for _, path := range paths.order {
+		kind := byte(0)
+		for _, values := range rows {
+			value := values[path]
+			if len(value) == 0 || value[0] == 'n' {
+				continue
+			}
+			k := jsonKind(value)
+			if kind != 0 && kind != k {
+				k = '['
+			}
+			kind = k
+		}
+
+		var flattened *data.Field
+		switch kind {
+		case '"':
+			flattened = data.NewField(path, field.Labels, make([]*string, len(rows)))
+		case 't':
+			flattened = data.NewField(path, field.Labels, make([]*bool, len(rows)))
+		case '0':
+			flattened = data.NewField(path, field.Labels, make([]*float64, len(rows)))
+		default:
+			flattened = data.NewField(path, field.Labels, make([]*json.RawMessage, len(rows)))
+		}
+		for row, values := range rows {
+			value := values[path]
+			if len(value) == 0 || value[0] == 'n' {
+				continue
+			}
+			switch kind {
+			case '"':
+				var s string
+				if json.Unmarshal(value, &s) == nil {
+					flattened.SetConcrete(row, s)
+				}
+			case 't':
+				flattened.SetConcrete(row, value[0] == 't')
+			case '0':
+				if f, err := strconv.ParseFloat(string(value), 64); err == nil {
+					flattened.SetConcrete(row, f)
+				}
+			default:
+				flattened.SetConcrete(row, value)
+			}
+		}
+		fields = append(fields, flattened)
+	}
+	return fields, true
+}
+
+// objectPaths are the paths of the values of flattened objects, in the order of their
+// first occurrence.
+type objectPaths struct {
+	order []string
+	seen  map[string]bool
+}
+
+func (p *objectPaths) add(path string) {
+	if !p.seen[path] {
+		p.seen[path] = true
+		p.order = append(p.order, path)
+	}
+}
+
+// flattenObject adds the values of a JSON object to values by their path, nested
+// objects are flattened recursively.
+func flattenObject(prefix string, raw json.RawMessage, values map[string]json.RawMessage, paths *objectPaths) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		path := prefix + token.(string)
+		if value[0] == '{' {
+			if err := flattenObject(path+".", value, values, paths); err != nil {
+				return err
+			}
+			continue
+		}
+		paths.add(path)
+		values[path] = value
+	}
+	return nil
+}
+
+// jsonKind returns the first character of a JSON value, with booleans reported as 't'
+// and numbers as '0'.
+func jsonKind(value json.RawMessage) byte {
+	switch value[0] {
+	case '"', '{', '[':
+		return value[0]
+	case 't', 'f':
+		return 't'
+	default:
+		return '0'
+	}
+}
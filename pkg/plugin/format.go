@@ -0,0 +1,312 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Formats of the query result.
+const (
+	formatTable      = "table"
+	formatTimeSeries = "time_series"
+	formatLogs       = "logs"
+)
+
+// Column names used as body and severity of log lines, in order of preference.
+var (
+	logBodyColumns     = []string{"body", "message", "msg", "line", "log"}
+	logSeverityColumns = []string{"severity", "level", "log_level"}
+)
+
+// timeColumnNames are the names of columns preferred as time column of time series.
+var timeColumnNames = []string{"time", "timestamp", "ts", "datetime", "event_time", "date"}
+
+// shapeFrame converts the frame returned by a statement to the format of the query. If
+// no format is set, long frames are converted to wide ones if enabled in the query
+// settings.
+func shapeFrame(frame *data.Frame, qm queryModel) (*data.Frame, error) {
+	switch qm.Format {
+	case "":
+		// Only long frames converted to wide ones are ordered by time, other frames keep
+		// the order and columns of the query, i.e. tables of the latest rows
+		if qm.QuerySettings.ConvertLongToWide && frame.TimeSeriesSchema().Type == data.TimeSeriesTypeLong {
+			frame = sortByTime(frame)
+		}
+		// Frames without fields are returned by statements without result
+		if qm.QuerySettings.ConvertLongToWide && len(frame.Fields) > 0 && frame.TimeSeriesSchema().Type != data.TimeSeriesTypeWide {
+			wideFrame, err := longToWide(frame, qm.QuerySettings)
+			if err != nil {
+				logger.Info("LongToWide conversion error", "err", err)
+				severity := data.NoticeSeverityWarning
+				if errors.Is(err, errNoTimeColumn) {
+					severity = data.NoticeSeverityInfo
+				}
+				frame.AppendNotices(data.Notice{
+					Severity: severity,
+					Text:     fmt.Sprintf("The result was not converted from long to wide: %s. Disable Convert Long To Wide in the advanced options if the result is a table.", err),
+				})
+				return frame, nil
+			}
+			frame = wideFrame
+		}
+		if frame.TimeSeriesSchema().Type == data.TimeSeriesTypeWide {
+			setFrameType(frame, data.FrameTypeTimeSeriesWide)
+		}
+		return frame, nil
+	case formatTable:
+		setPreferredVisualization(frame, data.VisTypeTable)
+		return frame, nil
+	case formatTimeSeries:
+		return timeSeriesFrame(sortByTime(frame), qm.QuerySettings)
+	case formatLogs:
+		return logsFrame(frame)
+	default:
+		return nil, fmt.Errorf("unsupported format %q, expected %q, %q or %q", qm.Format, formatTable, formatTimeSeries, formatLogs)
+	}
+}
+
+func setPreferredVisualization(frame *data.Frame, visType data.VisType) {
+	if frame.Meta == nil {
+		frame.SetMeta(&data.FrameMeta{})
+	}
+	frame.Meta.PreferredVisualization = visType
+}
+
+func setFrameType(frame *data.Frame, frameType data.FrameType) {
+	if frame.Meta == nil {
+		frame.SetMeta(&data.FrameMeta{})
+	}
+	frame.Meta.Type = frameType
+}
+
+// timeColumn returns the index of the time column of the frame, or -1 if there is none.
+// Time columns named like time or timestamp are preferred. If the frame has no time
+// column, a string column with such a name is converted to time if all its values are
+// timestamps.
+func timeColumn(frame *data.Frame) int {
+	timeIndices := frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+	for _, i := range timeIndices {
+		if hasName(frame.Fields[i], timeColumnNames) {
+			return i
+		}
+	}
+	if len(timeIndices) > 0 {
+		return timeIndices[0]
+	}
+
+	for i, field := range frame.Fields {
+		if !isStringField(field) || !hasName(field, timeColumnNames) {
+			continue
+		}
+		times := make([]*time.Time, field.Len())
+		valid := true
+		for row := 0; row < field.Len() && valid; row++ {
+			if v, ok := field.ConcreteAt(row); ok {
+				t, err := parseTimestamp(v.(string))
+				times[row], valid = &t, err == nil
+			}
+		}
+		if valid {
+			timeField := data.NewField(field.Name, field.Labels, times)
+			timeField.Config = field.Config
+			frame.Fields[i] = timeField
+			return i
+		}
+	}
+	return -1
+}
+
+// sortByTime moves the time column of time series to the front and orders the rows by
+// it, as Databricks doesn't guarantee any order without ORDER BY. Rows without time are
+// moved to the end. Rows with the same time keep the order of the query. Other frames
+// are returned unchanged.
+func sortByTime(frame *data.Frame) *data.Frame {
+	timeIdx := timeColumn(frame)
+	if timeIdx == -1 || frame.TimeSeriesSchema().Type == data.TimeSeriesTypeNot {
+		return frame
+	}
+	if timeIdx != 0 {
+		fields := append([]*data.Field{frame.Fields[timeIdx]}, frame.Fields[:timeIdx]...)
+		frame.Fields = append(fields, frame.Fields[timeIdx+1:]...)
+	}
+
+	timeField := frame.Fields[0]
+	rows := make([]int, timeField.Len())
+	for i := range rows {
+		rows[i] = i
+	}
+	before := func(i, j int) bool {
+		a, aOk := timeField.ConcreteAt(rows[i])
+		b, bOk := timeField.ConcreteAt(rows[j])
+		return aOk && (!bOk || a.(time.Time).Before(b.(time.Time)))
+	}
+	if sort.SliceIsSorted(rows, before) {
+		return frame
+	}
+	sort.SliceStable(rows, before)
+
+	for i, field := range frame.Fields {
+		sorted := data.NewFieldFromFieldType(field.Type(), len(rows))
+		sorted.Name = field.Name
+		sorted.Labels = field.Labels
+		sorted.Config = field.Config
+		for j, row := range rows {
+			sorted.Set(j, field.At(row))
+		}
+		frame.Fields[i] = sorted
+	}
+	return frame
+}
+
+// timeSeriesFrame returns the frame as wide time series. Long frames are converted,
+// frames without a time column are rejected.
+func timeSeriesFrame(frame *data.Frame, settings querySettings) (*data.Frame, error) {
+	switch frame.TimeSeriesSchema().Type {
+	case data.TimeSeriesTypeLong:
+		wideFrame, err := longToWide(frame, settings)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert the result to a time series: %w", err)
+		}
+		frame = wideFrame
+	case data.TimeSeriesTypeWide:
+	default:
+		return nil, fmt.Errorf("the result can't be returned as time series, it requires a time column and at least one numeric column")
+	}
+	setPreferredVisualization(frame, data.VisTypeGraph)
+	frame.Meta.Type = data.FrameTypeTimeSeriesWide
+	return frame, nil
+}
+
+// logsFrame converts the frame to log lines. The first time column is used as timestamp
+// and a string column named like body, message or line, falling back to the first
+// string column, as log line. A column named severity or level is used as level of the
+// line, the remaining columns are added as labels.
+func logsFrame(frame *data.Frame) (*data.Frame, error) {
+	timeIdx, bodyIdx, severityIdx := -1, -1, -1
+	for i, field := range frame.Fields {
+		switch {
+		case timeIdx == -1 && (field.Type() == data.FieldTypeTime || field.Type() == data.FieldTypeNullableTime):
+			timeIdx = i
+		case isStringField(field) && hasName(field, logSeverityColumns) && severityIdx == -1:
+			severityIdx = i
+		case (isStringField(field) || field.Type().JSON()) && hasName(field, logBodyColumns) && bodyIdx == -1:
+			bodyIdx = i
+		}
+	}
+	if bodyIdx == -1 {
+		for i, field := range frame.Fields {
+			if isStringField(field) && i != severityIdx {
+				bodyIdx = i
+				break
+			}
+		}
+	}
+	if bodyIdx == -1 {
+		for i, field := range frame.Fields {
+			if field.Type().JSON() {
+				bodyIdx = i
+				break
+			}
+		}
+	}
+	if timeIdx == -1 || bodyIdx == -1 {
+		return nil, fmt.Errorf("the result can't be returned as logs, it requires a time column and a string or JSON column")
+	}
+
+	timestamp := frame.Fields[timeIdx]
+	timestamp.Name = "timestamp"
+	body := frame.Fields[bodyIdx]
+	if body.Type().JSON() {
+		body = jsonTextField(body)
+	}
+	body.Name = "body"
+	fields := []*data.Field{timestamp, body}
+	if severityIdx != -1 {
+		severity := frame.Fields[severityIdx]
+		severity.Name = "severity"
+		fields = append(fields, severity)
+	}
+
+	rows, _ := frame.RowLen()
+	labels := data.NewFieldFromFieldType(data.FieldTypeJSON, rows)
+	labels.Name = "labels"
+	for row := 0; row < rows; row++ {
+		values := make(map[string]string)
+		for i, field := range frame.Fields {
+			if i == timeIdx || i == bodyIdx || i == severityIdx {
+				continue
+			}
+			v, ok := field.ConcreteAt(row)
+			if !ok {
+				continue
+			}
+			if raw, isJSON := v.(json.RawMessage); isJSON {
+				addJSONLabels(values, field.Name, raw)
+				continue
+			}
+			values[field.Name] = fmt.Sprint(v)
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		labels.Set(row, json.RawMessage(b))
+	}
+	fields = append(fields, labels)
+
+	logs := data.NewFrame(frame.Name, fields...)
+	logs.Meta = frame.Meta
+	setPreferredVisualization(logs, data.VisTypeLogs)
+	logs.Meta.Type = data.FrameTypeLogLines
+	return logs, nil
+}
+
+// jsonTextField converts a JSON field to a string field of the JSON documents, so
+// structured payloads can be used as log line.
+func jsonTextField(field *data.Field) *data.Field {
+	converted := data.NewFieldFromFieldType(data.FieldTypeNullableString, field.Len())
+	converted.Name = field.Name
+	for i := 0; i < field.Len(); i++ {
+		if v, ok := field.ConcreteAt(i); ok {
+			s := string(v.(json.RawMessage))
+			converted.Set(i, &s)
+		}
+	}
+	return converted
+}
+
+// addJSONLabels adds a JSON value as labels. The keys of objects are added as separate
+// labels prefixed with the column name, other values are added as JSON text.
+func addJSONLabels(labels map[string]string, name string, raw json.RawMessage) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil || object == nil {
+		labels[name] = string(raw)
+		return
+	}
+	for key, value := range object {
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			labels[name+"_"+key] = s
+		} else {
+			labels[name+"_"+key] = string(value)
+		}
+	}
+}
+
+func isStringField(field *data.Field) bool {
+	return field.Type() == data.FieldTypeString || field.Type() == data.FieldTypeNullableString
+}
+
+func hasName(field *data.Field, names []string) bool {
+	for _, name := range names {
+		if strings.EqualFold(field.Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+)
+
+const (
+	functionKindBuiltin = "builtin"
+	functionKindUser    = "udf"
+)
+
+// sqlFunction is a function offered as completion by the query editor.
+type sqlFunction struct {
+	Name        string `json:"name"`
+	Signature   string `json:"signature,omitempty"`
+	Description string `json:"description,omitempty"`
+	Kind        string `json:"kind"`
+}
+
+type functionsResponseBody struct {
+	Functions []sqlFunction `json:"functions"`
+	Keywords  []string      `json:"keywords"`
+}
+
+// builtinFunctions are the commonly used Databricks SQL built-in functions with their
+// signature and a short description. The query editor completes the names of all other
+// built-in functions without documentation.
+var builtinFunctions = []sqlFunction{
+	// aggregates
+	{Name: "avg", Signature: "avg([DISTINCT] expr)", Description: "Returns the mean of the values of the group."},
+	{Name: "count", Signature: "count([DISTINCT] expr [, ...])", Description: "Returns the number of rows of the group for which the expressions are not null, count(*) counts all rows."},
+	{Name: "count_if", Signature: "count_if(cond)", Description: "Returns the number of rows of the group for which the condition is true."},
+	{Name: "sum", Signature: "sum([DISTINCT] expr)", Description: "Returns the sum of the values of the group."},
+	{Name: "min", Signature: "min(expr)", Description: "Returns the minimum value of the group."},
+	{Name: "max", Signature: "max(expr)", Description: "Returns the maximum value of the group."},
+	{Name: "min_by", Signature: "min_by(expr, ord)", Description: "Returns the value of expr of the row with the minimum ord."},
+	{Name: "max_by", Signature: "max_by(expr, ord)", Description: "Returns the value of expr of the row with the maximum ord."},
+	{Name: "first", Signature: "first(expr [, ignoreNull])", Description: "Returns the first value of the group."},
+	{Name: "last", Signature: "last(expr [, ignoreNull])", Description: "Returns the last value of the group."},
+	{Name: "any_value", Signature: "any_value(expr [, ignoreNull])", Description: "Returns any value of the group."},
+	{Name: "approx_count_distinct", Signature: "approx_count_distinct(expr [, relativeSD])", Description: "Returns the estimated number of distinct values of the group."},
+	{Name: "approx_percentile", Signature: "approx_percentile([ALL | DISTINCT] expr, percentile [, accuracy])", Description: "Returns the approximate percentile of the values of the group."},
+	{Name: "percentile", Signature: "percentile(expr, percentage [, frequency])", Description: "Returns the exact percentile of the values of the group."},
+	{Name: "median", Signature: "median(expr)", Description: "Returns the median of the values of the group."},
+	{Name: "stddev", Signature: "stddev(expr)", Description: "Returns the sample standard deviation of the values of the group."},
+	{Name: "variance", Signature: "variance(expr)", Description: "Returns the sample variance of the values of the group."},
+	{Name: "collect_list", Signature: "collect_list(expr)", Description: "Returns an array of the values of the group."},
+	{Name: "collect_set", Signature: "collect_set(expr)", Description: "Returns an array of the distinct values of the group."},
+	// window functions
+	{Name: "row_number", Signature: "row_number() OVER (...)", Description: "Returns the sequential number of the row within the window partition."},
+	{Name: "rank", Signature: "rank() OVER (...)", Description: "Returns the rank of the row within the window partition, with gaps."},
+	{Name: "dense_rank", Signature: "dense_rank() OVER (...)", Description: "Returns the rank of the row within the window partition, without gaps."},
+	{Name: "lag", Signature: "lag(expr [, offset [, default]]) OVER (...)", Description: "Returns the value of expr of a preceding row of the window partition."},
+	{Name: "lead", Signature: "lead(expr [, offset [, default]]) OVER (...)", Description: "Returns the value of expr of a following row of the window partition."},
+	{Name: "ntile", Signature: "ntile([n]) OVER (...)", Description: "Divides the rows of the window partition into n buckets."},
+	// date and time
+	{Name: "current_date", Signature: "current_date()", Description: "Returns the current date."},
+	{Name: "current_timestamp", Signature: "current_timestamp()", Description: "Returns the current timestamp."},
+	{Name: "date_trunc", Signature: "date_trunc(unit, expr)", Description: "Returns the timestamp truncated to the unit, i.e. 'HOUR' or 'DAY'."},
+	{Name: "date_format", Signature: "date_format(expr, fmt)", Description: "Formats the timestamp using the datetime pattern."},
+	{Name: "date_add", Signature: "date_add(startDate, numDays)", Description: "Returns the date numDays after startDate."},
+	{Name: "date_sub", Signature: "date_sub(startDate, numDays)", Description: "Returns the date numDays before startDate."},
+	{Name: "datediff", Signature: "datediff(endDate, startDate)", Description: "Returns the number of days from startDate to endDate."},
+	{Name: "timestampadd", Signature: "timestampadd(unit, value, expr)", Description: "Adds value units to the timestamp."},
+	{Name: "timestampdiff", Signature: "timestampdiff(unit, start, end)", Description: "Returns the difference between the timestamps in units."},
+	{Name: "from_unixtime", Signature: "from_unixtime(unixTime [, fmt])", Description: "Formats the epoch seconds as string."},
+	{Name: "unix_timestamp", Signature: "unix_timestamp([expr [, fmt]])", Description: "Returns the epoch seconds of the timestamp."},
+	{Name: "timestamp_seconds", Signature: "timestamp_seconds(expr)", Description: "Creates a timestamp from epoch seconds."},
+	{Name: "timestamp_millis", Signature: "timestamp_millis(expr)", Description: "Creates a timestamp from epoch milliseconds."},
+	{Name: "to_date", Signature: "to_date(expr [, fmt])", Description: "Parses the string as date."},
+	{Name: "to_timestamp", Signature: "to_timestamp(expr [, fmt])", Description: "Parses the string as timestamp."},
+	{Name: "from_utc_timestamp", Signature: "from_utc_timestamp(expr, timeZone)", Description: "Converts a UTC timestamp to the wall clock time of the timezone."},
+	{Name: "to_utc_timestamp", Signature: "to_utc_timestamp(expr, timeZone)", Description: "Converts a wall clock time of the timezone to a UTC timestamp."},
+	{Name: "window", Signature: "window(expr, width [, slide [, start]])", Description: "Groups rows into time windows, returns a struct with start and end."},
+	{Name: "year", Signature: "year(expr)", Description: "Returns the year of the date or timestamp."},
+	{Name: "month", Signature: "month(expr)", Description: "Returns the month of the date or timestamp."},
+	{Name: "day", Signature: "day(expr)", Description: "Returns the day of month of the date or timestamp."},
+	{Name: "hour", Signature: "hour(expr)", Description: "Returns the hour of the timestamp."},
+	// conditionals and null handling
+	{Name: "coalesce", Signature: "coalesce(expr1, expr2 [, ...])", Description: "Returns the first argument which is not null."},
+	{Name: "nullif", Signature: "nullif(expr1, expr2)", Description: "Returns null if the arguments are equal, otherwise expr1."},
+	{Name: "ifnull", Signature: "ifnull(expr1, expr2)", Description: "Returns expr2 if expr1 is null, otherwise expr1."},
+	{Name: "if", Signature: "if(cond, expr1, expr2)", Description: "Returns expr1 if the condition is true, otherwise expr2."},
+	{Name: "try_cast", Signature: "try_cast(expr AS type)", Description: "Casts the value to the type, returning null if it can't be cast."},
+	{Name: "try_divide", Signature: "try_divide(dividend, divisor)", Description: "Divides the values, returning null if the divisor is 0."},
+	// strings
+	{Name: "concat", Signature: "concat(expr1, expr2 [, ...])", Description: "Returns the concatenation of the arguments."},
+	{Name: "concat_ws", Signature: "concat_ws(sep [, expr1 [, ...]])", Description: "Returns the concatenation of the arguments separated by sep."},
+	{Name: "lower", Signature: "lower(expr)", Description: "Returns the string in lower case."},
+	{Name: "upper", Signature: "upper(expr)", Description: "Returns the string in upper case."},
+	{Name: "trim", Signature: "trim(str)", Description: "Removes leading and trailing spaces."},
+	{Name: "substring", Signature: "substring(expr, pos [, len])", Description: "Returns the substring starting at pos."},
+	{Name: "split", Signature: "split(str, regex [, limit])", Description: "Splits the string around matches of the regex."},
+	{Name: "regexp_extract", Signature: "regexp_extract(str, regexp [, idx])", Description: "Extracts the group of the first match of the regex."},
+	{Name: "regexp_replace", Signature: "regexp_replace(str, regexp, rep [, position])", Description: "Replaces all matches of the regex."},
+	{Name: "format_number", Signature: "format_number(expr, scale)", Description: "Formats the number with thousands separators."},
+	// JSON and complex types
+	{Name: "get_json_object", Signature: "get_json_object(expr, path)", Description: "Extracts the value of the JSON path from the JSON string."},
+	{Name: "from_json", Signature: "from_json(jsonStr, schema [, options])", Description: "Parses the JSON string into a struct of the schema."},
+	{Name: "to_json", Signature: "to_json(expr [, options])", Description: "Returns the struct as JSON string."},
+	{Name: "parse_json", Signature: "parse_json(jsonStr)", Description: "Parses the JSON string into a VARIANT."},
+	{Name: "explode", Signature: "explode(collection)", Description: "Returns a row per element of the array or map."},
+	{Name: "size", Signature: "size(expr)", Description: "Returns the number of elements of the array or map."},
+	{Name: "array_contains", Signature: "array_contains(array, value)", Description: "Returns true if the array contains the value."},
+	{Name: "element_at", Signature: "element_at(arrayOrMap, indexOrKey)", Description: "Returns the element at the 1-based index, or the value of the key."},
+	{Name: "named_struct", Signature: "named_struct({name1, val1} [, ...])", Description: "Creates a struct of the named values."},
+	// math
+	{Name: "round", Signature: "round(expr [, targetScale])", Description: "Rounds the number to targetScale decimal places."},
+	{Name: "floor", Signature: "floor(expr [, targetScale])", Description: "Returns the largest number not greater than expr."},
+	{Name: "ceil", Signature: "ceil(expr [, targetScale])", Description: "Returns the smallest number not smaller than expr."},
+	{Name: "abs", Signature: "abs(expr)", Description: "Returns the absolute value."},
+	{Name: "greatest", Signature: "greatest(expr1, expr2 [, ...])", Description: "Returns the greatest argument, skipping nulls."},
+	{Name: "least", Signature: "least(expr1, expr2 [, ...])", Description: "Returns the least argument, skipping nulls."},
+}
+
+// sqlKeywords are the Databricks SQL keywords offered as completion.
+var sqlKeywords = []string{
+	"SELECT", "DISTINCT", "FROM", "WHERE", "GROUP BY", "GROUP BY ALL", "HAVING", "QUALIFY", "WINDOW",
+	"ORDER BY", "SORT BY", "CLUSTER BY", "DISTRIBUTE BY", "LIMIT", "OFFSET", "UNION", "UNION ALL",
+	"INTERSECT", "EXCEPT", "WITH", "AS", "ON", "USING", "JOIN", "INNER JOIN", "LEFT JOIN", "RIGHT JOIN",
+	"FULL JOIN", "CROSS JOIN", "LEFT SEMI JOIN", "LEFT ANTI JOIN", "LATERAL VIEW", "PIVOT", "UNPIVOT",
+	"CASE", "WHEN", "THEN", "ELSE", "END", "AND", "OR", "NOT", "IN", "EXISTS", "BETWEEN", "LIKE", "ILIKE",
+	"RLIKE", "IS NULL", "IS NOT NULL", "OVER", "PARTITION BY", "ROWS BETWEEN", "RANGE BETWEEN",
+	"UNBOUNDED PRECEDING", "CURRENT ROW", "UNBOUNDED FOLLOWING", "ASC", "DESC", "NULLS FIRST",
+	"NULLS LAST", "CAST", "INTERVAL", "TIMESTAMP AS OF", "VERSION AS OF", "USE", "SHOW", "DESCRIBE",
+	"EXPLAIN",
+}
+
+// functionCatalog returns the built-in functions and keywords, and the user defined
+// functions of the workspace if udfs is set. Errors listing the user defined functions
+// are only logged, the built-in functions are returned anyway.
+func functionCatalog(ctx context.Context, db *sql.DB, udfs bool) functionsResponseBody {
+	functions := make([]sqlFunction, 0, len(builtinFunctions))
+	for _, f := range builtinFunctions {
+		f.Kind = functionKindBuiltin
+		functions = append(functions, f)
+	}
+	if udfs {
+		rows, err := queryStrings(ctx, db, "SHOW USER FUNCTIONS")
+		if err != nil {
+			logger.Info("User Functions Error", "err", err)
+		}
+		for _, row := range rows {
+			functions = append(functions, sqlFunction{Name: row[0], Kind: functionKindUser})
+		}
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+	return functionsResponseBody{Functions: functions, Keywords: sqlKeywords}
+}
@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats INTERVAL values can be converted to.
+const (
+	intervalFormatSeconds = "seconds"
+	intervalFormatISO     = "iso"
+)
+
+// secondsPerMonth is the average length of a month in the Gregorian calendar, used to
+// convert year-month intervals to seconds.
+const secondsPerMonth = 365.2425 * 24 * 60 * 60 / 12
+
+var (
+	// intervalTypeRgx matches the names of the year-month and day-time interval types.
+	intervalTypeRgx = regexp.MustCompile(`^INTERVAL`)
+	// intervalLiteralRgx matches interval literals, i.e. INTERVAL '1-2' YEAR TO MONTH.
+	intervalLiteralRgx = regexp.MustCompile(`(?i)^INTERVAL\s+'([^']*)'`)
+	// yearMonthIntervalRgx matches year-month intervals, i.e. 1-2 for 1 year and 2 months.
+	yearMonthIntervalRgx = regexp.MustCompile(`^([+-])?(\d+)-(\d+)$`)
+	// dayTimeIntervalRgx matches day-time intervals, i.e. 1 02:03:04.5 for 1 day, 2 hours,
+	// 3 minutes and 4.5 seconds. The days are optional.
+	dayTimeIntervalRgx = regexp.MustCompile(`^([+-])?(?:(\d+) )?(\d+):(\d+):(\d+(?:\.\d+)?)$`)
+)
+
+// parseInterval parses a Databricks INTERVAL value into its months and its duration,
+// only one of them is set.
+func parseInterval(value string) (int64, time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if match := intervalLiteralRgx.FindStringSubmatch(value); match != nil {
+		value = strings.TrimSpace(match[1])
+	}
+	if match := yearMonthIntervalRgx.FindStringSubmatch(value); match != nil {
+		years, _ := strconv.ParseInt(match[2], 10, 64)
+		months, _ := strconv.ParseInt(match[3], 10, 64)
+		months += years * 12
+		if match[1] == "-" {
+			months = -months
+		}
+		return months, 0, nil
+	}
+	if match := dayTimeIntervalRgx.FindStringSubmatch(value); match != nil {
+		days, _ := strconv.ParseInt(match[2], 10, 64)
+		hours, _ := strconv.ParseInt(match[3], 10, 64)
+		minutes, _ := strconv.ParseInt(match[4], 10, 64)
+		seconds, _ := strconv.ParseFloat(match[5], 64)
+		d := time.Duration(days)*24*time.Hour + time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+		if match[1] == "-" {
+			d = -d
+		}
+		return 0, d, nil
+	}
+	return 0, 0, fmt.Errorf("invalid interval %q", value)
+}
+
+// isoDuration renders an interval as ISO-8601 duration, i.e. P1Y2M or P1DT2H3M4.5S.
+// Negative intervals are prefixed with a minus sign.
+func isoDuration(months int64, d time.Duration) string {
+	var b strings.Builder
+	if months < 0 || d < 0 {
+		b.WriteString("-")
+		months, d = -months, -d
+	}
+	b.WriteString("P")
+	if months >= 12 {
+		fmt.Fprintf(&b, "%dY", months/12)
+	}
+	if months%12 > 0 {
+		fmt.Fprintf(&b, "%dM", months%12)
+	}
+	if days := d / (24 * time.Hour); days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+		d -= days * 24 * time.Hour
+	}
+	if d > 0 || (months == 0 && b.Len() <= 2) {
+		b.WriteString("T")
+		if hours := d / time.Hour; hours > 0 {
+			fmt.Fprintf(&b, "%dH", hours)
+			d -= hours * time.Hour
+		}
+		if minutes := d / time.Minute; minutes > 0 {
+			fmt.Fprintf(&b, "%dM", minutes)
+			d -= minutes * time.Minute
+		}
+		if d > 0 || strings.HasSuffix(b.String(), "T") {
+			fmt.Fprintf(&b, "%sS", strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+		}
+	}
+	return b.String()
+}
+
+// withIntervalFormat returns the options converting INTERVAL values to the format,
+// seconds if empty.
+func (o conversionOptions) withIntervalFormat(format string) (conversionOptions, error) {
+	switch strings.ToLower(format) {
+	case "", intervalFormatSeconds:
+		o.intervalFormat = intervalFormatSeconds
+	case intervalFormatISO:
+		o.intervalFormat = intervalFormatISO
+	default:
+		return o, fmt.Errorf("unsupported interval format %q, expected %s or %s", format, intervalFormatSeconds, intervalFormatISO)
+	}
+	return o, nil
+}
+
+// newIntervalField creates a field for INTERVAL values in the format of the options.
+func (o conversionOptions) newIntervalField(name string) *data.Field {
+	if o.intervalFormat == intervalFormatISO {
+		return data.NewField(name, nil, []*string{})
+	}
+	return data.NewField(name, nil, []*float64{})
+}
+
+// intervalValue converts an interval to a value of the field created by
+// newIntervalField.
+func (o conversionOptions) intervalValue(months int64, d time.Duration) interface{} {
+	if o.intervalFormat == intervalFormatISO {
+		v := isoDuration(months, d)
+		return &v
+	}
+	v := float64(months)*secondsPerMonth + d.Seconds()
+	return &v
+}
+
+// intervalConverter converts Databricks INTERVAL columns, which the driver returns as
+// strings, to the format of the options.
+func (o conversionOptions) intervalConverter() sqlutil.Converter {
+	fieldType := data.FieldTypeNullableFloat64
+	if o.intervalFormat == intervalFormatISO {
+		fieldType = data.FieldTypeNullableString
+	}
+	return sqlutil.Converter{
+		Name:           "Databricks interval converter",
+		InputScanType:  reflect.TypeOf(sql.NullString{}),
+		InputTypeRegex: intervalTypeRgx,
+		FrameConverter: sqlutil.FrameConverter{
+			FieldType: fieldType,
+			ConverterFunc: func(n interface{}) (interface{}, error) {
+				v := n.(*sql.NullString)
+
+				if !v.Valid {
+					if o.intervalFormat == intervalFormatISO {
+						return (*string)(nil), nil
+					}
+					return (*float64)(nil), nil
+				}
+
+				months, d, err := parseInterval(v.String)
+				if err != nil {
+					return nil, err
+				}
+				return o.intervalValue(months, d), nil
+			},
+		},
+	}
+}
@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultKeepAliveInterval is used if keep-alive is enabled without an interval.
+const defaultKeepAliveInterval = 5 * time.Minute
+
+// startKeepAlive pings the warehouses of the connection pools in the given
+// interval until the instance is disposed. This keeps sessions open and prevents the
+// warehouses from stopping, which avoids cold starts for latency sensitive dashboards
+// at the cost of keeping the warehouses running.
+func (d *Datasource) startKeepAlive(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultKeepAliveInterval
+	}
+	d.stopKeepAlive = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stopKeepAlive:
+				return
+			case <-ticker.C:
+				d.ping()
+			}
+		}
+	}()
+}
+
+// ping pings the warehouses of the default connection pools and of the connection
+// pools of the credential mappings.
+func (d *Datasource) ping() {
+	pingPools(d.pools, "datasource")
+	for i, pool := range d.credentialMappings {
+		pingPools(pool.pools, fmt.Sprintf("mapping/%d", i))
+	}
+}
+
+func pingPools(pools *poolSet, scope string) {
+	for warehouse, db := range pools.all() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := db.PingContext(ctx)
+		cancel()
+		if err != nil {
+			logger.Info("Keep-Alive Ping Error", "warehouse", warehouse, "credentials", scope, "err", err)
+		}
+	}
+}
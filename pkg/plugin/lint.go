@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"encoding/json"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"strings"
+)
+
+// Codes of the warnings returned by lintQuery.
+const (
+	lintMissingTimeFilter = "missing_time_filter"
+	lintSelectStar        = "select_star"
+	lintCrossJoin         = "cross_join"
+	lintMissingLimit      = "missing_limit"
+)
+
+// timeFilterMarkers are the macros and variables restricting a query to the time range
+// of the dashboard.
+var timeFilterMarkers = []string{
+	"$__timeFilter", "$__unixEpochFilter", "$__unixEpochMsFilter", "$__partitionFilter",
+	"$__timeFrom", "$__timeTo", "${__from", "${__to", "$__fiscalYearStart", "$__fiscalQuarterStart",
+}
+
+// joinEndKeywords end the join condition of a JOIN, if it has none.
+var joinEndKeywords = map[string]bool{
+	"JOIN": true, "WHERE": true, "GROUP": true, "HAVING": true, "QUALIFY": true, "WINDOW": true,
+	"ORDER": true, "CLUSTER": true, "DISTRIBUTE": true, "SORT": true, "LIMIT": true, "OFFSET": true,
+	"UNION": true, "INTERSECT": true, "EXCEPT": true, "LEFT": true, "RIGHT": true, "FULL": true,
+	"INNER": true, "CROSS": true, "NATURAL": true,
+}
+
+type lintWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Statement is the index of the statement of the query the warning is about.
+	Statement int `json:"statement"`
+}
+
+type lintRequestBody struct {
+	RawSqlQuery string `json:"rawSqlQuery"`
+	Format      string `json:"format"`
+}
+
+type lintResponseBody struct {
+	Warnings []lintWarning `json:"warnings"`
+}
+
+// lintQuery runs static checks on the SELECT statements of the query string which read
+// from tables. Time series and logs are expected to be filtered by the time range of the
+// dashboard, tables without time filter to be limited while exploring the data. The
+// checks are heuristics working on the tokens of the statements, nothing is sent to
+// Databricks.
+func lintQuery(queryString string, format string) []lintWarning {
+	warnings := make([]lintWarning, 0)
+	for i, statement := range splitStatements(queryString) {
+		keyword := statementKeyword(statement)
+		if keyword != "SELECT" && keyword != "WITH" && keyword != "FROM" {
+			continue
+		}
+		tokens := tokenizeSQL(statement)
+		if !hasKeyword(tokens, "FROM") {
+			continue
+		}
+		warn := func(code string, message string) {
+			warnings = append(warnings, lintWarning{Code: code, Message: message, Statement: i})
+		}
+
+		timeFiltered := false
+		for _, marker := range timeFilterMarkers {
+			timeFiltered = timeFiltered || strings.Contains(statement, marker)
+		}
+		if !timeFiltered && format != formatTable {
+			warn(lintMissingTimeFilter, "The query has no time filter like $__timeFilter(column), so it reads all rows regardless of the time range of the dashboard.")
+		}
+		if selectsStar(tokens) {
+			warn(lintSelectStar, "SELECT * reads all columns, select only the columns the panel needs.")
+		}
+		if message := crossJoin(tokens, hasTopLevelKeyword(statement, "WHERE")); message != "" {
+			warn(lintCrossJoin, message)
+		}
+		if format == formatTable && !timeFiltered && !hasTopLevelKeyword(statement, "LIMIT") && !hasTopLevelKeyword(statement, "WHERE") && !hasTopLevelKeyword(statement, "GROUP") {
+			warn(lintMissingLimit, "The query neither filters, aggregates nor limits the rows, add a LIMIT while exploring the data.")
+		}
+	}
+	return warnings
+}
+
+func hasKeyword(tokens []formatToken, keyword string) bool {
+	for _, token := range tokens {
+		if token.kind == formatKeyword && token.text == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsStar reports whether the tokens select all columns, i.e. SELECT * or
+// SELECT t.*, which count(*) doesn't.
+func selectsStar(tokens []formatToken) bool {
+	for i, token := range tokens {
+		if token.text != "*" || i == 0 {
+			continue
+		}
+		switch tokens[i-1].text {
+		case "SELECT", "DISTINCT", "ALL", ",", ".":
+			return true
+		}
+	}
+	return false
+}
+
+// crossJoin returns a warning message if the tokens join tables without condition: a
+// CROSS JOIN, a JOIN without ON or USING, or tables separated by commas in the FROM
+// clause of a statement without WHERE clause.
+func crossJoin(tokens []formatToken, hasWhere bool) string {
+	depth := 0
+	fromDepth := -1
+	for i, token := range tokens {
+		switch token.text {
+		case "(":
+			depth++
+			continue
+		case ")":
+			depth--
+			continue
+		}
+		if token.kind != formatKeyword && token.text != "," {
+			continue
+		}
+		switch {
+		case token.text == "CROSS" && i+1 < len(tokens) && tokens[i+1].text == "JOIN":
+			return "The query uses a CROSS JOIN, which returns every combination of the rows of both tables."
+		case token.text == "JOIN" && (i == 0 || (tokens[i-1].text != "CROSS" && tokens[i-1].text != "NATURAL")) && !joinCondition(tokens[i+1:]):
+			return "A JOIN has no ON or USING condition, so it returns every combination of the rows of both tables."
+		case token.text == "FROM":
+			fromDepth = depth
+		case token.text == "," && depth == fromDepth && !hasWhere:
+			return "Tables are joined with commas without WHERE clause, so every combination of their rows is returned."
+		case token.text != "," && depth == fromDepth && joinEndKeywords[token.text]:
+			fromDepth = -1
+		}
+	}
+	return ""
+}
+
+// joinCondition reports whether the tokens following a JOIN contain its ON or USING
+// condition, before the next clause.
+func joinCondition(tokens []formatToken) bool {
+	depth := 0
+	for _, token := range tokens {
+		switch {
+		case token.text == "(":
+			depth++
+		case token.text == ")":
+			if depth == 0 {
+				return false
+			}
+			depth--
+		case depth > 0 || token.kind != formatKeyword:
+		case token.text == "ON" || token.text == "USING":
+			return true
+		case joinEndKeywords[token.text]:
+			return false
+		}
+	}
+	return false
+}
+
+// lintQueryRequest handles the resource request linting the query of the request body.
+func (d *Datasource) lintQueryRequest(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	var body lintRequestBody
+	err := json.Unmarshal(req.Body, &body)
+	if err != nil {
+		return sendError(sender, http.StatusBadRequest, err)
+	}
+
+	jsonBody, err := json.Marshal(lintResponseBody{Warnings: lintQuery(body.RawSqlQuery, body.Format)})
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"regexp"
+)
+
+// logger is used for all log output of the plugin. It redacts secrets like access tokens
+// and the values of string literals in queries before anything is written to the log.
+var logger log.Logger = newRedactingLogger(log.DefaultLogger)
+
+var secretPatterns = []struct {
+	rgx         *regexp.Regexp
+	replacement string
+}{
+	// Databricks personal access tokens
+	{regexp.MustCompile(`dapi[0-9a-fA-F]{32}(-\d+)?`), "dapi***"},
+	// JSON web tokens, i.e. OAuth access and ID tokens
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), "***"},
+	// Authorization headers
+	{regexp.MustCompile(`(?i)(bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`), "$1 ***"},
+	// Connection strings, form values and maps containing secrets
+	{regexp.MustCompile(`(?i)\b(access_token|client_secret|clientSecret|password|proxyPassword|serviceAccountKey|tlsClientKey|token)(["']?\s*[:=]\s*["']?)([^\s&,"'}\]@]+)`), "$1$2***"},
+}
+
+// queryLiteralRgx matches string literals of sql queries, which may contain PII.
+var queryLiteralRgx = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+
+// queryLogKeys are the log argument keys holding sql queries.
+var queryLogKeys = map[string]bool{
+	"query":       true,
+	"queryString": true,
+	"sql":         true,
+}
+
+// redactSecrets removes access tokens and other secrets from a log value.
+func redactSecrets(value string) string {
+	for _, pattern := range secretPatterns {
+		value = pattern.rgx.ReplaceAllString(value, pattern.replacement)
+	}
+	return value
+}
+
+// redactQuery removes secrets and the values of string literals from a sql query.
+func redactQuery(query string) string {
+	return queryLiteralRgx.ReplaceAllString(redactSecrets(query), "'***'")
+}
+
+// redactingLogger wraps a log.Logger and redacts all messages and arguments.
+type redactingLogger struct {
+	logger log.Logger
+}
+
+func newRedactingLogger(logger log.Logger) log.Logger {
+	return &redactingLogger{logger: logger}
+}
+
+func (l *redactingLogger) redactArgs(args []interface{}) []interface{} {
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		// Arguments are key value pairs, keys are never redacted
+		if i%2 == 0 {
+			redacted[i] = arg
+			continue
+		}
+		var value string
+		switch v := arg.(type) {
+		case nil:
+			redacted[i] = arg
+			continue
+		case string:
+			value = v
+		case error:
+			value = v.Error()
+		case fmt.Stringer:
+			value = v.String()
+		default:
+			value = fmt.Sprintf("%+v", v)
+		}
+		if key, ok := args[i-1].(string); ok && queryLogKeys[key] {
+			redacted[i] = redactQuery(value)
+		} else {
+			redacted[i] = redactSecrets(value)
+		}
+	}
+	return redacted
+}
+
+func (l *redactingLogger) Debug(msg string, args ...interface{}) {
+	l.logger.Debug(redactSecrets(msg), l.redactArgs(args)...)
+}
+
+func (l *redactingLogger) Info(msg string, args ...interface{}) {
+	l.logger.Info(redactSecrets(msg), l.redactArgs(args)...)
+}
+
+func (l *redactingLogger) Warn(msg string, args ...interface{}) {
+	l.logger.Warn(redactSecrets(msg), l.redactArgs(args)...)
+}
+
+func (l *redactingLogger) Error(msg string, args ...interface{}) {
+	l.logger.Error(redactSecrets(msg), l.redactArgs(args)...)
+}
+
+func (l *redactingLogger) With(args ...interface{}) log.Logger {
+	return &redactingLogger{logger: l.logger.With(l.redactArgs(args)...)}
+}
+
+func (l *redactingLogger) Level() log.Level {
+	return l.logger.Level()
+}
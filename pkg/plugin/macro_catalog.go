@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"encoding/json"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+)
+
+const (
+	macroKindBuiltin  = "builtin"
+	macroKindUser     = "user"
+	macroKindVariable = "variable"
+)
+
+// macroInfo documents a macro expanded by the backend, as returned by the macros
+// resource.
+type macroInfo struct {
+	Name        string `json:"name"`
+	Signature   string `json:"signature"`
+	Description string `json:"description"`
+	Example     string `json:"example,omitempty"`
+	Kind        string `json:"kind"`
+}
+
+// builtinMacros are the macros and variables expanded by replaceMacros and
+// globalVariables. New macros have to be added here, so the query editor completes and
+// documents them.
+var builtinMacros = []macroInfo{
+	{Name: "$__timeFilter", Signature: "$__timeFilter(column)", Description: "Filters the time column on the selected time range.", Example: "WHERE $__timeFilter(event_time)"},
+	{Name: "$__timeWindow", Signature: "$__timeWindow(column)", Description: "Groups the time column into windows of the interval of the dashboard, with $__time and $__value selecting the start of the window and the average of a column.", Example: "GROUP BY $__timeWindow(event_time)"},
+	{Name: "$__time", Signature: "$__time(column)", Description: "Selects the column as time, or the start of the window if $__timeWindow is used.", Example: "SELECT $__time(event_time)"},
+	{Name: "$__value", Signature: "$__value(column)", Description: "Selects the column as value, or its average per window if $__timeWindow is used.", Example: "SELECT $__value(cpu)"},
+	{Name: "$__timeGroup", Signature: "$__timeGroup(column, interval[, fill])", Description: "Truncates the time column to buckets of the interval, a duration like '5m', $__interval, a calendar interval like month, or fiscal_quarter and fiscal_year. The fill NULL, previous or a number sets how missing buckets are filled.", Example: "SELECT $__timeGroup(event_time, '5m', 0) AS time"},
+	{Name: "$__partitionFilter", Signature: "$__partitionFilter(column[, pattern])", Description: "Filters a date partition column on the days of the selected time range, string columns are compared with dates formatted with the pattern.", Example: "WHERE $__partitionFilter(day, 'yyyyMMdd')"},
+	{Name: "$__fiscalYear", Signature: "$__fiscalYear(column)", Description: "Returns the fiscal year of the time column, named after the calendar year it ends in.", Example: "SELECT $__fiscalYear(order_date) AS fiscal_year"},
+	{Name: "$__fiscalQuarter", Signature: "$__fiscalQuarter(column)", Description: "Returns the fiscal quarter, 1 to 4, of the time column.", Example: "SELECT $__fiscalQuarter(order_date) AS fiscal_quarter"},
+	{Name: "$__fiscalYearStart", Signature: "$__fiscalYearStart()", Description: "Returns the start of the fiscal year containing the end of the selected time range as DATE literal.", Example: "WHERE order_date >= $__fiscalYearStart()"},
+	{Name: "$__fiscalQuarterStart", Signature: "$__fiscalQuarterStart()", Description: "Returns the start of the fiscal quarter containing the end of the selected time range as DATE literal.", Example: "WHERE order_date >= $__fiscalQuarterStart()"},
+	{Name: "$__unixEpochFilter", Signature: "$__unixEpochFilter(column)", Description: "Filters a column of epoch seconds on the selected time range.", Example: "WHERE $__unixEpochFilter(epoch)"},
+	{Name: "$__unixEpochGroup", Signature: "$__unixEpochGroup(column, interval[, fill])", Description: "Groups a column of epoch seconds into buckets of the interval, returned as timestamps.", Example: "SELECT $__unixEpochGroup(epoch, '5m') AS time"},
+	{Name: "$__unixEpochMsFilter", Signature: "$__unixEpochMsFilter(column)", Description: "Filters a column of epoch milliseconds on the selected time range.", Example: "WHERE $__unixEpochMsFilter(epoch_ms)"},
+	{Name: "$__unixEpochMsGroup", Signature: "$__unixEpochMsGroup(column, interval[, fill])", Description: "Groups a column of epoch milliseconds into buckets of the interval, returned as timestamps.", Example: "SELECT $__unixEpochMsGroup(epoch_ms, '5m') AS time"},
+	{Name: "$__timeFrom", Signature: "$__timeFrom([format])", Description: "Returns the start of the selected time range as literal, formatted as timestamp (default), iso, date, epoch or epoch_ms.", Example: "WHERE day >= $__timeFrom(date)"},
+	{Name: "$__timeTo", Signature: "$__timeTo([format])", Description: "Returns the end of the selected time range as literal, formatted as timestamp (default), iso, date, epoch or epoch_ms.", Example: "WHERE day <= $__timeTo(date)"},
+	{Name: "$__quoteList", Signature: "$__quoteList(values)", Description: "Returns the values of a multi-value variable, rendered as ${var:json} or ${var:csv}, as escaped string literals.", Example: "WHERE host IN ($__quoteList(${host:json}))"},
+	{Name: "$__timezone", Signature: "$__timezone", Description: "Returns the timezone of the dashboard as string literal.", Example: "SELECT from_utc_timestamp(event_time, $__timezone)"},
+	{Name: "$__searchFilter", Signature: "$__searchFilter", Description: "Returns a LIKE pattern matching values starting with the text typed into the search of a variable.", Example: "WHERE host LIKE $__searchFilter"},
+	{Name: "$__interval", Signature: "$__interval", Description: "Returns the interval of the dashboard as Databricks interval string, i.e. 2 HOURS.", Example: "INTERVAL $__interval"},
+	{Name: "$__interval_ms", Signature: "$__interval_ms", Description: "Returns the interval of the dashboard in milliseconds.", Example: "floor(epoch_ms / $__interval_ms)"},
+	{Name: "${__user.login}", Signature: "${__user.login}", Description: "Returns the login of the user running the query, escaped for string literals.", Example: "WHERE owner = '${__user.login}'", Kind: macroKindVariable},
+	{Name: "${__user.email}", Signature: "${__user.email}", Description: "Returns the email of the user running the query, escaped for string literals.", Kind: macroKindVariable},
+	{Name: "${__user.name}", Signature: "${__user.name}", Description: "Returns the name of the user running the query, escaped for string literals.", Kind: macroKindVariable},
+	{Name: "${__org.id}", Signature: "${__org.id}", Description: "Returns the id of the Grafana organization.", Kind: macroKindVariable},
+	{Name: "${__dashboard.uid}", Signature: "${__dashboard.uid}", Description: "Returns the uid of the dashboard, empty for alert rules.", Kind: macroKindVariable},
+}
+
+// listMacros handles the resource request listing the built-in macros and the user
+// defined macros of the datasource.
+func (d *Datasource) listMacros(sender backend.CallResourceResponseSender) error {
+	macros := make([]macroInfo, 0, len(builtinMacros)+len(d.macros))
+	for _, macro := range builtinMacros {
+		if macro.Kind == "" {
+			macro.Kind = macroKindBuiltin
+		}
+		macros = append(macros, macro)
+	}
+	for _, macro := range d.macros {
+		name := "$__" + macro.macroName()
+		macros = append(macros, macroInfo{
+			Name:        name,
+			Signature:   name + "(...)",
+			Description: "User defined macro, replaced by: " + macro.Template,
+			Kind:        macroKindUser,
+		})
+	}
+
+	jsonBody, err := json.Marshal(macros)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
@@ -0,0 +1,535 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// macroOptions configure the expansion of the macros of a query.
+type macroOptions struct {
+	userMacros []userMacro
+	// location is the timezone of the dashboard, times are rendered in UTC if nil.
+	location *time.Location
+	// searchFilter is the search text of a variable query.
+	searchFilter string
+	// fiscalYearStartMonth is the first month of the fiscal year, 1 for January.
+	fiscalYearStartMonth int
+	// variables are the Grafana global variables of the request by name, i.e.
+	// __user.login.
+	variables map[string]string
+}
+
+// globalVariables returns the Grafana global variables available on the backend, so
+// they can be used by alert rules, which aren't interpolated by the frontend.
+func globalVariables(pCtx backend.PluginContext, dashboardUid string) map[string]string {
+	variables := map[string]string{
+		"__org.id":        fmt.Sprint(pCtx.OrgID),
+		"__dashboard.uid": dashboardUid,
+		"__user.login":    "",
+		"__user.email":    "",
+		"__user.name":     "",
+	}
+	if pCtx.User != nil {
+		variables["__user.login"] = pCtx.User.Login
+		variables["__user.email"] = pCtx.User.Email
+		variables["__user.name"] = pCtx.User.Name
+	}
+	return variables
+}
+
+// macroOptions returns the options for a query with the given timezone, an IANA name
+// like Europe/Berlin. Without timezone or for utc times are rendered in UTC. The fiscal
+// year start month overrides the one of the datasource if set.
+func (d *Datasource) macroOptions(timezone string, fiscalYearStartMonth int) (macroOptions, error) {
+	options := macroOptions{userMacros: d.macros, fiscalYearStartMonth: d.fiscalYearStartMonth}
+	if fiscalYearStartMonth != 0 {
+		if fiscalYearStartMonth < 1 || fiscalYearStartMonth > 12 {
+			return options, fmt.Errorf("invalid fiscal year start month %d, expected 1 to 12", fiscalYearStartMonth)
+		}
+		options.fiscalYearStartMonth = fiscalYearStartMonth
+	}
+	switch {
+	case timezone == "", strings.EqualFold(timezone, "utc"):
+	default:
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return options, fmt.Errorf("unknown timezone %q", timezone)
+		}
+		options.location = location
+	}
+	return options, nil
+}
+
+// macroTime renders a time for the time filter macros. Without location the time is
+// rendered in UTC without offset, which Databricks sessions use by default. Otherwise
+// the local time of the location is rendered with its offset, so the date matches the
+// one of the dashboard and the literal still denotes the same instant.
+func macroTime(t time.Time, location *time.Location) string {
+	if location == nil {
+		return t.UTC().Format("2006-01-02 15:04:05")
+	}
+	return t.In(location).Format("2006-01-02 15:04:05Z07:00")
+}
+
+// searchFilterPattern returns the LIKE pattern matching values starting with the search
+// text of a variable, with the wildcards in the text escaped, as string literal.
+func searchFilterPattern(searchFilter string) string {
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(searchFilter)
+	return quoteString(pattern + "%")
+}
+
+// expandQuoteList expands $__quoteList(values) to a comma separated list of escaped
+// string literals for IN clauses. The values are a JSON array as rendered by
+// ${var:json}, or comma separated as rendered by ${var:csv}. An empty list is expanded
+// to NULL, which matches no value.
+func expandQuoteList(call *macroCall) (string, error) {
+	body := strings.TrimSpace(call.body)
+	var values []string
+	if strings.HasPrefix(body, "[") {
+		var list []interface{}
+		err := json.Unmarshal([]byte(body), &list)
+		if err != nil {
+			return "", fmt.Errorf("invalid JSON array: %w", err)
+		}
+		for _, value := range list {
+			values = append(values, fmt.Sprint(value))
+		}
+	} else if body != "" {
+		for _, value := range strings.Split(body, ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	if len(values) == 0 {
+		return "NULL", nil
+	}
+	literals := make([]string, len(values))
+	for i, value := range values {
+		literals[i] = quoteString(value)
+	}
+	return strings.Join(literals, ", "), nil
+}
+
+// inLocation returns the time in the location, or in UTC if the location is nil.
+func inLocation(t time.Time, location *time.Location) time.Time {
+	if location == nil {
+		return t.UTC()
+	}
+	return t.In(location)
+}
+
+// macroCall is a call of a macro within a query string.
+type macroCall struct {
+	start int
+	end   int
+	// body is the text between the parentheses of the call.
+	body string
+	args []string
+}
+
+// findMacroCall returns the first call of the named macro at or after offset, or nil if
+// there is none. Arguments are split at commas outside of parentheses and quotes and
+// trimmed, so they may contain function calls and string literals.
+func findMacroCall(queryString string, name string, offset int) (*macroCall, error) {
+	prefix := "$__" + name + "("
+	idx := strings.Index(queryString[offset:], prefix)
+	if idx == -1 {
+		return nil, nil
+	}
+	call := &macroCall{start: offset + idx}
+	argStart := call.start + len(prefix)
+	depth := 0
+	i := argStart
+	for i < len(queryString) {
+		c := queryString[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = quotedEnd(queryString, i)
+			continue
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ')':
+			if arg := strings.TrimSpace(queryString[argStart:i]); arg != "" || len(call.args) > 0 {
+				call.args = append(call.args, arg)
+			}
+			call.end = i + 1
+			call.body = queryString[call.start+len(prefix) : i]
+			return call, nil
+		case c == ',' && depth == 0:
+			call.args = append(call.args, strings.TrimSpace(queryString[argStart:i]))
+			argStart = i + 1
+		}
+		i++
+	}
+	return nil, fmt.Errorf("missing closing parenthesis of $__%s", name)
+}
+
+// replaceMacro replaces every call of the named macro by the expression returned by
+// expand for its arguments.
+func replaceMacro(queryString string, name string, expand func(args []string) (string, error)) (string, error) {
+	return replaceMacroCalls(queryString, name, func(call *macroCall) (string, error) {
+		return expand(call.args)
+	})
+}
+
+// replaceMacroCalls replaces every call of the named macro by the expression returned
+// by expand for the call.
+func replaceMacroCalls(queryString string, name string, expand func(call *macroCall) (string, error)) (string, error) {
+	offset := 0
+	for {
+		call, err := findMacroCall(queryString, name, offset)
+		if err != nil || call == nil {
+			return queryString, err
+		}
+		expression, err := expand(call)
+		if err != nil {
+			return "", fmt.Errorf("invalid $__%s: %w", name, err)
+		}
+		queryString = queryString[:call.start] + expression + queryString[call.end:]
+		offset = call.start + len(expression)
+	}
+}
+
+// macroArgs returns an error unless the number of arguments is between minArgs and
+// maxArgs.
+func macroArgs(args []string, minArgs int, maxArgs int) error {
+	if len(args) < minArgs || len(args) > maxArgs {
+		if minArgs == maxArgs {
+			return fmt.Errorf("expected %d arguments, got %d", minArgs, len(args))
+		}
+		return fmt.Errorf("expected %d to %d arguments, got %d", minArgs, maxArgs, len(args))
+	}
+	return nil
+}
+
+// unquoteArg removes the quotes of a quoted macro argument.
+func unquoteArg(arg string) string {
+	if len(arg) >= 2 && (arg[0] == '\'' || arg[0] == '"') && arg[len(arg)-1] == arg[0] {
+		return arg[1 : len(arg)-1]
+	}
+	return arg
+}
+
+// macroInterval parses the interval argument of a macro, a Grafana duration like 5m or
+// 1h, optionally quoted. $__interval and auto use the interval of the query.
+func macroInterval(arg string, query backend.DataQuery) (time.Duration, error) {
+	arg = unquoteArg(arg)
+	if arg == "$__interval" || arg == "${__interval}" || arg == "auto" {
+		return query.Interval, nil
+	}
+	interval, err := gtime.ParseDuration(arg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q", arg)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("interval %q must be positive", arg)
+	}
+	return interval, nil
+}
+
+// timeGroupExpression returns the expression truncating the time column to buckets of
+// the interval. Whole seconds, minutes, hours and days use date_trunc, other intervals
+// are rounded down to a multiple of the interval since the epoch. Days start at
+// midnight of the location if set.
+func timeGroupExpression(column string, interval time.Duration, location *time.Location) string {
+	if interval == 24*time.Hour && location != nil {
+		return localTrunc("DAY", column, location)
+	}
+	switch interval {
+	case time.Second:
+		return fmt.Sprintf("date_trunc('SECOND', %s)", column)
+	case time.Minute:
+		return fmt.Sprintf("date_trunc('MINUTE', %s)", column)
+	case time.Hour:
+		return fmt.Sprintf("date_trunc('HOUR', %s)", column)
+	case 24 * time.Hour:
+		return fmt.Sprintf("date_trunc('DAY', %s)", column)
+	}
+	seconds := int64(interval / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return fmt.Sprintf("timestamp_seconds(floor(unix_timestamp(%s) / %d) * %d)", column, seconds, seconds)
+}
+
+// localTrunc returns the expression truncating the time column to the unit in the
+// timezone of the location, for sessions using UTC.
+func localTrunc(unit string, column string, location *time.Location) string {
+	timezone := quoteString(location.String())
+	return fmt.Sprintf("to_utc_timestamp(date_trunc('%s', from_utc_timestamp(%s, %s)), %s)", unit, column, timezone, timezone)
+}
+
+// fiscalTrunc returns the expression truncating the time column to the start of its
+// fiscal quarter or year. The time is shifted by the months the fiscal year starts
+// after January, truncated and shifted back.
+func fiscalTrunc(unit string, column string, startMonth int, location *time.Location) string {
+	if location != nil {
+		column = fmt.Sprintf("from_utc_timestamp(%s, %s)", column, quoteString(location.String()))
+	}
+	expression := fmt.Sprintf("date_trunc('%s', %s)", unit, column)
+	if offset := startMonth - 1; offset > 0 {
+		expression = fmt.Sprintf("add_months(date_trunc('%s', add_months(%s, -%d)), %d)", unit, column, offset, offset)
+	}
+	if location != nil {
+		expression = fmt.Sprintf("to_utc_timestamp(%s, %s)", expression, quoteString(location.String()))
+	}
+	return expression
+}
+
+// expandFiscalYear expands $__fiscalYear(column) to the fiscal year of the time column,
+// named after the calendar year it ends in.
+func expandFiscalYear(args []string, startMonth int) (string, error) {
+	if err := macroArgs(args, 1, 1); err != nil {
+		return "", err
+	}
+	if startMonth == 1 {
+		return fmt.Sprintf("year(%s)", args[0]), nil
+	}
+	return fmt.Sprintf("year(add_months(%s, %d))", args[0], 13-startMonth), nil
+}
+
+// expandFiscalQuarter expands $__fiscalQuarter(column) to the fiscal quarter, 1 to 4, of
+// the time column.
+func expandFiscalQuarter(args []string, startMonth int) (string, error) {
+	if err := macroArgs(args, 1, 1); err != nil {
+		return "", err
+	}
+	if startMonth == 1 {
+		return fmt.Sprintf("quarter(%s)", args[0]), nil
+	}
+	return fmt.Sprintf("quarter(add_months(%s, -%d))", args[0], startMonth-1), nil
+}
+
+// fiscalPeriodStart returns the start of the fiscal year, or quarter if months is 3,
+// containing the time.
+func fiscalPeriodStart(t time.Time, months int, startMonth int) time.Time {
+	// Months since the start of the fiscal year starting in the calendar year of t
+	sinceStart := int(t.Month()) - startMonth
+	if sinceStart < 0 {
+		sinceStart += 12
+	}
+	return time.Date(t.Year(), t.Month()-time.Month(sinceStart%months), 1, 0, 0, 0, 0, t.Location())
+}
+
+// expandFiscalPeriodStart expands $__fiscalYearStart() and $__fiscalQuarterStart() to a
+// DATE literal of the start of the fiscal year or quarter containing the end of the
+// time range.
+func expandFiscalPeriodStart(args []string, query backend.DataQuery, months int, options macroOptions) (string, error) {
+	if err := macroArgs(args, 0, 0); err != nil {
+		return "", err
+	}
+	start := fiscalPeriodStart(inLocation(query.TimeRange.To, options.location), months, options.fiscalYearStartMonth)
+	return fmt.Sprintf("DATE'%s'", start.Format("2006-01-02")), nil
+}
+
+// calendarUnits maps the calendar intervals of $__timeGroup to the units of date_trunc.
+// Weeks start on Monday like ISO weeks. 1w, 1M and 1y are grouped by calendar as well,
+// as months and years don't have a fixed length.
+var calendarUnits = map[string]string{
+	"week":    "WEEK",
+	"1w":      "WEEK",
+	"month":   "MONTH",
+	"1M":      "MONTH",
+	"quarter": "QUARTER",
+	"year":    "YEAR",
+	"1y":      "YEAR",
+}
+
+// calendarUnit returns the date_trunc unit of a calendar interval argument, or an empty
+// string if the argument isn't a calendar interval.
+func calendarUnit(arg string) string {
+	arg = unquoteArg(arg)
+	if unit, ok := calendarUnits[arg]; ok {
+		return unit
+	}
+	return calendarUnits[strings.ToLower(arg)]
+}
+
+// expandTimeGroup expands $__timeGroup(column, interval[, fill]). The interval is a
+// duration, a calendar interval, i.e. month, or fiscal_quarter or fiscal_year.
+func expandTimeGroup(args []string, query backend.DataQuery, options macroOptions) (string, error) {
+	if err := macroArgs(args, 2, 3); err != nil {
+		return "", err
+	}
+	location := options.location
+	switch strings.ToLower(unquoteArg(args[1])) {
+	case "fiscal_quarter":
+		return fiscalTrunc("QUARTER", args[0], options.fiscalYearStartMonth, location), nil
+	case "fiscal_year":
+		return fiscalTrunc("YEAR", args[0], options.fiscalYearStartMonth, location), nil
+	}
+	if unit := calendarUnit(args[1]); unit != "" {
+		if location != nil {
+			return localTrunc(unit, args[0], location), nil
+		}
+		return fmt.Sprintf("date_trunc('%s', %s)", unit, args[0]), nil
+	}
+	interval, err := macroInterval(args[1], query)
+	if err != nil {
+		return "", err
+	}
+	return timeGroupExpression(args[0], interval, location), nil
+}
+
+// expandUnixEpochFilter expands $__unixEpochFilter(column) for columns storing the time
+// as epoch in the given unit.
+func expandUnixEpochFilter(args []string, query backend.DataQuery, unit time.Duration) (string, error) {
+	if err := macroArgs(args, 1, 1); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s BETWEEN %d AND %d", args[0], query.TimeRange.From.UnixNano()/int64(unit), query.TimeRange.To.UnixNano()/int64(unit)), nil
+}
+
+// expandUnixEpochGroup expands $__unixEpochGroup(column, interval[, fill]) for columns
+// storing the time as epoch in the given unit. The buckets are returned as timestamps.
+func expandUnixEpochGroup(args []string, query backend.DataQuery, unit time.Duration) (string, error) {
+	if err := macroArgs(args, 2, 3); err != nil {
+		return "", err
+	}
+	interval, err := macroInterval(args[1], query)
+	if err != nil {
+		return "", err
+	}
+	size := int64(interval / unit)
+	if size < 1 {
+		size = 1
+	}
+	toTimestamp := "timestamp_seconds"
+	if unit == time.Millisecond {
+		toTimestamp = "timestamp_millis"
+	}
+	return fmt.Sprintf("%s(floor(%s / %d) * %d)", toTimestamp, args[0], size, size), nil
+}
+
+// expandTimeBoundary expands $__timeFrom(format) and $__timeTo(format) to a literal of
+// the time in the given format. Without format a timestamp literal is returned.
+func expandTimeBoundary(args []string, t time.Time, location *time.Location) (string, error) {
+	if err := macroArgs(args, 0, 1); err != nil {
+		return "", err
+	}
+	format := "timestamp"
+	if len(args) == 1 {
+		format = strings.ToLower(unquoteArg(args[0]))
+	}
+	t = inLocation(t, location)
+	switch format {
+	case "timestamp":
+		return fmt.Sprintf("TIMESTAMP'%s'", t.Format("2006-01-02 15:04:05Z07:00")), nil
+	case "iso":
+		return fmt.Sprintf("'%s'", t.Format(time.RFC3339)), nil
+	case "date":
+		return fmt.Sprintf("DATE'%s'", t.Format("2006-01-02")), nil
+	case "epoch":
+		return fmt.Sprint(t.Unix()), nil
+	case "epoch_ms":
+		return fmt.Sprint(t.UnixMilli()), nil
+	}
+	return "", fmt.Errorf("unsupported format %q, expected timestamp, iso, date, epoch or epoch_ms", format)
+}
+
+// expandPartitionFilter expands $__partitionFilter(column[, pattern]) to a filter on a
+// date partition column covering the days of the time range, which lets Databricks
+// prune the partitions outside of it. Without pattern the column is compared with DATE
+// literals, otherwise with strings formatted using the date pattern, i.e. yyyyMMdd for
+// string partition columns.
+func expandPartitionFilter(args []string, query backend.DataQuery, location *time.Location) (string, error) {
+	if err := macroArgs(args, 1, 2); err != nil {
+		return "", err
+	}
+	from, to := inLocation(query.TimeRange.From, location), inLocation(query.TimeRange.To, location)
+	if len(args) == 1 {
+		return fmt.Sprintf("%s BETWEEN DATE'%s' AND DATE'%s'", args[0], from.Format("2006-01-02"), to.Format("2006-01-02")), nil
+	}
+	layout, err := dateLayout(unquoteArg(args[1]))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s BETWEEN %s AND %s", args[0], quoteString(from.Format(layout)), quoteString(to.Format(layout))), nil
+}
+
+// dateLayout converts a Databricks date pattern consisting of yyyy, MM and dd to a Go
+// time layout.
+func dateLayout(pattern string) (string, error) {
+	layout := strings.NewReplacer("yyyy", "2006", "MM", "01", "dd", "02").Replace(pattern)
+	for _, c := range layout {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			return "", fmt.Errorf("unsupported date pattern %q, only yyyy, MM and dd are supported", pattern)
+		}
+	}
+	return layout, nil
+}
+
+// userMacroNameRgx matches valid names of user defined macros.
+var userMacroNameRgx = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// macroPlaceholderRgx matches the positional placeholders $1, $2, ... in the template of
+// a user defined macro.
+var macroPlaceholderRgx = regexp.MustCompile(`\$([0-9]+)`)
+
+// userMacro is a macro defined in the datasource settings. Calls like $__name(a, b) are
+// replaced by the template with the placeholders $1, $2, ... replaced by the arguments.
+type userMacro struct {
+	Name     string `json:"name"`
+	Template string `json:"template"`
+}
+
+// macroName returns the name of the macro without the $__ prefix, which is optional in
+// the settings.
+func (m userMacro) macroName() string {
+	return strings.TrimPrefix(m.Name, "$__")
+}
+
+func (m userMacro) expand(args []string) (string, error) {
+	var err error
+	expression := macroPlaceholderRgx.ReplaceAllStringFunc(m.Template, func(placeholder string) string {
+		i, _ := strconv.Atoi(placeholder[1:])
+		if i < 1 || i > len(args) {
+			err = fmt.Errorf("the template uses %s, but %d arguments were passed", placeholder, len(args))
+			return placeholder
+		}
+		return args[i-1]
+	})
+	return expression, err
+}
+
+// groupMacros are the macros grouping rows into time buckets, which accept a fill as
+// third argument.
+var groupMacros = []string{"timeGroup", "unixEpochGroup", "unixEpochMsGroup"}
+
+// timeGroupFill returns how missing values are filled as set by the fill argument of
+// the group macros, or nil if no fill is set. The fill is NULL, previous or a number.
+func timeGroupFill(queryString string) (*data.FillMissing, error) {
+	var fill *data.FillMissing
+	for _, name := range groupMacros {
+		_, err := replaceMacro(queryString, name, func(args []string) (string, error) {
+			if len(args) < 3 {
+				return "", nil
+			}
+			switch arg := unquoteArg(args[2]); strings.ToLower(arg) {
+			case "null":
+				fill = &data.FillMissing{Mode: data.FillModeNull}
+			case "previous":
+				fill = &data.FillMissing{Mode: data.FillModePrevious}
+			default:
+				value, err := strconv.ParseFloat(arg, 64)
+				if err != nil {
+					return "", fmt.Errorf("invalid fill %q, expected NULL, previous or a number", arg)
+				}
+				fill = &data.FillMissing{Mode: data.FillModeValue, Value: value}
+			}
+			return "", nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fill, nil
+}
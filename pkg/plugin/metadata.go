@@ -0,0 +1,395 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type catalogInfo struct {
+	Name    string            `json:"name"`
+	Comment string            `json:"comment,omitempty"`
+	Owner   string            `json:"owner,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+type schemaInfo struct {
+	Catalog string            `json:"catalog"`
+	Name    string            `json:"name"`
+	Comment string            `json:"comment,omitempty"`
+	Owner   string            `json:"owner,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+type tableInfo struct {
+	Catalog string            `json:"catalog"`
+	Schema  string            `json:"schema"`
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	Comment string            `json:"comment,omitempty"`
+	Owner   string            `json:"owner,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+type columnInfo struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Nullable bool              `json:"nullable"`
+	Comment  string            `json:"comment,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+type defaultsResponseBody struct {
+	DefaultCatalog string `json:"defaultCatalog"`
+	DefaultSchema  string `json:"defaultSchema"`
+}
+
+// metadataRequest handles the GET requests of the catalogs, schemas, tables and columns
+// used by the query editor:
+//
+//	catalogs
+//	catalogs/{catalog}/schemas
+//	schemas/{schema}/tables
+//	tables/{table}/columns
+//	tables/{table}/detail
+//	tables/{table}/columns/{column}/stats?sample=10
+//	defaults
+//	functions?udfs=true
+//
+// Schemas and tables can be qualified, i.e. schemas/main.sales/tables, unqualified names
+// are resolved against the current catalog and schema. Path segments are URL encoded.
+// Responses are cached as configured by the metadata cache TTL.
+func (d *Datasource) metadataRequest(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, db *sql.DB) error {
+	segments := strings.Split(req.Path, "/")
+	for i, segment := range segments {
+		s, err := url.PathUnescape(segment)
+		if err != nil {
+			return sendError(sender, http.StatusBadRequest, err)
+		}
+		segments[i] = s
+	}
+
+	key := strings.Join(segments, "/")
+	var load func() (interface{}, error)
+	switch {
+	case len(segments) == 1 && segments[0] == "catalogs":
+		load = func() (interface{}, error) { return catalogs(ctx, db, d.filterMetadata) }
+	case len(segments) == 3 && segments[0] == "catalogs" && segments[2] == "schemas":
+		load = func() (interface{}, error) { return schemas(ctx, db, segments[1], d.filterMetadata) }
+	case len(segments) == 3 && segments[0] == "schemas" && segments[2] == "tables":
+		schema := splitIdentifier(segments[1])
+		if len(schema) == 0 || len(schema) > 2 {
+			return sendError(sender, http.StatusBadRequest, fmt.Errorf("invalid schema %q", segments[1]))
+		}
+		load = func() (interface{}, error) { return tables(ctx, db, schema, d.filterMetadata) }
+	case len(segments) == 3 && segments[0] == "tables" && segments[2] == "columns":
+		table := splitIdentifier(segments[1])
+		if len(table) == 0 || len(table) > 3 {
+			return sendError(sender, http.StatusBadRequest, fmt.Errorf("invalid table %q", segments[1]))
+		}
+		load = func() (interface{}, error) { return columns(ctx, db, table) }
+	case len(segments) == 3 && segments[0] == "tables" && segments[2] == "detail":
+		table := splitIdentifier(segments[1])
+		if len(table) == 0 || len(table) > 3 {
+			return sendError(sender, http.StatusBadRequest, fmt.Errorf("invalid table %q", segments[1]))
+		}
+		load = func() (interface{}, error) { return describeTableDetail(ctx, db, table, d.checkStatements) }
+	case len(segments) == 5 && segments[0] == "tables" && segments[2] == "columns" && segments[4] == "stats":
+		table := splitIdentifier(segments[1])
+		if len(table) == 0 || len(table) > 3 {
+			return sendError(sender, http.StatusBadRequest, fmt.Errorf("invalid table %q", segments[1]))
+		}
+		sample := 0
+		if u, err := url.Parse(req.URL); err == nil && u.Query().Get("sample") != "" {
+			sample, err = strconv.Atoi(u.Query().Get("sample"))
+			if err != nil || sample < 1 || sample > 100 {
+				return sendError(sender, http.StatusBadRequest, fmt.Errorf("invalid sample %q, expected a percentage between 1 and 100", u.Query().Get("sample")))
+			}
+		}
+		key = fmt.Sprintf("%s?sample=%d", key, sample)
+		load = func() (interface{}, error) {
+			return profileColumn(ctx, db, table, segments[3], sample, d.checkStatements)
+		}
+	case len(segments) == 1 && segments[0] == "defaults":
+		load = func() (interface{}, error) { return defaults(ctx, db) }
+	case len(segments) == 1 && segments[0] == "functions":
+		udfs := false
+		if u, err := url.Parse(req.URL); err == nil {
+			udfs = u.Query().Get("udfs") == "true"
+		}
+		key = fmt.Sprintf("functions?udfs=%t", udfs)
+		load = func() (interface{}, error) { return functionCatalog(ctx, db, udfs), nil }
+	default:
+		logger.Error("CallResource Error", "err", "Unknown URL")
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusNotFound,
+			Body:   []byte("Unknown URL"),
+		})
+	}
+
+	jsonBody, err := d.metadataCache.get(runningQueryKey(req.PluginContext, key), func() ([]byte, error) {
+		result, err := load()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(result)
+	})
+	if err != nil {
+		logger.Error("CallResource Error", "path", req.Path, "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
+
+// sendError responds with the error as JSON body.
+func sendError(sender backend.CallResourceResponseSender, status int, err error) error {
+	logger.Error("CallResource Error", "err", err)
+	jsonBody, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return sender.Send(&backend.CallResourceResponse{
+		Status: status,
+		Body:   jsonBody,
+	})
+}
+
+// catalogs returns the catalogs, only the ones the principal can use if usableOnly is set.
+func catalogs(ctx context.Context, db *sql.DB, usableOnly bool) ([]catalogInfo, error) {
+	condition := "true"
+	if usableOnly {
+		condition = privilegeCondition("c.catalog_owner", "system.information_schema.catalog_privileges",
+			"p.catalog_name = c.catalog_name", "USE CATALOG")
+	}
+	rows, err := queryStrings(ctx, db, "SELECT catalog_name, comment, catalog_owner FROM system.information_schema.catalogs c WHERE "+condition+" ORDER BY catalog_name")
+	if err != nil {
+		return nil, err
+	}
+	tags := objectTags(ctx, db, "SELECT catalog_name, tag_name, tag_value FROM system.information_schema.catalog_tags")
+	catalogs := make([]catalogInfo, 0, len(rows))
+	for _, row := range rows {
+		catalogs = append(catalogs, catalogInfo{Name: row[0], Comment: row[1], Owner: row[2], Tags: tags[row[0]]})
+	}
+	return catalogs, nil
+}
+
+// schemas returns the schemas of the catalog, only the ones the principal can use if
+// usableOnly is set.
+func schemas(ctx context.Context, db *sql.DB, catalog string, usableOnly bool) ([]schemaInfo, error) {
+	condition := "true"
+	if usableOnly {
+		condition = privilegeCondition("s.schema_owner", quoteIdentifier(catalog)+".information_schema.schema_privileges",
+			"p.catalog_name = s.catalog_name AND p.schema_name = s.schema_name", "USE SCHEMA")
+	}
+	rows, err := queryStrings(ctx, db, fmt.Sprintf(
+		"SELECT catalog_name, schema_name, comment, schema_owner FROM %s.information_schema.schemata s WHERE %s ORDER BY schema_name",
+		quoteIdentifier(catalog),
+		condition,
+	))
+	if err != nil {
+		return nil, err
+	}
+	tags := objectTags(ctx, db, fmt.Sprintf(
+		"SELECT schema_name, tag_name, tag_value FROM %s.information_schema.schema_tags",
+		quoteIdentifier(catalog),
+	))
+	schemas := make([]schemaInfo, 0, len(rows))
+	for _, row := range rows {
+		schemas = append(schemas, schemaInfo{Catalog: row[0], Name: row[1], Comment: row[2], Owner: row[3], Tags: tags[row[1]]})
+	}
+	return schemas, nil
+}
+
+// tables returns the tables of the schema, given as [catalog, schema] or [schema], only
+// the ones the principal can select from if usableOnly is set.
+func tables(ctx context.Context, db *sql.DB, schema []string, usableOnly bool) ([]tableInfo, error) {
+	schemaName := quoteString(strings.ToLower(schema[len(schema)-1]))
+	condition := "true"
+	if usableOnly {
+		condition = privilegeCondition("t.table_owner", informationSchemaTable(schema[:len(schema)-1], "table_privileges"),
+			"p.table_catalog = t.table_catalog AND p.table_schema = t.table_schema AND p.table_name = t.table_name", "SELECT")
+	}
+	rows, err := queryStrings(ctx, db, fmt.Sprintf(
+		"SELECT table_catalog, table_schema, table_name, table_type, comment, table_owner FROM %s t WHERE table_schema = %s AND %s ORDER BY table_name",
+		informationSchemaTable(schema[:len(schema)-1], "tables"),
+		schemaName,
+		condition,
+	))
+	if err != nil {
+		return nil, err
+	}
+	tags := objectTags(ctx, db, fmt.Sprintf(
+		"SELECT table_name, tag_name, tag_value FROM %s WHERE schema_name = %s",
+		informationSchemaTable(schema[:len(schema)-1], "table_tags"),
+		schemaName,
+	))
+	tables := make([]tableInfo, 0, len(rows))
+	for _, row := range rows {
+		tables = append(tables, tableInfo{Catalog: row[0], Schema: row[1], Name: row[2], Type: row[3], Comment: row[4], Owner: row[5], Tags: tags[row[2]]})
+	}
+	return tables, nil
+}
+
+// columns returns the columns of the table, given as [catalog, schema, table],
+// [schema, table] or [table].
+func columns(ctx context.Context, db *sql.DB, table []string) ([]columnInfo, error) {
+	schemaCondition := "current_schema()"
+	if len(table) > 1 {
+		schemaCondition = quoteString(strings.ToLower(table[len(table)-2]))
+	}
+	var catalog []string
+	if len(table) == 3 {
+		catalog = table[:1]
+	}
+	tableName := quoteString(strings.ToLower(table[len(table)-1]))
+	rows, err := queryStrings(ctx, db, fmt.Sprintf(
+		"SELECT column_name, full_data_type, is_nullable, comment FROM %s WHERE table_schema = %s AND table_name = %s ORDER BY ordinal_position",
+		informationSchemaTable(catalog, "columns"),
+		schemaCondition,
+		tableName,
+	))
+	if err != nil {
+		return nil, err
+	}
+	tags := objectTags(ctx, db, fmt.Sprintf(
+		"SELECT column_name, tag_name, tag_value FROM %s WHERE schema_name = %s AND table_name = %s",
+		informationSchemaTable(catalog, "column_tags"),
+		schemaCondition,
+		tableName,
+	))
+	columns := make([]columnInfo, 0, len(rows))
+	for _, row := range rows {
+		columns = append(columns, columnInfo{Name: row[0], Type: row[1], Nullable: row[2] == "YES", Comment: row[3], Tags: tags[row[0]]})
+	}
+	return columns, nil
+}
+
+func defaults(ctx context.Context, db *sql.DB) (defaultsResponseBody, error) {
+	rows, err := queryStrings(ctx, db, "SELECT current_catalog(), current_schema()")
+	if err != nil || len(rows) == 0 {
+		return defaultsResponseBody{}, err
+	}
+	return defaultsResponseBody{DefaultCatalog: rows[0][0], DefaultSchema: rows[0][1]}, nil
+}
+
+// privilegeCondition returns a condition matching the objects owned by the principal or
+// one of its groups, or on which it or one of its groups was granted the privilege or
+// ALL PRIVILEGES. The privileges view lists the privileges inherited from the catalog
+// and schema too, match correlates it with the listed object.
+func privilegeCondition(owner string, privileges string, match string, privilege string) string {
+	return fmt.Sprintf(
+		"(%[1]s = current_user() OR is_account_group_member(%[1]s) OR EXISTS (SELECT 1 FROM %[2]s p WHERE %[3]s AND p.privilege_type IN (%[4]s, 'ALL PRIVILEGES') AND (p.grantee = current_user() OR is_account_group_member(p.grantee))))",
+		owner, privileges, match, quoteString(privilege),
+	)
+}
+
+// objectTags looks up the Unity Catalog tags with a query returning the name of the
+// tagged object, the tag name and the tag value, by object name. Tags aren't available
+// for all catalogs, errors looking them up are only logged.
+func objectTags(ctx context.Context, db *sql.DB, query string) map[string]map[string]string {
+	rows, err := queryStrings(ctx, db, query)
+	if err != nil {
+		logger.Info("Tags Error", "err", err)
+		return nil
+	}
+	tags := make(map[string]map[string]string)
+	for _, row := range rows {
+		if tags[row[0]] == nil {
+			tags[row[0]] = make(map[string]string)
+		}
+		tags[row[0]][row[1]] = row[2]
+	}
+	return tags
+}
+
+// informationSchemaTable returns the name of a table of the information_schema of the
+// catalog, or of the current catalog if catalog is empty.
+func informationSchemaTable(catalog []string, table string) string {
+	if len(catalog) == 0 {
+		return "information_schema." + table
+	}
+	return quoteIdentifier(catalog[0]) + ".information_schema." + table
+}
+
+// queryStrings executes the query and returns its rows as strings, null values are
+// returned as empty strings.
+func queryStrings(ctx context.Context, db *sql.DB, query string) ([][]string, error) {
+	_, rows, err := queryResult(ctx, db, query)
+	return rows, err
+}
+
+// queryRecords executes the query and returns its rows as maps of the column names to
+// the values as strings.
+func queryRecords(ctx context.Context, db *sql.DB, query string) ([]map[string]string, error) {
+	columns, rows, err := queryResult(ctx, db, query)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		records[i] = make(map[string]string, len(columns))
+		for j, column := range columns {
+			records[i][column] = row[j]
+		}
+	}
+	return records, nil
+}
+
+func queryResult(ctx context.Context, db *sql.DB, query string) ([]string, [][]string, error) {
+	columns, rows, err := queryNullStrings(ctx, db, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := make([][]string, len(rows))
+	for i, values := range rows {
+		result[i] = make([]string, len(values))
+		for j, v := range values {
+			result[i][j] = v.String
+		}
+	}
+	return columns, result, nil
+}
+
+// queryNullStrings executes the query and returns its column names and rows, keeping
+// null values apart from empty strings.
+func queryNullStrings(ctx context.Context, db *sql.DB, query string) ([]string, [][]sql.NullString, error) {
+	logger.Info("CallResource called", "queryString", query)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var result [][]sql.NullString
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, err
+		}
+		result = append(result, values)
+	}
+	return columns, result, rows.Err()
+}
+
+// splitIdentifier splits a qualified identifier like catalog.`my schema` into its
+// unquoted parts.
+func splitIdentifier(name string) []string {
+	parts := identifierPartRgx.FindAllString(name, -1)
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(strings.Trim(part, "`"), "``", "`")
+	}
+	return parts
+}
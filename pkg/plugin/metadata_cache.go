@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultMetadataCacheTTL is the time looked up metadata is cached, if not configured.
+const defaultMetadataCacheTTL = 5 * time.Minute
+
+type metadataCacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+// metadataCache caches the responses of the metadata resources, so the query editor
+// doesn't query the warehouse on every keystroke. Responses are cached per org and
+// user, since the visible catalogs depend on the credentials.
+type metadataCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]metadataCacheEntry
+}
+
+// newMetadataCache creates a cache for the configured TTL in seconds. Zero uses the
+// default TTL, a negative value disables caching and nil is returned.
+func newMetadataCache(ttlSeconds int) *metadataCache {
+	if ttlSeconds < 0 {
+		return nil
+	}
+	ttl := time.Duration(ttlSeconds) * time.Second
+	if ttl == 0 {
+		ttl = defaultMetadataCacheTTL
+	}
+	return &metadataCache{ttl: ttl, entries: make(map[string]metadataCacheEntry)}
+}
+
+// get returns the cached response for the key, or loads and caches it. Errors are not
+// cached. A nil cache always loads the response.
+func (c *metadataCache) get(key string, load func() ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return load()
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.body, nil
+	}
+
+	body, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = metadataCacheEntry{body: body, expires: now.Add(c.ttl)}
+	return body, nil
+}
+
+// clear removes all cached responses.
+func (c *metadataCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]metadataCacheEntry)
+}
+
+// refreshMetadata handles the resource request clearing the metadata cache, i.e. after
+// tables were created.
+func (d *Datasource) refreshMetadata(sender backend.CallResourceResponseSender) error {
+	d.metadataCache.clear()
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusNoContent,
+	})
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// bindParameters replaces named parameter markers (`:name`) in the query with the
+// values of the parameters map rendered as typed SQL literals. Markers without a
+// matching parameter are left as is.
+//
+// The databricks-sql-go driver version used does not support server side parameter
+// binding yet, therefore values are rendered client side. Strings are always quoted
+// and escaped and numbers are validated, so parameter values can never change the
+// structure of the statement. Markers inside string literals, quoted identifiers and
+// comments are left untouched.
+func bindParameters(queryString string, parameters map[string]interface{}) (string, error) {
+	if len(parameters) == 0 {
+		return queryString, nil
+	}
+
+	var sb strings.Builder
+	i := 0
+	for i < len(queryString) {
+		c := queryString[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := quotedEnd(queryString, i)
+			sb.WriteString(queryString[i:end])
+			i = end
+		case c == '-' && strings.HasPrefix(queryString[i:], "--"):
+			end := strings.IndexByte(queryString[i:], '\n')
+			if end == -1 {
+				end = len(queryString)
+			} else {
+				end += i
+			}
+			sb.WriteString(queryString[i:end])
+			i = end
+		case c == '/' && strings.HasPrefix(queryString[i:], "/*"):
+			end := strings.Index(queryString[i+2:], "*/")
+			if end == -1 {
+				end = len(queryString)
+			} else {
+				end += i + 4
+			}
+			sb.WriteString(queryString[i:end])
+			i = end
+		case c == ':' && (i == 0 || queryString[i-1] != ':') && i+1 < len(queryString) && isIdentifierStart(queryString[i+1]):
+			end := i + 1
+			for end < len(queryString) && isIdentifierPart(queryString[end]) {
+				end++
+			}
+			name := queryString[i+1 : end]
+			value, ok := parameters[name]
+			if !ok {
+				// Not a parameter, i.e. a JSON path expression like raw:field
+				sb.WriteString(queryString[i:end])
+				i = end
+				continue
+			}
+			literal, err := sqlLiteral(value)
+			if err != nil {
+				return "", fmt.Errorf("invalid value for query parameter :%s: %w", name, err)
+			}
+			sb.WriteString(literal)
+			i = end
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String(), nil
+}
+
+// quotedEnd returns the index after the closing quote of the quoted section starting
+// at start. Backslash escapes and doubled quotes are respected.
+func quotedEnd(queryString string, start int) int {
+	quote := queryString[start]
+	i := start + 1
+	for i < len(queryString) {
+		switch queryString[i] {
+		case '\\':
+			if quote != '`' {
+				i += 2
+				continue
+			}
+		case quote:
+			if i+1 < len(queryString) && queryString[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return len(queryString)
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierPart(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
+
+// quoteString renders a string as an escaped Databricks SQL string literal.
+func quoteString(value string) string {
+	return "'" + escapeString(value) + "'"
+}
+
+// escapeString escapes a string so it can be used within a SQL string literal.
+func escapeString(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, `'`, `\'`)
+}
+
+// sqlLiteral renders a JSON decoded value as SQL literal. Arrays are rendered as comma
+// separated list, so they can be used within IN clauses.
+func sqlLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return quoteString(v), nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		_, err := strconv.ParseFloat(v.String(), 64)
+		if err != nil {
+			return "", err
+		}
+		return v.String(), nil
+	case []interface{}:
+		if len(v) == 0 {
+			return "", fmt.Errorf("empty list")
+		}
+		literals := make([]string, 0, len(v))
+		for _, item := range v {
+			literal, err := sqlLiteral(item)
+			if err != nil {
+				return "", err
+			}
+			literals = append(literals, literal)
+		}
+		return strings.Join(literals, ", "), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", value)
+	}
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBindParameters(t *testing.T) {
+	parameters := map[string]interface{}{
+		"name":   "o'brien",
+		"path":   `C:\temp`,
+		"limit":  float64(10),
+		"exact":  json.Number("12345678901234567890"),
+		"flag":   true,
+		"empty":  nil,
+		"ids":    []interface{}{float64(1), "two"},
+		"inject": "'; DROP TABLE t; --",
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"string", "SELECT * FROM t WHERE name = :name", `SELECT * FROM t WHERE name = 'o\'brien'`},
+		{"backslash", "SELECT :path", `SELECT 'C:\\temp'`},
+		{"number", "SELECT * FROM t LIMIT :limit", "SELECT * FROM t LIMIT 10"},
+		{"json number", "SELECT :exact", "SELECT 12345678901234567890"},
+		{"bool and null", "SELECT :flag, :empty", "SELECT TRUE, NULL"},
+		{"list", "SELECT * FROM t WHERE id IN (:ids)", "SELECT * FROM t WHERE id IN (1, 'two')"},
+		{"injection", "SELECT * FROM t WHERE name = :inject", `SELECT * FROM t WHERE name = '\'; DROP TABLE t; --'`},
+		{"adjacent punctuation", "SELECT (:limit)+:limit", "SELECT (10)+10"},
+		{"unknown parameter", "SELECT :other", "SELECT :other"},
+		{"marker in single quotes", "SELECT ':name'", "SELECT ':name'"},
+		{"marker in double quotes", `SELECT ":name"`, `SELECT ":name"`},
+		{"marker in backticks", "SELECT `:name`", "SELECT `:name`"},
+		{"marker after escaped quote", `SELECT 'it\'s :name', :name`, `SELECT 'it\'s :name', 'o\'brien'`},
+		{"marker after doubled quote", "SELECT 'it''s :name', :name", `SELECT 'it''s :name', 'o\'brien'`},
+		{"marker in line comment", "SELECT 1 -- :name\n, :name", "SELECT 1 -- :name\n, 'o\\'brien'"},
+		{"marker in block comment", "SELECT /* :name */ :name", `SELECT /* :name */ 'o\'brien'`},
+		{"cast", "SELECT a::name, :name::STRING", `SELECT a::name, 'o\'brien'::STRING`},
+		{"marker prefix", "SELECT :names", "SELECT :names"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bindParameters(tt.query, parameters)
+			if err != nil {
+				t.Fatalf("bindParameters(%q) returned error %v", tt.query, err)
+			}
+			if got != tt.want {
+				t.Errorf("bindParameters(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindParametersInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"invalid number", json.Number("1; DROP TABLE t")},
+		{"empty list", []interface{}{}},
+		{"object", map[string]interface{}{"a": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bindParameters("SELECT :p", map[string]interface{}{"p": tt.value})
+			if err == nil {
+				t.Errorf("bindParameters accepted %v", tt.value)
+			}
+		})
+	}
+}
+
+func TestBindParametersWithoutParameters(t *testing.T) {
+	query := "SELECT :name"
+	got, err := bindParameters(query, nil)
+	if err != nil || got != query {
+		t.Errorf("bindParameters(%q, nil) = %q, %v", query, got, err)
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	_ "github.com/databricks/databricks-sql-go"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/databricks/databricks-sql-go/auth/pat"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
-	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
-	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
-	"reflect"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,61 +33,583 @@ var (
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 	_ backend.CallResourceHandler   = (*Datasource)(nil)
+	_ backend.StreamHandler         = (*Datasource)(nil)
 )
 
 type DatasourceSettings struct {
-	Path     string `json:"path"`
-	Hostname string `json:"hostname"`
-	Port     string `json:"port"`
+	Path                    string              `json:"path"`
+	Hostname                string              `json:"hostname"`
+	Port                    string              `json:"port"`
+	AuthenticationMethod    string              `json:"authenticationMethod"`
+	ClientId                string              `json:"clientId"`
+	TenantId                string              `json:"tenantId"`
+	OAuthPassThru           bool                `json:"oauthPassThru"`
+	TLSSkipVerify           bool                `json:"tlsSkipVerify"`
+	TLSAuth                 bool                `json:"tlsAuth"`
+	TLSAuthWithCACert       bool                `json:"tlsAuthWithCACert"`
+	ServerName              string              `json:"serverName"`
+	ProxyUrl                string              `json:"proxyUrl"`
+	ProxyUsername           string              `json:"proxyUsername"`
+	ReadOnly                bool                `json:"readOnly"`
+	StatementAllowlist      []string            `json:"statementAllowlist"`
+	StatementDenylist       []string            `json:"statementDenylist"`
+	CredentialMappings      []credentialMapping `json:"credentialMappings"`
+	Catalog                 string              `json:"catalog"`
+	Schema                  string              `json:"schema"`
+	SessionParameters       map[string]string   `json:"sessionParameters"`
+	MaxOpenConns            int                 `json:"maxOpenConns"`
+	MaxIdleConns            int                 `json:"maxIdleConns"`
+	ConnMaxLifetime         int                 `json:"connMaxLifetime"`
+	ConnMaxIdleTime         int                 `json:"connMaxIdleTime"`
+	QueryTimeout            int                 `json:"queryTimeout"`
+	MaxRetries              int                 `json:"maxRetries"`
+	WarehouseStartTimeout   int                 `json:"warehouseStartTimeout"`
+	Warehouses              []warehouse         `json:"warehouses"`
+	KeepAlive               bool                `json:"keepAlive"`
+	KeepAliveInterval       int                 `json:"keepAliveInterval"`
+	UserAgent               string              `json:"userAgent"`
+	CircuitBreakerThreshold int                 `json:"circuitBreakerThreshold"`
+	CircuitBreakerCooldown  int                 `json:"circuitBreakerCooldown"`
+	PoolDrainTimeout        int                 `json:"poolDrainTimeout"`
+	MaxConcurrentQueries    int                 `json:"maxConcurrentQueries"`
+	ExecutionMode           string              `json:"executionMode"`
+	ResultDisposition       string              `json:"resultDisposition"`
+	CloudFetch              bool                `json:"cloudFetch"`
+	MaxDownloadThreads      int                 `json:"maxDownloadThreads"`
+	MaxRows                 int64               `json:"maxRows"`
+	MaxRunningQueries       int                 `json:"maxRunningQueries"`
+	QueueTimeout            int                 `json:"queueTimeout"`
+	QueryDedupWindow        int                 `json:"queryDedupWindow"`
+	QueryMetrics            bool                `json:"queryMetrics"`
+	QueryTags               bool                `json:"queryTags"`
+	AlertQueryTimeout       int                 `json:"alertQueryTimeout"`
+	Macros                  []userMacro         `json:"macros"`
+	FiscalYearStartMonth    int                 `json:"fiscalYearStartMonth"`
+	DecimalAsString         bool                `json:"decimalAsString"`
+	TimestampNtzTimezone    string              `json:"timestampNtzTimezone"`
+	MaxCellSize             int                 `json:"maxCellSize"`
+	MetadataCacheTTL        int                 `json:"metadataCacheTtl"`
+	FilterMetadata          bool                `json:"filterMetadata"`
+	ResultCacheTTL          int                 `json:"resultCacheTtl"`
+	ResultCacheMaxSize      int                 `json:"resultCacheMaxSize"`
+	ResultCacheDir          string              `json:"resultCacheDir"`
+}
+
+// fiscalYearStartMonth returns the configured first month of the fiscal year, January
+// if not configured or invalid.
+func fiscalYearStartMonth(month int) int {
+	if month < 1 || month > 12 {
+		return 1
+	}
+	return month
+}
+
+// defaultMaxRows is the number of rows fetched if no row limit is configured.
+const defaultMaxRows = 1000000
+
+// maxRows returns the row limit for the configured value. Zero uses the default, a
+// negative value disables the limit.
+func maxRows(limit int64) int64 {
+	if limit == 0 {
+		return defaultMaxRows
+	}
+	if limit < 0 {
+		return -1
+	}
+	return limit
+}
+
+// defaultDrainTimeout is the time queries of a disposed instance can continue to run.
+const defaultDrainTimeout = 2 * time.Minute
+
+func drainTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultDrainTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// defaultMaxConcurrentQueries is the number of queries of a request executed in parallel.
+const defaultMaxConcurrentQueries = 5
+
+func maxConcurrentQueries(limit int) int {
+	if limit <= 0 {
+		return defaultMaxConcurrentQueries
+	}
+	return limit
 }
 
 // NewSampleDatasource creates a new datasource instance.
 func NewSampleDatasource(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
 	datasourceSettings := new(DatasourceSettings)
-	err := json.Unmarshal(settings.JSONData, datasourceSettings)
-	if err != nil {
-		log.DefaultLogger.Info("Setting Parse Error", "err", err)
+	if len(settings.JSONData) > 0 {
+		err := json.Unmarshal(settings.JSONData, datasourceSettings)
+		if err != nil {
+			logger.Error("Setting Parse Error", "err", err)
+			return nil, fmt.Errorf("invalid datasource settings: %w", err)
+		}
 	}
 	port := "443"
 	if datasourceSettings.Port != "" {
 		port = datasourceSettings.Port
 	}
-	databricksConnectionsString := fmt.Sprintf("token:%s@%s:%s/%s", settings.DecryptedSecureJSONData["token"], datasourceSettings.Hostname, port, datasourceSettings.Path)
-	databricksDB := &sql.DB{}
-	if databricksConnectionsString != "" {
-		log.DefaultLogger.Info("Init Databricks SQL DB")
-		db, err := sql.Open("databricks", databricksConnectionsString)
+	portInt, err := strconv.Atoi(port)
+	if err != nil {
+		logger.Error("Port Parse Error", "err", err)
+		return nil, fmt.Errorf("invalid server port %q: %w", port, err)
+	}
+	proxyOptions, err := settings.ProxyOptions()
+	if err != nil {
+		logger.Error("Proxy Settings Parse Error", "err", err)
+		return nil, fmt.Errorf("invalid secure socks proxy settings: %w", err)
+	}
+	proxyURL, err := newProxyURL(datasourceSettings, settings.DecryptedSecureJSONData)
+	if err != nil {
+		logger.Error("Proxy Settings Parse Error", "err", err)
+		return nil, fmt.Errorf("invalid proxy settings: %w", err)
+	}
+	transport, err := newTransport(newTLSSettings(datasourceSettings, settings.DecryptedSecureJSONData), proxyURL, proxyOptions)
+	if err != nil {
+		logger.Error("Transport Settings Error", "err", err)
+		return nil, fmt.Errorf("invalid TLS settings: %w", err)
+	}
+	downloadTransport, err := newTransport(tlsSettings{}, proxyURL, proxyOptions)
+	if err != nil {
+		logger.Error("Transport Settings Error", "err", err)
+		return nil, fmt.Errorf("invalid proxy settings: %w", err)
+	}
+	connection := connectionSettings{
+		hostname:           datasourceSettings.Hostname,
+		port:               portInt,
+		path:               datasourceSettings.Path,
+		transport:          transport,
+		downloadTransport:  downloadTransport,
+		catalog:            datasourceSettings.Catalog,
+		schema:             datasourceSettings.Schema,
+		sessionParams:      datasourceSettings.SessionParameters,
+		pool:               newPoolSettings(datasourceSettings),
+		queryTimeout:       time.Duration(datasourceSettings.QueryTimeout) * time.Second,
+		warehouses:         newWarehouseMap(datasourceSettings.Warehouses),
+		userAgentEntry:     newUserAgentEntry(datasourceSettings.UserAgent),
+		cloudFetch:         datasourceSettings.CloudFetch,
+		maxDownloadThreads: datasourceSettings.MaxDownloadThreads,
+	}
+	provider, err := newTokenProvider(datasourceSettings.Hostname, transport, credentials{
+		AuthenticationMethod: datasourceSettings.AuthenticationMethod,
+		TenantId:             datasourceSettings.TenantId,
+		ClientId:             datasourceSettings.ClientId,
+		Token:                settings.DecryptedSecureJSONData["token"],
+		ClientSecret:         settings.DecryptedSecureJSONData["clientSecret"],
+		ServiceAccountKey:    settings.DecryptedSecureJSONData["serviceAccountKey"],
+	})
+	if err != nil {
+		logger.Error("Authentication Settings Error", "err", err)
+		return nil, fmt.Errorf("invalid authentication settings: %w", err)
+	}
+	if provider == nil {
+		provider = &staticTokenProvider{token: settings.DecryptedSecureJSONData["token"]}
+	}
+	logger.Info("Init Databricks SQL DB", "authenticationMethod", datasourceSettings.AuthenticationMethod)
+	pools, err := newPoolSet(connection, newTokenAuthenticator(provider))
+	if err != nil {
+		logger.Error("DB Init Error", "err", err)
+		return nil, fmt.Errorf("failed to initialize the Databricks connection: %w", err)
+	}
+	logger.Info("Store Databricks SQL DB Connection")
+
+	credentialMappings := make([]*credentialMappingPool, 0, len(datasourceSettings.CredentialMappings))
+	for i, mapping := range datasourceSettings.CredentialMappings {
+		pool, err := newCredentialMappingPool(connection, mapping, i, settings.DecryptedSecureJSONData)
 		if err != nil {
-			log.DefaultLogger.Info("DB Init Error", "err", err)
-		} else {
-			databricksDB = db
-			databricksDB.SetConnMaxIdleTime(6 * time.Hour)
-			log.DefaultLogger.Info("Store Databricks SQL DB Connection")
+			logger.Error("Credential Mapping Error", "index", i, "err", err)
+			return nil, fmt.Errorf("invalid credential mapping %d: %w", i, err)
 		}
+		credentialMappings = append(credentialMappings, pool)
 	}
 
-	return &Datasource{
-		databricksConnectionsString: databricksConnectionsString,
-		databricksDB:                databricksDB,
-	}, nil
+	filter := newStatementFilter(datasourceSettings.StatementAllowlist, datasourceSettings.StatementDenylist)
+	if filter.err != nil {
+		logger.Info("Statement Filter Parse Error", "err", filter.err)
+	}
+
+	warehouseStartTimeout := time.Duration(datasourceSettings.WarehouseStartTimeout) * time.Second
+	warehouseMonitors := map[string]*warehouseMonitor{
+		"": newWarehouseMonitor(connection, warehouseStartTimeout),
+	}
+	for name := range connection.warehouses {
+		warehouseConnection, _ := connection.forWarehouse(name)
+		warehouseMonitors[name] = newWarehouseMonitor(warehouseConnection, warehouseStartTimeout)
+	}
+
+	datasource := &Datasource{
+		pools:                pools,
+		connection:           connection,
+		oauthPassThru:        datasourceSettings.OAuthPassThru,
+		readOnly:             datasourceSettings.ReadOnly,
+		statementFilter:      filter,
+		credentialMappings:   credentialMappings,
+		queryTimeout:         connection.queryTimeout,
+		alertQueryTimeout:    time.Duration(datasourceSettings.AlertQueryTimeout) * time.Second,
+		maxRows:              maxRows(datasourceSettings.MaxRows),
+		maxCellSize:          maxCellSize(datasourceSettings.MaxCellSize),
+		running:              newRunningQueries(),
+		asyncQueries:         newAsyncQueries(),
+		liveQueries:          newLiveQueries(),
+		progress:             newProgressTracker(),
+		dedup:                newQueryDeduplicator(datasourceSettings.QueryDedupWindow),
+		metadataCache:        newMetadataCache(datasourceSettings.MetadataCacheTTL),
+		resultCache:          newResultCache(datasourceSettings.ResultCacheTTL, datasourceSettings.ResultCacheMaxSize, datasourceSettings.ResultCacheDir, settings.UID),
+		filterMetadata:       datasourceSettings.FilterMetadata,
+		queryTags:            datasourceSettings.QueryTags,
+		macros:               datasourceSettings.Macros,
+		fiscalYearStartMonth: fiscalYearStartMonth(datasourceSettings.FiscalYearStartMonth),
+		conversion:           newConversionOptions(datasourceSettings),
+		queue:                newQueryQueue(datasourceSettings.MaxRunningQueries, datasourceSettings.QueueTimeout),
+		retry:                newRetryPolicy(datasourceSettings.MaxRetries),
+		warehouseMonitors:    warehouseMonitors,
+		settingsErrors:       validateSettings(datasourceSettings, settings.DecryptedSecureJSONData),
+		drained:              make(chan struct{}),
+		drainTimeout:         drainTimeout(datasourceSettings.PoolDrainTimeout),
+		maxConcurrentQueries: maxConcurrentQueries(datasourceSettings.MaxConcurrentQueries),
+		breaker:              newCircuitBreaker(datasourceSettings.CircuitBreakerThreshold, time.Duration(datasourceSettings.CircuitBreakerCooldown)*time.Second),
+	}
+	if datasourceSettings.ExecutionMode == executionModeStatementAPI {
+		datasource.statementAPI = newStatementAPIClient(connection, datasourceSettings.ResultDisposition)
+	}
+	if datasourceSettings.QueryMetrics {
+		datasource.queryMetrics = newQueryMetrics(connection)
+	}
+	if datasourceSettings.KeepAlive {
+		datasource.startKeepAlive(time.Duration(datasourceSettings.KeepAliveInterval) * time.Second)
+	}
+	return datasource, nil
 }
 
 // Datasource is an example datasource which can respond to data queries, reports
 // its health and has streaming skills.
 type Datasource struct {
-	databricksConnectionsString string
-	databricksDB                *sql.DB
+	pools                *poolSet
+	connection           connectionSettings
+	oauthPassThru        bool
+	readOnly             bool
+	statementFilter      *statementFilter
+	credentialMappings   []*credentialMappingPool
+	queryTimeout         time.Duration
+	alertQueryTimeout    time.Duration
+	maxRows              int64
+	maxCellSize          int
+	queue                *queryQueue
+	running              *runningQueries
+	asyncQueries         *asyncQueries
+	liveQueries          *liveQueries
+	progress             *progressTracker
+	dedup                *queryDeduplicator
+	metadataCache        *metadataCache
+	resultCache          *resultCache
+	filterMetadata       bool
+	queryTags            bool
+	macros               []userMacro
+	fiscalYearStartMonth int
+	conversion           conversionOptions
+	retry                retryPolicy
+	warehouseMonitors    map[string]*warehouseMonitor
+	stopKeepAlive        chan struct{}
+	settingsErrors       settingsErrors
+	breaker              *circuitBreaker
+	maxConcurrentQueries int
+	// statementAPI executes the queries if the Statement Execution API mode is enabled,
+	// otherwise it is nil and queries are executed by the driver.
+	statementAPI *statementAPIClient
+	// queryMetrics looks up the bytes scanned by statements if enabled, otherwise nil.
+	queryMetrics *queryMetrics
+
+	// mu guards the in-flight request counter, so the connection pools are only closed
+	// once the requests started before Dispose was called are done.
+	mu          sync.Mutex
+	inFlight    int
+	disposed    bool
+	poolsClosed bool
+	// drained is closed once the drain timeout of a disposed instance exceeded.
+	drained      chan struct{}
+	drainTimeout time.Duration
+	drainTimer   *time.Timer
+}
+
+// authenticatorFor returns the authenticator used by the given connection pool, or nil
+// if the pool is not managed by the datasource instance.
+func (d *Datasource) authenticatorFor(db *sql.DB) *tokenAuthenticator {
+	if d.pools.contains(db) {
+		return d.pools.authenticator
+	}
+	for _, pool := range d.credentialMappings {
+		if pool.pools.contains(db) {
+			return pool.pools.authenticator
+		}
+	}
+	return nil
+}
+
+// refreshCredentials drops the cached access token and the idle connections of the
+// pool, so subsequent queries reconnect using freshly acquired credentials.
+func (d *Datasource) refreshCredentials(db *sql.DB) {
+	authenticator := d.authenticatorFor(db)
+	if authenticator == nil {
+		return
+	}
+	logger.Info("Refreshing credentials and idle connections")
+	authenticator.invalidate()
+	db.SetMaxIdleConns(-1)
+	db.SetMaxIdleConns(d.connection.pool.idleConns())
+}
+
+// withAuthRetry runs fn and, if it fails because Databricks rejected the credentials,
+// refreshes them and runs fn once more.
+func (d *Datasource) withAuthRetry(db *sql.DB, fn func() error) error {
+	err := fn()
+	if isAuthError(err) && d.authenticatorFor(db) != nil {
+		logger.Info("Authentication Error, retrying with refreshed credentials", "err", err)
+		d.refreshCredentials(db)
+		err = fn()
+	}
+	return err
+}
+
+// execute runs a statement using fn, retrying it on transient errors if it is safe to
+// do so, and returns the number of retries made.
+func (d *Datasource) execute(ctx context.Context, db *sql.DB, statement string, fn func() error) (int, error) {
+	if !isIdempotent(statement) {
+		return 0, d.withAuthRetry(db, fn)
+	}
+	return d.retry.do(ctx, func() error {
+		return d.withAuthRetry(db, fn)
+	})
+}
+
+// requestPools resolves the connection pools used by a single request. Pools are
+// selected by the org and user of the request and the warehouse of the query.
+type requestPools struct {
+	d       *Datasource
+	pCtx    backend.PluginContext
+	headers backend.ForwardHTTPHeaders
+
+	mu             sync.Mutex
+	dbs            map[string]*sql.DB
+	authenticators map[string]auth.Authenticator
+	closers        []func()
+}
+
+// poolsForRequest returns the pools for a request, release has to be called once the
+// request is done.
+func (d *Datasource) poolsForRequest(pCtx backend.PluginContext, headers backend.ForwardHTTPHeaders) *requestPools {
+	d.acquire()
+	return &requestPools{
+		d:              d,
+		pCtx:           pCtx,
+		headers:        headers,
+		dbs:            make(map[string]*sql.DB),
+		authenticators: make(map[string]auth.Authenticator),
+	}
+}
+
+// db returns the connection pool for the named warehouse, an empty name selects the
+// default warehouse.
+func (r *requestPools) db(warehouse string) (*sql.DB, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if db, ok := r.dbs[warehouse]; ok {
+		return db, nil
+	}
+	db, authenticator, closeDB, err := r.d.selectDB(r.pCtx, r.headers, warehouse)
+	if err != nil {
+		return nil, err
+	}
+	r.dbs[warehouse] = db
+	r.authenticators[warehouse] = authenticator
+	r.closers = append(r.closers, closeDB)
+	return db, nil
+}
+
+// authenticator returns the authenticator of the pool returned by db for the warehouse.
+func (r *requestPools) authenticator(warehouse string) auth.Authenticator {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.authenticators[warehouse]
+}
+
+func (r *requestPools) release() {
+	for _, closeDB := range r.closers {
+		closeDB()
+	}
+	r.d.release()
+}
+
+// selectDB returns the connection pool to be used. If OAuth pass-through is enabled a
+// dedicated pool authenticated as the signed-in Grafana user is opened, which is closed
+// by the returned function. Otherwise the pool of the first credential mapping matching
+// the org and user of the request is used, falling back to the default pool.
+func (d *Datasource) selectDB(pCtx backend.PluginContext, headers backend.ForwardHTTPHeaders, warehouse string) (*sql.DB, auth.Authenticator, func(), error) {
+	if !d.oauthPassThru {
+		for _, pool := range d.credentialMappings {
+			if pool.mapping.matches(pCtx) {
+				db, err := pool.pools.db(warehouse)
+				return db, pool.pools.authenticator, func() {}, err
+			}
+		}
+		db, err := d.pools.db(warehouse)
+		return db, d.pools.authenticator, func() {}, err
+	}
+	token := oauthPassThruToken(headers)
+	if token == "" {
+		return nil, nil, nil, fmt.Errorf("no OAuth access token found for the signed-in user, make sure the user is logged in to Grafana via OAuth")
+	}
+	connection, err := d.connection.forWarehouse(warehouse)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	authenticator := &pat.PATAuth{AccessToken: token}
+	db, err := connection.openDB(authenticator)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return db, authenticator, func() {
+		err := db.Close()
+		if err != nil {
+			logger.Info("DB Close Error", "err", err)
+		}
+	}, nil
+}
+
+func (d *Datasource) acquire() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight++
+}
+
+// isDisposed reports whether the instance was replaced due to changed settings.
+func (d *Datasource) isDisposed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.disposed
+}
+
+func (d *Datasource) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.disposed && d.inFlight == 0 {
+		d.closePools()
+	}
+}
+
+// withDrainDeadline returns a context which is also cancelled once the drain deadline
+// of the disposed instance passed, so queries can't keep the old pools open forever.
+func (d *Datasource) withDrainDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-d.drained:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// closePools closes all connection pools of the instance, which also closes the
+// Databricks sessions so the warehouse can stop once idle, and drops the cached results
+// of the instance. It has to be called with the lock held.
+func (d *Datasource) closePools() {
+	if d.poolsClosed {
+		return
+	}
+	d.poolsClosed = true
+	if d.drainTimer != nil {
+		d.drainTimer.Stop()
+	}
+	d.pools.close()
+	for _, pool := range d.credentialMappings {
+		pool.pools.close()
+	}
+	if d.resultCache != nil {
+		d.resultCache.close()
+	}
 }
 
 func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
-	return autocompletionQueries(req, sender, d.databricksDB)
+	if match := cancelQueryPathRgx.FindStringSubmatch(req.Path); match != nil {
+		return d.cancelQuery(req, sender, match[1])
+	}
+	if req.Path == "preview" {
+		return d.previewQuery(req, sender)
+	}
+	if req.Path == "format" {
+		return d.formatQuery(req, sender)
+	}
+	if req.Path == "lint" {
+		return d.lintQueryRequest(req, sender)
+	}
+	if req.Path == "macros" {
+		return d.listMacros(sender)
+	}
+	if req.Path == "metadata/refresh" && req.Method == http.MethodPost {
+		return d.refreshMetadata(sender)
+	}
+
+	ctx, cancel := d.withDrainDeadline(ctx)
+	defer cancel()
+	pools := d.poolsForRequest(req.PluginContext, req)
+	defer pools.release()
+	if req.Path == "validate" {
+		return d.validateQuery(ctx, req, sender, pools)
+	}
+	if req.Method != http.MethodGet {
+		return sendError(sender, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+	}
+	if req.Path == "warehouses" {
+		return d.listWarehouses(ctx, sender, pools.authenticator(""))
+	}
+	if req.Path == "saved-queries" {
+		return d.listSavedQueries(ctx, sender, pools.authenticator(""))
+	}
+	if req.Path == "history" {
+		return d.queryHistory(ctx, req, sender, pools)
+	}
+	if match := tablePreviewPathRgx.FindStringSubmatch(req.Path); match != nil {
+		return d.previewTable(ctx, req, sender, pools, match[1:])
+	}
+	db, err := pools.db("")
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return d.metadataRequest(ctx, req, sender, db)
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created. As soon as datasource settings change detected by SDK old datasource instance will
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (d *Datasource) Dispose() {
-	// Clean up datasource instance resources.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.disposed {
+		return
+	}
+	d.disposed = true
+	if d.stopKeepAlive != nil {
+		close(d.stopKeepAlive)
+	}
+	// Requests still running on the old instance close the pools once they are done,
+	// while new requests are already served by the new instance. Requests still running
+	// after the drain timeout are cancelled.
+	if d.inFlight == 0 {
+		d.closePools()
+		return
+	}
+	logger.Info("Draining connection pools", "inFlight", d.inFlight, "timeout", d.drainTimeout)
+	d.drainTimer = time.AfterFunc(d.drainTimeout, func() {
+		logger.Info("Drain timeout exceeded, cancelling queries")
+		close(d.drained)
+	})
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -92,19 +617,52 @@ func (d *Datasource) Dispose() {
 // The QueryDataResponse contains a map of RefID to the response for each query, and each response
 // contains Frames ([]*Frame).
 func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
-	log.DefaultLogger.Info("QueryData called", "request", req)
+	logger.Info("QueryData called", "queries", len(req.Queries), "orgId", req.PluginContext.OrgID)
 
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
-	// loop over queries and execute them individually.
+	ctx, cancel := d.withDrainDeadline(ctx)
+	defer cancel()
+	if d.alertQueryTimeout > 0 && isAlertRequest(req) {
+		var cancelAlert context.CancelFunc
+		ctx, cancelAlert = context.WithTimeout(ctx, d.alertQueryTimeout)
+		defer cancelAlert()
+	}
+	pools := d.poolsForRequest(req.PluginContext, req)
+	defer pools.release()
+
+	// execute the queries concurrently, limited by the configured concurrency.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	semaphore := make(chan struct{}, d.maxConcurrentQueries)
 	for _, q := range req.Queries {
-		res := d.query(ctx, req.PluginContext, q)
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		// Don't start further queries once the request was aborted
+		if ctx.Err() != nil {
+			mu.Lock()
+			response.Responses[q.RefID] = backend.DataResponse{Error: fmt.Errorf("query was cancelled: %w", ctx.Err())}
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(q backend.DataQuery) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			res := d.query(ctx, pools, req.PluginContext, q)
 
-		// save the response in a hashmap
-		// based on with RefID as identifier
-		response.Responses[q.RefID] = res
+			// save the response in a hashmap
+			// based on with RefID as identifier
+			mu.Lock()
+			response.Responses[q.RefID] = res
+			mu.Unlock()
+		}(q)
 	}
+	wg.Wait()
 
 	return response, nil
 }
@@ -113,130 +671,527 @@ type querySettings struct {
 	ConvertLongToWide bool          `json:"convertLongToWide"`
 	FillMode          data.FillMode `json:"fillMode"`
 	FillValue         float64       `json:"fillValue"`
+	// LimitToMaxDataPoints limits the result to the data points the panel can show and
+	// coarsens the interval of the time window macros accordingly.
+	LimitToMaxDataPoints bool `json:"limitToMaxDataPoints"`
+	// Downsample aggregates the rows into windows of the interval of the dashboard.
+	Downsample            bool   `json:"downsample"`
+	DownsampleAggregation string `json:"downsampleAggregation"`
+	// IntervalFormat is the format INTERVAL columns are converted to, seconds or iso.
+	IntervalFormat string `json:"intervalFormat"`
+	// BigintAsString converts BIGINT columns to strings, so identifiers exceeding the
+	// precision of JavaScript numbers are shown unchanged.
+	BigintAsString bool `json:"bigintAsString"`
+	// ColumnTypes overrides the field types of columns by name: time, number, string or
+	// boolean.
+	ColumnTypes map[string]string `json:"columnTypes"`
+	// FlattenStructs replaces STRUCT columns by a column per nested field.
+	FlattenStructs bool `json:"flattenStructs"`
+	// ColumnMetadata sets the descriptions and units of the fields from the comments and
+	// unit tags of the columns of the queried table.
+	ColumnMetadata bool `json:"columnMetadata"`
+	// Resample resamples time series to the interval of the panel, filling missing
+	// values as configured by the fill mode.
+	Resample bool `json:"resample"`
+	// Live streams the results of the query, which is re-executed every LiveInterval
+	// seconds, or only once the version of the queried table changed if LiveTrigger is
+	// version.
+	Live         bool   `json:"live"`
+	LiveInterval int    `json:"liveInterval"`
+	LiveTrigger  string `json:"liveTrigger"`
+	// RoundTimeRange rounds the time range to multiples of the interval before the
+	// macros are expanded, a duration like 1m or $__interval.
+	RoundTimeRange string `json:"roundTimeRange"`
 }
 
 type queryModel struct {
-	RawSqlQuery   string        `json:"rawSqlQuery"`
-	QuerySettings querySettings `json:"querySettings"`
+	RawSqlQuery   string                 `json:"rawSqlQuery"`
+	QuerySettings querySettings          `json:"querySettings"`
+	Parameters    map[string]interface{} `json:"parameters"`
+	// Format of the result, table, time_series or logs. If empty the frame is returned
+	// as is or converted to wide as configured in the query settings.
+	Format string `json:"format"`
+	// Timeout in seconds, overrides the timeout of the datasource.
+	Timeout int `json:"timeout"`
+	// MaxRows overrides the row limit of the datasource.
+	MaxRows int64 `json:"maxRows"`
+	// Warehouse is the name of an additional warehouse the query is executed on.
+	Warehouse string `json:"warehouse"`
+	// QueryId is assigned by the frontend to cancel the query while it is running.
+	QueryId string `json:"queryId"`
+	// Async executes the query in the background, the response contains a handle which
+	// is polled by setting AsyncHandle until the result is available.
+	Async       bool   `json:"async"`
+	AsyncHandle string `json:"asyncHandle"`
+	// DashboardUid and PanelId are sent by the frontend to tag the statements.
+	DashboardUid string `json:"dashboardUid"`
+	PanelId      int64  `json:"panelId"`
+	// SchemaOnly returns empty frames with the columns of the result, without scanning
+	// any data.
+	SchemaOnly bool `json:"schemaOnly"`
+	// Timezone of the dashboard the time macros are rendered in, UTC if empty.
+	Timezone string `json:"timezone"`
+	// SearchFilter is the text typed into the search of a variable, which is used by the
+	// $__searchFilter macro.
+	SearchFilter string `json:"searchFilter"`
+	// FiscalYearStartMonth overrides the fiscal year start month of the datasource.
+	FiscalYearStartMonth int `json:"fiscalYearStartMonth"`
+	// SavedQueryId references a Databricks SQL saved query, whose SQL is executed
+	// instead of RawSqlQuery.
+	SavedQueryId string `json:"savedQueryId"`
 }
 
-func (d *Datasource) query(_ context.Context, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
+func (d *Datasource) query(ctx context.Context, pools *requestPools, pCtx backend.PluginContext, query backend.DataQuery) backend.DataResponse {
 	response := backend.DataResponse{}
 
 	// Unmarshal the JSON into our queryModel.
 	var qm queryModel
 
-	log.DefaultLogger.Info("Query Ful", "query", query)
+	logger.Debug("Query called", "refId", query.RefID, "timeRange", query.TimeRange, "interval", query.Interval)
 	err := json.Unmarshal(query.JSON, &qm)
 	if err != nil {
 		response.Error = err
-		log.DefaultLogger.Info("Query Parsing Error", "err", err)
+		logger.Info("Query Parsing Error", "err", err)
 		return response
 	}
 
-	queryString := replaceMacros(qm.RawSqlQuery, query)
-
-	// Check if multiple statements are present in the query
-	// If so, split them and execute them individually
-	if strings.Contains(queryString, ";") {
-		// Split the query string into multiple statements
-		queries := strings.Split(queryString, ";")
-		// Check if the last statement is empty or just whitespace and newlines
-		if strings.TrimSpace(queries[len(queries)-1]) == "" {
-			// Remove the last statement
-			queries = queries[:len(queries)-1]
-		}
-		// Check if there are stil multiple statements
-		if len(queries) > 1 {
-			// Execute all but the last statement without returning any data
-			for _, query := range queries[:len(queries)-1] {
-				_, err := d.databricksDB.Exec(query)
-				if err != nil {
-					response.Error = err
-					log.DefaultLogger.Info("Error", "err", err)
-					return response
-				}
-			}
-			// Set the query string to the last statement
-			queryString = queries[len(queries)-1]
+	if query.QueryType == queryTypeWarehouseUtilization {
+		return d.warehouseUtilizationQuery(ctx, pools, pCtx, qm)
+	}
+	if qm.AsyncHandle != "" {
+		return d.asyncQueries.result(runningQueryKey(pCtx, qm.AsyncHandle), qm.AsyncHandle)
+	}
+	if qm.Async {
+		return d.startAsyncQuery(pools, pCtx, query, qm)
+	}
+	response = d.runSharedQuery(ctx, pools, pCtx, query, qm)
+	if qm.QuerySettings.Live && !qm.SchemaOnly && response.Error == nil {
+		d.publishLive(pCtx, pools, query, qm, &response)
+	}
+	return response
+}
+
+// runSharedQuery executes the query, sharing the result with identical queries if
+// deduplication is enabled.
+func (d *Datasource) runSharedQuery(ctx context.Context, pools *requestPools, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) backend.DataResponse {
+	if d.dedup == nil {
+		return d.runQuery(ctx, pools, pCtx, query, qm)
+	}
+	key, err := dedupKey(pCtx, query, qm)
+	if err != nil {
+		logger.Info("Query Dedup Key Error", "err", err)
+		return d.runQuery(ctx, pools, pCtx, query, qm)
+	}
+	// The shared execution can outlive the request starting it, so it uses its own pools
+	// and keeps only the deadline of the request, queries with a later deadline don't
+	// join it
+	deadline, hasDeadline := ctx.Deadline()
+	return d.dedup.do(ctx, key, func(ctx context.Context) backend.DataResponse {
+		if hasDeadline {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
 		}
+		ctx, cancel := d.withDrainDeadline(ctx)
+		defer cancel()
+		sharedPools := d.poolsForRequest(pCtx, pools.headers)
+		defer sharedPools.release()
+		return d.runQuery(ctx, sharedPools, pCtx, query, qm)
+	})
+}
+
+// runQuery executes the query and returns its frames.
+func (d *Datasource) runQuery(ctx context.Context, pools *requestPools, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) backend.DataResponse {
+	response := backend.DataResponse{}
+
+	if qm.SavedQueryId != "" {
+		savedQuery, err := d.savedQuery(ctx, pCtx, pools.authenticator(qm.Warehouse), qm.SavedQueryId)
+		if err != nil {
+			response.Error = fmt.Errorf("failed to load the saved query %s: %w", qm.SavedQueryId, err)
+			logger.Info("Saved Query Error", "err", err)
+			return response
+		}
+		qm.RawSqlQuery = savedQuery
+	}
+
+	if qm.QuerySettings.LimitToMaxDataPoints {
+		query.Interval = maxDataPointsInterval(query)
+	}
+	if qm.QuerySettings.RoundTimeRange != "" {
+		interval, err := macroInterval(qm.QuerySettings.RoundTimeRange, query)
+		if err != nil {
+			response.Error = fmt.Errorf("invalid time range rounding: %w", err)
+			logger.Info("Query Settings Error", "err", err)
+			return response
+		}
+		if interval > 0 {
+			query.TimeRange = roundTimeRange(query.TimeRange, interval)
+		}
+	}
+	options, err := d.macroOptions(qm.Timezone, qm.FiscalYearStartMonth)
+	if err != nil {
+		response.Error = err
+		logger.Info("Query Macro Error", "err", err)
+		return response
+	}
+	options.searchFilter = qm.SearchFilter
+	options.variables = globalVariables(pCtx, newQueryTags(pCtx, pools.headers, qm).DashboardUid)
+	queryString, err := replaceMacros(qm.RawSqlQuery, query, options)
+	if err != nil {
+		response.Error = err
+		logger.Info("Query Macro Error", "err", err)
+		return response
+	}
+
+	fill, err := timeGroupFill(qm.RawSqlQuery)
+	if err != nil {
+		response.Error = err
+		logger.Info("Query Macro Error", "err", err)
+		return response
+	}
+	if fill != nil {
+		qm.QuerySettings.FillMode = fill.Mode
+		qm.QuerySettings.FillValue = fill.Value
 	}
 
-	log.DefaultLogger.Info("Query", "query", queryString)
+	queryString, err = bindParameters(queryString, qm.Parameters)
+	if err != nil {
+		response.Error = err
+		logger.Info("Query Parameter Error", "err", err)
+		return response
+	}
 
-	frame := data.NewFrame("response")
+	if d.readOnly {
+		err := checkReadOnly(queryString)
+		if err != nil {
+			response.Error = err
+			logger.Info("Read Only Violation", "err", err)
+			return response
+		}
+	}
 
-	rows, err := d.databricksDB.Query(queryString)
+	err = d.statementFilter.check(queryString)
 	if err != nil {
 		response.Error = err
-		log.DefaultLogger.Info("Error", "err", err)
+		logger.Info("Statement Filter Violation", "err", err)
 		return response
 	}
 
-	dateConverter := sqlutil.Converter{
-		Name:          "Databricks date to timestamp converter",
-		InputScanType: reflect.TypeOf(sql.NullString{}),
-		InputTypeName: "DATE",
-		FrameConverter: sqlutil.FrameConverter{
-			FieldType: data.FieldTypeNullableTime,
-			ConverterFunc: func(n interface{}) (interface{}, error) {
-				v := n.(*sql.NullString)
+	var cacheKey string
+	if d.resultCache != nil && !qm.SchemaOnly && useResultCache(ctx) {
+		cacheKey, err = d.resultKey(pCtx, query, qm, queryString)
+		if err != nil {
+			logger.Info("Result Cache Key Error", "err", err)
+		} else if cached, ok := d.resultCache.get(cacheKey); ok {
+			logger.Debug("Returning cached result", "key", cacheKey)
+			return cached
+		}
+	}
 
-				if !v.Valid {
-					return (*time.Time)(nil), nil
-				}
+	timeout := requestTimeout(ctx, d.queryTimeout)
+	if qm.Timeout > 0 {
+		timeout = requestTimeout(ctx, time.Duration(qm.Timeout)*time.Second)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if qm.QueryId != "" {
+		var done func()
+		ctx, done = d.running.register(ctx, runningQueryKey(pCtx, qm.QueryId))
+		defer done()
+	}
 
-				f := v.String
-				date, error := time.Parse("2006-01-02", f)
-				if error != nil {
-					return (*time.Time)(nil), error
-				}
-				return &date, nil
-			},
-		},
+	db, err := pools.db(qm.Warehouse)
+	if err != nil {
+		response.Error = err
+		logger.Info("Connection Error", "err", err)
+		return response
 	}
 
-	frame, err = sqlutil.FrameFromRows(rows, -1, dateConverter)
+	release, err := d.breaker.allow()
 	if err != nil {
-		log.DefaultLogger.Info("FrameFromRows", "err", err)
 		response.Error = err
+		logger.Info("Circuit Breaker Open", "err", err)
 		return response
 	}
+	defer release()
 
-	if qm.QuerySettings.ConvertLongToWide {
-		wideFrame, err := data.LongToWide(frame, &data.FillMissing{Value: qm.QuerySettings.FillValue, Mode: qm.QuerySettings.FillMode})
+	if authenticator := d.authenticatorFor(db); authenticator != nil {
+		err = d.warehouseMonitors[qm.Warehouse].ensureRunning(ctx, authenticator)
 		if err != nil {
-			log.DefaultLogger.Info("LongToWide conversion error", "err", err)
-		} else {
-			frame = wideFrame
+			response.Error = contextError(ctx, timeout, err)
+			logger.Info("Warehouse Error", "err", err)
+			return response
 		}
+	}
+
+	conversion, err := d.conversion.inLocation(options.location).withIntervalFormat(qm.QuerySettings.IntervalFormat)
+	if err != nil {
+		response.Error = err
+		logger.Info("Query Settings Error", "err", err)
+		return response
+	}
+	conversion.bigintAsString = qm.QuerySettings.BigintAsString
+	err = validateColumnTypes(qm.QuerySettings.ColumnTypes)
+	if err != nil {
+		response.Error = err
+		logger.Info("Query Settings Error", "err", err)
+		return response
+	}
+	executor, err := d.executor(pools, qm.Warehouse, db, conversion)
+	if err != nil {
+		response.Error = err
+		logger.Info("Connection Error", "err", err)
+		return response
+	}
 
+	rowLimit := d.maxRows
+	if qm.MaxRows > 0 {
+		rowLimit = qm.MaxRows
 	}
 
-	// add the frames to the response.
-	response.Frames = append(response.Frames, frame)
+	statements := splitStatements(queryString)
+	running := func() {}
+	if qm.QueryId != "" {
+		var done func()
+		ctx, running, done = d.progress.track(ctx, runningQueryKey(pCtx, qm.QueryId), len(statements), pools.authenticator(qm.Warehouse))
+		defer done()
+	}
 
+	queueTime, err := d.queue.acquire(ctx)
+	if err != nil {
+		response.Error = contextError(ctx, timeout, err)
+		logger.Info("Query Queue Error", "err", err)
+		return response
+	}
+	defer d.queue.release()
+	running()
+
+	// number of retries made due to transient errors
+	retries := 0
+
+	// Every statement producing a result returns a frame, other statements are executed
+	// without returning any data. The last statement always returns a frame.
+	var tags queryTags
+	if d.queryTags {
+		tags = newQueryTags(pCtx, pools.headers, qm)
+	}
+
+	for i, statement := range statements {
+		if i < len(statements)-1 && !producesResult(statement) {
+			// Only session statements like USE are needed to resolve the schema, others
+			// could modify data
+			if qm.SchemaOnly && !isUnexplained(statement) {
+				continue
+			}
+			statement = tags.tag(statement)
+			n, err := d.execute(ctx, db, statement, func() error {
+				return executor.exec(ctx, statement)
+			})
+			retries += n
+			if err != nil {
+				d.breaker.record(err)
+				response.Error = contextError(ctx, timeout, err)
+				logger.Info("Error", "err", err)
+				return response
+			}
+			continue
+		}
+
+		var notices []data.Notice
+		if qm.SchemaOnly {
+			statement = schemaOnlyStatement(statement)
+		} else if qm.QuerySettings.Downsample {
+			downsampled, err := d.downsampleStatement(ctx, db, executor, statement, query.Interval, qm.QuerySettings.DownsampleAggregation)
+			if ctx.Err() != nil {
+				response.Error = contextError(ctx, timeout, err)
+				return response
+			}
+			if err != nil {
+				logger.Info("Downsampling Error", "err", err)
+				notices = append(notices, data.Notice{
+					Severity: data.NoticeSeverityWarning,
+					Text:     fmt.Sprintf("The query was not downsampled: %s", err),
+				})
+			} else {
+				statement = downsampled
+			}
+		}
+		if qm.QuerySettings.LimitToMaxDataPoints && !qm.SchemaOnly {
+			statement = limitStatement(statement, query.MaxDataPoints)
+		}
+		statement = tags.tag(statement)
+
+		logger.Info("Query", "query", statement)
+
+		frame, n, err := d.queryFrame(ctx, db, executor, statement, rowLimit)
+		retries += n
+		d.breaker.record(err)
+		if err != nil {
+			response.Error = contextError(ctx, timeout, err)
+			logger.Info("Error", "err", err)
+			return response
+		}
+
+		frame.AppendNotices(notices...)
+		if stats := frameStats(frame); d.queryMetrics != nil && stats.QueryId != "" {
+			stats.BytesScanned = d.queryMetrics.bytesScanned(ctx, pools.authenticator(qm.Warehouse), stats.QueryId)
+		}
+
+		if qm.QuerySettings.ColumnMetadata {
+			metadata, err := d.columnMetadata(ctx, db, executor, statement)
+			if err != nil {
+				logger.Info("Column Metadata Error", "err", err)
+				frame.AppendNotices(data.Notice{
+					Severity: data.NoticeSeverityWarning,
+					Text:     fmt.Sprintf("The column metadata couldn't be looked up: %s", err),
+				})
+			}
+			applyColumnMetadata(frame, metadata)
+		}
+		if qm.QuerySettings.FlattenStructs {
+			flattenStructs(frame)
+		}
+		applyColumnTypes(frame, qm.QuerySettings.ColumnTypes)
+		truncateCells(frame, d.maxCellSize)
+		frame, err = shapeFrame(frame, qm)
+		if err != nil {
+			response.Error = err
+			logger.Info("Format Error", "err", err)
+			return response
+		}
+		if qm.QuerySettings.Resample && qm.Format != formatTable && qm.Format != formatLogs {
+			frame = resampleFrame(frame, query.TimeRange, query.Interval, query.MaxDataPoints, qm.QuerySettings)
+		}
+
+		// add the frames to the response.
+		response.Frames = append(response.Frames, frame)
+	}
+
+	if d.queue != nil {
+		for _, frame := range response.Frames {
+			if frame.Meta == nil {
+				frame.SetMeta(&data.FrameMeta{})
+			}
+			frame.Meta.Stats = append(frame.Meta.Stats, data.QueryStat{
+				FieldConfig: data.FieldConfig{DisplayName: "Queue time", Unit: "ms"},
+				Value:       float64(queueTime.Milliseconds()),
+			})
+		}
+	}
+
+	if retries > 0 && len(response.Frames) > 0 {
+		response.Frames[0].AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("The query succeeded after %d retries due to transient errors.", retries),
+		})
+	}
+
+	if cacheKey != "" {
+		d.resultCache.set(cacheKey, response)
+	}
 	return response
 }
 
+// queryFrame executes a statement and returns its result as frame, limited to maxRows
+// rows, together with the number of retries made.
+func (d *Datasource) queryFrame(ctx context.Context, db *sql.DB, executor statementExecutor, statement string, maxRows int64) (*data.Frame, int, error) {
+	var frame *data.Frame
+	start := time.Now()
+	retries, err := d.execute(ctx, db, statement, func() error {
+		var err error
+		frame, err = executor.query(ctx, statement, maxRows)
+		return err
+	})
+	if err != nil {
+		return nil, retries, err
+	}
+	frame.Name = "response"
+	stats := frameStats(frame)
+	stats.DurationMs = time.Since(start).Milliseconds()
+	stats.Rows, _ = frame.RowLen()
+	frame.Meta.ExecutedQueryString = statement
+	return frame, retries, nil
+}
+
+// isAlertRequest reports whether the request was sent by the Grafana alerting engine to
+// evaluate an alert rule.
+func isAlertRequest(req *backend.QueryDataRequest) bool {
+	for name, value := range req.Headers {
+		if strings.EqualFold(name, "FromAlert") {
+			return value == "true"
+		}
+	}
+	return false
+}
+
+// requestTimeout returns the timeout of a query, shortened to the deadline of the
+// request if Grafana, i.e. the data proxy or an alert evaluation, stops waiting for the
+// result earlier. Cancelling the query then also cancels the statement on the warehouse
+// instead of leaving it running.
+func requestTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+	if remaining := time.Until(deadline); timeout <= 0 || remaining < timeout {
+		return remaining
+	}
+	return timeout
+}
+
+// contextError replaces err with a descriptive error if the query was cancelled because
+// it exceeded the timeout or the request was aborted by the client.
+func contextError(ctx context.Context, timeout time.Duration, err error) error {
+	if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// Timeouts derived from the deadline of the request aren't whole seconds
+		if timeout > time.Second {
+			timeout = timeout.Round(time.Second)
+		}
+		return fmt.Errorf("query exceeded the timeout of %s and was cancelled", timeout)
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		logger.Info("Query cancelled by the client")
+		return fmt.Errorf("query was cancelled: %w", ctx.Err())
+	}
+	return err
+}
+
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // The main use case for these health checks is the test button on the
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
-func (d *Datasource) CheckHealth(_ context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	log.DefaultLogger.Info("CheckHealth called", "request", req)
+func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	logger.Info("CheckHealth called", "orgId", req.PluginContext.OrgID)
 
-	dsn := d.databricksConnectionsString
+	if len(d.settingsErrors) > 0 {
+		details, err := json.Marshal(map[string]interface{}{"errors": d.settingsErrors})
+		if err != nil {
+			logger.Info("Settings Errors Marshal Error", "err", err)
+		}
+		return &backend.CheckHealthResult{
+			Status:      backend.HealthStatusError,
+			Message:     fmt.Sprintf("Invalid datasource settings: %s", d.settingsErrors),
+			JSONDetails: details,
+		}, nil
+	}
 
-	if dsn == "" {
+	pools := d.poolsForRequest(req.PluginContext, req)
+	defer pools.release()
+	db, err := pools.db("")
+	if err != nil {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
-			Message: "No connection string found." + "Set the DATABRICKS_DSN environment variable, and try again.",
+			Message: fmt.Sprintf("SQL Connection Failed: %s", err),
 		}, nil
 	}
 
-	rows, err := d.databricksDB.Query("SELECT 1")
-
+	executor, err := d.executor(pools, "", db, d.conversion)
+	if err == nil {
+		_, err = executor.query(ctx, "SELECT 1", 1)
+	}
 	if err != nil {
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
@@ -244,8 +1199,6 @@ func (d *Datasource) CheckHealth(_ context.Context, req *backend.CheckHealthRequ
 		}, nil
 	}
 
-	defer rows.Close()
-
 	return &backend.CheckHealthResult{
 		Status:  backend.HealthStatusOk,
 		Message: "Data source is working",
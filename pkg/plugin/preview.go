@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"encoding/json"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+)
+
+type previewResponseBody struct {
+	Sql   string `json:"sql"`
+	Error string `json:"error,omitempty"`
+}
+
+// previewQuery handles the resource request returning the query with all macros and
+// parameters replaced, as it would be executed. The request body is the one of the
+// validate request, nothing is sent to Databricks.
+func (d *Datasource) previewQuery(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	var body validateRequestBody
+	err := json.Unmarshal(req.Body, &body)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+
+	var response previewResponseBody
+	queryString, err := d.expandQuery(req.PluginContext, body)
+	if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Sql = queryString
+	}
+
+	jsonBody, err := json.Marshal(response)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
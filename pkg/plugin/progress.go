@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"context"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressInterval is the interval in which the progress of a query is sent.
+	progressInterval = 2 * time.Second
+	// progressStartTimeout is the time the progress stream waits for the query to start,
+	// the frontend can subscribe before the query reached the backend.
+	progressStartTimeout = time.Minute
+	// progressPathPrefix is the prefix of the channel paths of query progress.
+	progressPathPrefix = "progress/"
+)
+
+const (
+	progressStateQueued   = "queued"
+	progressStateRunning  = "running"
+	progressStateFinished = "finished"
+)
+
+// queryProgress is the progress of a running query, updated by runQuery and the
+// executors.
+type queryProgress struct {
+	started       time.Time
+	state         string
+	statement     int
+	statements    int
+	statementId   string
+	authenticator auth.Authenticator
+}
+
+// progressTracker holds the progress of the running queries with an id assigned by the
+// frontend, by the same key as runningQueries.
+type progressTracker struct {
+	mu      sync.Mutex
+	queries map[string]*queryProgress
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{queries: make(map[string]*queryProgress)}
+}
+
+type statementCallbackKey struct{}
+
+// track starts tracking the progress of a query with the given number of statements,
+// which is queued until running is called. The returned context reports the statements
+// started by the executors, done has to be called once the query finished.
+func (t *progressTracker) track(ctx context.Context, key string, statements int, authenticator auth.Authenticator) (context.Context, func(), func()) {
+	progress := &queryProgress{
+		started:       time.Now(),
+		state:         progressStateQueued,
+		statements:    statements,
+		authenticator: authenticator,
+	}
+	t.mu.Lock()
+	t.queries[key] = progress
+	t.mu.Unlock()
+
+	ctx = context.WithValue(ctx, statementCallbackKey{}, func(statementId string) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		progress.statement++
+		progress.statementId = statementId
+	})
+	running := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		progress.state = progressStateRunning
+	}
+	done := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.queries[key] == progress {
+			delete(t.queries, key)
+		}
+	}
+	return ctx, running, done
+}
+
+// get returns a copy of the progress of the query.
+func (t *progressTracker) get(key string) (queryProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	progress, ok := t.queries[key]
+	if !ok {
+		return queryProgress{}, false
+	}
+	return *progress, true
+}
+
+// statementStarted reports the id of a statement submitted to the warehouse to the
+// progress tracker of the query, if any.
+func statementStarted(ctx context.Context, statementId string) {
+	if callback, ok := ctx.Value(statementCallbackKey{}).(func(string)); ok {
+		callback(statementId)
+	}
+}
+
+// statementMetrics are the metrics of a statement in the query history, which are
+// updated while the statement is running.
+type statementMetrics struct {
+	Status    string
+	ReadBytes *int64
+	RowsRead  *int64
+	FilesRead *int64
+}
+
+// statementMetrics looks up the status and metrics of the statement in the query history.
+func (d *Datasource) statementMetrics(ctx context.Context, authenticator auth.Authenticator, statementId string) (*statementMetrics, error) {
+	var body struct {
+		Res []struct {
+			QueryId string `json:"query_id"`
+			Status  string `json:"status"`
+			Metrics *struct {
+				ReadBytes      *int64 `json:"read_bytes"`
+				RowsReadCount  *int64 `json:"rows_read_count"`
+				ReadFilesCount *int64 `json:"read_files_count"`
+			} `json:"metrics"`
+		} `json:"res"`
+	}
+	err := d.apiRequest(ctx, authenticator, http.MethodGet, "/api/2.0/sql/history/queries", map[string]interface{}{
+		"filter_by":       map[string]interface{}{"statement_ids": []string{statementId}},
+		"include_metrics": true,
+	}, &body)
+	if err != nil {
+		return nil, err
+	}
+	metrics := &statementMetrics{}
+	for _, query := range body.Res {
+		if query.QueryId != statementId {
+			continue
+		}
+		metrics.Status = strings.ToLower(query.Status)
+		if query.Metrics != nil {
+			metrics.ReadBytes = query.Metrics.ReadBytes
+			metrics.RowsRead = query.Metrics.RowsReadCount
+			metrics.FilesRead = query.Metrics.ReadFilesCount
+		}
+	}
+	return metrics, nil
+}
+
+// progressFrame returns a frame with a single row describing the progress of the query.
+// The metrics of the running statement are only added if they are available.
+func progressFrame(progress queryProgress, metrics *statementMetrics) *data.Frame {
+	if metrics == nil {
+		metrics = &statementMetrics{}
+	}
+	status := metrics.Status
+	if status == "" {
+		status = progress.state
+	}
+	return data.NewFrame("progress",
+		data.NewField("time", nil, []time.Time{time.Now()}),
+		data.NewField("state", nil, []string{progress.state}),
+		data.NewField("status", nil, []string{status}),
+		data.NewField("elapsed", nil, []int64{time.Since(progress.started).Milliseconds()}).SetConfig(&data.FieldConfig{Unit: "ms"}),
+		data.NewField("statement", nil, []int64{int64(progress.statement)}),
+		data.NewField("statements", nil, []int64{int64(progress.statements)}),
+		data.NewField("statementId", nil, []string{progress.statementId}),
+		data.NewField("bytesRead", nil, []*int64{metrics.ReadBytes}).SetConfig(&data.FieldConfig{Unit: "decbytes"}),
+		data.NewField("rowsRead", nil, []*int64{metrics.RowsRead}),
+		data.NewField("filesRead", nil, []*int64{metrics.FilesRead}),
+	)
+}
+
+// runProgressStream sends the progress of the query with the id every progressInterval
+// until it finished, ending with a frame in the finished state.
+func (d *Datasource) runProgressStream(ctx context.Context, pCtx backend.PluginContext, queryId string, sender *backend.StreamSender) error {
+	key := runningQueryKey(pCtx, queryId)
+	subscribed := time.Now()
+	var last *queryProgress
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		progress, ok := d.progress.get(key)
+		switch {
+		case ok:
+			var metrics *statementMetrics
+			if progress.statementId != "" && progress.authenticator != nil {
+				var err error
+				metrics, err = d.statementMetrics(ctx, progress.authenticator, progress.statementId)
+				if err != nil {
+					logger.Info("Query Progress Error", "queryId", queryId, "err", err)
+				}
+			}
+			err := sender.SendFrame(progressFrame(progress, metrics), data.IncludeAll)
+			if err != nil {
+				return err
+			}
+			last = &progress
+		case last != nil:
+			last.state = progressStateFinished
+			return sender.SendFrame(progressFrame(*last, &statementMetrics{Status: progressStateFinished}), data.IncludeAll)
+		case time.Since(subscribed) > progressStartTimeout:
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
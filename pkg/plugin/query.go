@@ -3,7 +3,6 @@ package plugin
 import (
 	"fmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
-	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"math"
 	"regexp"
 	"strings"
@@ -35,32 +34,100 @@ func getIntervalString(duration time.Duration) string {
 		deliminator = " "
 	}
 
+	remainingMilliseconds := duration.Milliseconds() - int64(seconds*1000)
+	if remainingMilliseconds > 0 {
+		returnString = fmt.Sprintf("%s%s%d MILLISECONDS", returnString, deliminator, remainingMilliseconds)
+	}
+
 	return returnString
 }
 
-func replaceMacros(sqlQuery string, query backend.DataQuery) string {
+func replaceMacros(sqlQuery string, query backend.DataQuery, options macroOptions) (string, error) {
 
 	queryString := sqlQuery
-	log.DefaultLogger.Info("Raw SQL Query selected", "query", queryString)
+	logger.Info("Raw SQL Query selected", "query", queryString)
 
 	interval_string := getIntervalString(query.Interval)
 
+	// User defined macros are expanded first, so their templates can use the built-in
+	// macros
+	var err error
+	for _, macro := range options.userMacros {
+		queryString, err = replaceMacro(queryString, macro.macroName(), macro.expand)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	queryString, err = replaceMacroCalls(queryString, "quoteList", expandQuoteList)
+	if err != nil {
+		return "", err
+	}
+
+	queryString, err = replaceMacro(queryString, "timeGroup", func(args []string) (string, error) {
+		return expandTimeGroup(args, query, options)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	queryString, err = replaceMacro(queryString, "fiscalYear", func(args []string) (string, error) {
+		return expandFiscalYear(args, options.fiscalYearStartMonth)
+	})
+	if err != nil {
+		return "", err
+	}
+	queryString, err = replaceMacro(queryString, "fiscalQuarter", func(args []string) (string, error) {
+		return expandFiscalQuarter(args, options.fiscalYearStartMonth)
+	})
+	if err != nil {
+		return "", err
+	}
+	queryString, err = replaceMacro(queryString, "fiscalYearStart", func(args []string) (string, error) {
+		return expandFiscalPeriodStart(args, query, 12, options)
+	})
+	if err != nil {
+		return "", err
+	}
+	queryString, err = replaceMacro(queryString, "fiscalQuarterStart", func(args []string) (string, error) {
+		return expandFiscalPeriodStart(args, query, 3, options)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for name, unit := range map[string]time.Duration{"unixEpoch": time.Second, "unixEpochMs": time.Millisecond} {
+		unit := unit
+		queryString, err = replaceMacro(queryString, name+"Filter", func(args []string) (string, error) {
+			return expandUnixEpochFilter(args, query, unit)
+		})
+		if err != nil {
+			return "", err
+		}
+		queryString, err = replaceMacro(queryString, name+"Group", func(args []string) (string, error) {
+			return expandUnixEpochGroup(args, query, unit)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
 	var rgx = regexp.MustCompile(`\$__timeWindow\(([a-zA-Z0-9_-]+)\)`)
 	if rgx.MatchString(queryString) {
-		log.DefaultLogger.Info("__timeWindow placeholder found")
+		logger.Info("__timeWindow placeholder found")
 		rs := rgx.FindStringSubmatch(queryString)
 		timeColumnName := rs[1]
 		queryString = rgx.ReplaceAllString(queryString, fmt.Sprintf("window(%s, '%s')", timeColumnName, interval_string))
 
 		rgx = regexp.MustCompile(`\$__time\(([a-zA-Z0-9_-]+)\)`)
 		if rgx.MatchString(queryString) {
-			log.DefaultLogger.Info("__time placeholder found")
+			logger.Info("__time placeholder found")
 			queryString = rgx.ReplaceAllString(queryString, "window.start")
 		}
 
 		rgx = regexp.MustCompile(`\$__value\(([a-zA-Z0-9_-]+)\)`)
 		if rgx.MatchString(queryString) {
-			log.DefaultLogger.Info("__value placeholder found")
+			logger.Info("__value placeholder found")
 			rs = rgx.FindStringSubmatch(queryString)
 			valueColumnName := rs[1]
 			queryString = rgx.ReplaceAllString(queryString, fmt.Sprintf("avg(%s) AS value", valueColumnName))
@@ -68,7 +135,7 @@ func replaceMacros(sqlQuery string, query backend.DataQuery) string {
 	} else {
 		rgx = regexp.MustCompile(`\$__time\(([a-zA-Z0-9_-]+)\)`)
 		if rgx.MatchString(queryString) {
-			log.DefaultLogger.Info("__time placeholder found")
+			logger.Info("__time placeholder found")
 			rs := rgx.FindStringSubmatch(queryString)
 			timeColumnName := rs[1]
 			queryString = rgx.ReplaceAllString(queryString, fmt.Sprintf("%s AS time", timeColumnName))
@@ -76,7 +143,7 @@ func replaceMacros(sqlQuery string, query backend.DataQuery) string {
 
 		rgx = regexp.MustCompile(`\$__value\(([a-zA-Z0-9_-]+)\)`)
 		if rgx.MatchString(queryString) {
-			log.DefaultLogger.Info("__value placeholder found")
+			logger.Info("__value placeholder found")
 			rs := rgx.FindStringSubmatch(queryString)
 			valueColumnName := rs[1]
 			queryString = rgx.ReplaceAllString(queryString, fmt.Sprintf("%s AS value", valueColumnName))
@@ -89,17 +156,59 @@ func replaceMacros(sqlQuery string, query backend.DataQuery) string {
 		timeColumnName := rs[1]
 		timeRangeFilter := fmt.Sprintf("%s BETWEEN '%s' AND '%s'",
 			timeColumnName,
-			query.TimeRange.From.UTC().Format("2006-01-02 15:04:05"),
-			query.TimeRange.To.UTC().Format("2006-01-02 15:04:05"),
+			macroTime(query.TimeRange.From, options.location),
+			macroTime(query.TimeRange.To, options.location),
 		)
 		queryString = rgx.ReplaceAllString(queryString, timeRangeFilter)
 	}
 
-	queryString = strings.ReplaceAll(queryString, "$__timeFrom", query.TimeRange.From.UTC().Format("2006-01-02 15:04:05"))
+	queryString, err = replaceMacro(queryString, "partitionFilter", func(args []string) (string, error) {
+		return expandPartitionFilter(args, query, options.location)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Calls with format have to be replaced before the plain variables
+	queryString, err = replaceMacro(queryString, "timeFrom", func(args []string) (string, error) {
+		return expandTimeBoundary(args, query.TimeRange.From, options.location)
+	})
+	if err != nil {
+		return "", err
+	}
+	queryString, err = replaceMacro(queryString, "timeTo", func(args []string) (string, error) {
+		return expandTimeBoundary(args, query.TimeRange.To, options.location)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	queryString = strings.ReplaceAll(queryString, "$__timeFrom", macroTime(query.TimeRange.From, options.location))
 
-	queryString = strings.ReplaceAll(queryString, "$__timeTo", query.TimeRange.To.UTC().Format("2006-01-02 15:04:05"))
+	queryString = strings.ReplaceAll(queryString, "$__timeTo", macroTime(query.TimeRange.To, options.location))
+
+	timezone := "UTC"
+	if options.location != nil {
+		timezone = options.location.String()
+	}
+	queryString = strings.ReplaceAll(queryString, "$__timezone", quoteString(timezone))
+
+	queryString = strings.ReplaceAll(queryString, "$__searchFilter", searchFilterPattern(options.searchFilter))
+
+	// Values are escaped, so variables can be used within string literals like
+	// '${__user.login}' without being able to end them
+	for name, value := range options.variables {
+		queryString = strings.ReplaceAll(queryString, "${"+name+"}", escapeString(value))
+	}
 
+	// The interval variables are expanded on the backend, so queries of alert rules
+	// get the same values as dashboards. $__interval_ms has to be replaced first, as
+	// $__interval is a prefix of it.
+	intervalMs := fmt.Sprint(query.Interval.Milliseconds())
+	queryString = strings.ReplaceAll(queryString, "${__interval_ms}", intervalMs)
+	queryString = strings.ReplaceAll(queryString, "$__interval_ms", intervalMs)
+	queryString = strings.ReplaceAll(queryString, "${__interval}", interval_string)
 	queryString = strings.ReplaceAll(queryString, "$__interval", interval_string)
 
-	return queryString
+	return queryString, nil
 }
@@ -0,0 +1,162 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultHistoryQueries = 25
+	maxHistoryQueries     = 100
+)
+
+// historyQuery is a statement of the Databricks query history, as returned by the
+// history resource.
+type historyQuery struct {
+	QueryId      string `json:"queryId"`
+	Statement    string `json:"statement"`
+	Status       string `json:"status"`
+	User         string `json:"user"`
+	StartTimeMs  int64  `json:"startTimeMs"`
+	DurationMs   int64  `json:"durationMs"`
+	Rows         *int64 `json:"rows,omitempty"`
+	BytesScanned *int64 `json:"bytesScanned,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// queryHistory handles the resource request listing the recent statements of the
+// warehouse of the datasource, or of the warehouse set by the warehouse parameter,
+// newest first. The number of statements is set by the limit parameter. If the
+// dashboardUid parameter is set, only statements tagged with the dashboard are returned,
+// which requires query tags to be enabled. The history is read with the credentials of
+// the datasource, so only statements tagged by Grafana and statements of the user are
+// returned, unless a Grafana admin requests all statements with the all parameter.
+func (d *Datasource) queryHistory(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, pools *requestPools) error {
+	params := url.Values{}
+	if u, err := url.Parse(req.URL); err == nil {
+		params = u.Query()
+	}
+	limit := defaultHistoryQueries
+	if params.Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(params.Get("limit"))
+		if err != nil || limit < 1 || limit > maxHistoryQueries {
+			return sendError(sender, http.StatusBadRequest, fmt.Errorf("invalid limit %q, expected a number between 1 and %d", params.Get("limit"), maxHistoryQueries))
+		}
+	}
+	warehouse := params.Get("warehouse")
+	connection, err := d.connection.forWarehouse(warehouse)
+	if err != nil {
+		return sendError(sender, http.StatusBadRequest, err)
+	}
+	warehouseId := warehouseIdFromPath(connection.path)
+	if warehouseId == "" {
+		return sendError(sender, http.StatusBadRequest, fmt.Errorf("the query history requires the HTTP Path of a SQL warehouse, got %q", connection.path))
+	}
+
+	all := params.Get("all") == "true"
+	if all && (req.PluginContext.User == nil || req.PluginContext.User.Role != "Admin") {
+		return sendError(sender, http.StatusForbidden, fmt.Errorf("only Grafana admins can list all statements of the warehouse"))
+	}
+	var user *backend.User
+	if !all {
+		user = req.PluginContext.User
+		if user == nil {
+			user = &backend.User{}
+		}
+	}
+
+	queries, err := d.fetchQueryHistory(ctx, pools.authenticator(warehouse), warehouseId, limit, params.Get("dashboardUid"), user)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return sendError(sender, http.StatusBadGateway, err)
+	}
+	jsonBody, err := json.Marshal(queries)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
+
+// fetchQueryHistory returns the recent statements of the warehouse. If user is set, only
+// statements tagged by Grafana or executed by the Databricks user with the email or login
+// of the user are returned. Statements are filtered after fetching, so fewer than limit
+// statements may be returned.
+func (d *Datasource) fetchQueryHistory(ctx context.Context, authenticator auth.Authenticator, warehouseId string, limit int, dashboardUid string, user *backend.User) ([]historyQuery, error) {
+	var body struct {
+		Res []struct {
+			QueryId          string `json:"query_id"`
+			QueryText        string `json:"query_text"`
+			Status           string `json:"status"`
+			UserName         string `json:"user_name"`
+			QueryStartTimeMs int64  `json:"query_start_time_ms"`
+			Duration         int64  `json:"duration"`
+			RowsProduced     *int64 `json:"rows_produced"`
+			ErrorMessage     string `json:"error_message"`
+			Metrics          *struct {
+				ReadBytes int64 `json:"read_bytes"`
+			} `json:"metrics"`
+		} `json:"res"`
+	}
+	maxResults := limit
+	if user != nil || dashboardUid != "" {
+		maxResults = maxHistoryQueries
+	}
+	err := d.apiRequest(ctx, authenticator, http.MethodGet, "/api/2.0/sql/history/queries", map[string]interface{}{
+		"filter_by":       map[string]interface{}{"warehouse_ids": []string{warehouseId}},
+		"max_results":     maxResults,
+		"include_metrics": true,
+	}, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	var tag string
+	if dashboardUid != "" {
+		tag = "dashboard_uid=" + unsafeTagValueRgx.ReplaceAllString(dashboardUid, "_")
+	}
+	queries := make([]historyQuery, 0, len(body.Res))
+	for _, q := range body.Res {
+		if len(queries) == limit {
+			break
+		}
+		if tag != "" && !strings.Contains(q.QueryText, tag+",") && !strings.Contains(q.QueryText, tag+" */") {
+			continue
+		}
+		if user != nil && !strings.HasPrefix(q.QueryText, queryTagsPrefix) && !isHistoryUser(q.UserName, user) {
+			continue
+		}
+		query := historyQuery{
+			QueryId:     q.QueryId,
+			Statement:   q.QueryText,
+			Status:      q.Status,
+			User:        q.UserName,
+			StartTimeMs: q.QueryStartTimeMs,
+			DurationMs:  q.Duration,
+			Rows:        q.RowsProduced,
+			Error:       q.ErrorMessage,
+		}
+		if q.Metrics != nil {
+			query.BytesScanned = &q.Metrics.ReadBytes
+		}
+		queries = append(queries, query)
+	}
+	return queries, nil
+}
+
+// isHistoryUser reports whether the Databricks user name of a statement is the email or
+// login of the Grafana user, i.e. with OAuth pass-through.
+func isHistoryUser(userName string, user *backend.User) bool {
+	return userName != "" && (strings.EqualFold(userName, user.Email) || strings.EqualFold(userName, user.Login))
+}
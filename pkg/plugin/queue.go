@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultMaxRunningQueries is the number of queries executed at once if not configured.
+const defaultMaxRunningQueries = 10
+
+// defaultQueueTimeout is the time a query waits for a free slot if not configured.
+const defaultQueueTimeout = time.Minute
+
+// queueTimeoutError is returned if a query didn't get a slot within the queue timeout.
+type queueTimeoutError struct {
+	limit   int
+	timeout time.Duration
+}
+
+func (e *queueTimeoutError) Error() string {
+	return fmt.Sprintf("the query waited %s for one of the %d query slots of the datasource and was rejected, reduce the number of concurrent queries or increase the limit", e.timeout, e.limit)
+}
+
+// queryQueue limits the number of queries executed at once against the warehouse across
+// all requests of the datasource. Queries exceeding the limit wait until a slot is free.
+type queryQueue struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// newQueryQueue creates a queue for the configured number of running queries and queue
+// timeout in seconds. Zero uses the defaults, a negative limit disables the queue and
+// nil is returned.
+func newQueryQueue(limit int, timeoutSeconds int) *queryQueue {
+	if limit < 0 {
+		return nil
+	}
+	if limit == 0 {
+		limit = defaultMaxRunningQueries
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultQueueTimeout
+	}
+	return &queryQueue{
+		slots:   make(chan struct{}, limit),
+		timeout: timeout,
+	}
+}
+
+// acquire waits for a free slot and returns the time waited. release has to be called
+// once the query is done.
+func (q *queryQueue) acquire(ctx context.Context) (time.Duration, error) {
+	if q == nil {
+		return 0, nil
+	}
+	start := time.Now()
+	select {
+	case q.slots <- struct{}{}:
+		return time.Since(start), nil
+	default:
+	}
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	select {
+	case q.slots <- struct{}{}:
+		return time.Since(start), nil
+	case <-timer.C:
+		return time.Since(start), &queueTimeoutError{limit: cap(q.slots), timeout: q.timeout}
+	case <-ctx.Done():
+		return time.Since(start), ctx.Err()
+	}
+}
+
+func (q *queryQueue) release() {
+	if q == nil {
+		return
+	}
+	<-q.slots
+}
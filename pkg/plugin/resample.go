@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"time"
+)
+
+// resampleFrame resamples a wide frame to the interval over the time range, so every
+// series has a value per interval and gaps are filled as configured by the fill mode,
+// also without long to wide conversion. Rows are assigned to the interval they fall
+// into, if multiple rows fall into the same interval the last one is used. Rows outside
+// the time range are dropped. The interval is increased if the time range would be split
+// into more than maxDataPoints intervals.
+func resampleFrame(frame *data.Frame, timeRange backend.TimeRange, interval time.Duration, maxDataPoints int64, settings querySettings) *data.Frame {
+	schema := frame.TimeSeriesSchema()
+	if schema.Type != data.TimeSeriesTypeWide || !timeRange.To.After(timeRange.From) {
+		return frame
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if maxDataPoints > 0 && int64(timeRange.Duration()/interval) > maxDataPoints {
+		interval = (timeRange.Duration() + time.Duration(maxDataPoints) - 1) / time.Duration(maxDataPoints)
+	}
+
+	// intervals start at multiples of the interval since the epoch, like $__timeGroup
+	start := time.Unix(0, timeRange.From.UnixNano()/int64(interval)*int64(interval)).UTC()
+	count := int(timeRange.To.Sub(start)/interval) + 1
+	times := make([]time.Time, count)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * interval)
+	}
+
+	timeField := frame.Fields[schema.TimeIndex]
+	resampledTime := data.NewField(timeField.Name, timeField.Labels, times)
+	resampledTime.Config = timeField.Config
+	resampled := data.NewFrame(frame.Name, resampledTime)
+	resampled.Meta = frame.Meta
+	var fields []*data.Field
+	for i, field := range frame.Fields {
+		if i == schema.TimeIndex {
+			continue
+		}
+		f := data.NewFieldFromFieldType(field.Type().NullableType(), count)
+		f.Name = field.Name
+		f.Labels = field.Labels
+		f.Config = field.Config
+		fields = append(fields, f)
+		resampled.Fields = append(resampled.Fields, f)
+	}
+
+	for row := 0; row < timeField.Len(); row++ {
+		v, ok := timeField.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		t := v.(time.Time)
+		if t.Before(start) || t.After(timeRange.To) {
+			continue
+		}
+		index := int(t.Sub(start) / interval)
+		j := 0
+		for i, field := range frame.Fields {
+			if i == schema.TimeIndex {
+				continue
+			}
+			if v, ok := field.ConcreteAt(row); ok {
+				fields[j].SetConcrete(index, v)
+			}
+			j++
+		}
+	}
+
+	for _, field := range fields {
+		fillMissing(field, settings)
+	}
+	return resampled
+}
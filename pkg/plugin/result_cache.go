@@ -0,0 +1,311 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultResultCacheMaxSize is the size in MB of the cached results, if not configured.
+const defaultResultCacheMaxSize = 100
+
+// resultCacheEntry is a cached result, encoded as the Arrow frames of the response.
+type resultCacheEntry struct {
+	key     string
+	size    int64
+	cached  time.Time
+	expires time.Time
+	// frames are the encoded frames, nil if the entry is stored on disk.
+	frames [][]byte
+}
+
+// resultCache caches the results of queries, so dashboards refreshed by many viewers
+// don't execute identical queries on the warehouse. Entries are evicted once they
+// expired or, least recently used first, once the size limit is exceeded. If a
+// directory is configured the encoded frames are stored in files instead of memory.
+type resultCache struct {
+	ttl     time.Duration
+	maxSize int64
+	dir     string
+
+	mu      sync.Mutex
+	closed  bool
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// newResultCache creates a cache for the configured TTL in seconds and size in MB,
+// stored in a directory for the datasource below dir if set. Caching is disabled and
+// nil returned if the TTL isn't positive. If the directory can't be created, results
+// are cached in memory.
+func newResultCache(ttlSeconds int, maxSizeMB int, dir string, uid string) *resultCache {
+	if ttlSeconds <= 0 {
+		return nil
+	}
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultResultCacheMaxSize
+	}
+	cache := &resultCache{
+		ttl:     time.Duration(ttlSeconds) * time.Second,
+		maxSize: int64(maxSizeMB) << 20,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if dir != "" {
+		// Every instance of the datasource stores its files in its own subdirectory, as
+		// the previous instance may still be draining and the changed settings may change
+		// the results
+		parent := filepath.Join(dir, "databricks-"+uid)
+		err := os.MkdirAll(parent, 0o700)
+		if err == nil {
+			cache.dir, err = os.MkdirTemp(parent, "instance-")
+		}
+		if err != nil {
+			logger.Error("Result Cache Directory Error", "err", err)
+			cache.dir = ""
+		}
+	}
+	return cache
+}
+
+// close drops the cached results and removes the directory of the cache, once the
+// datasource instance was disposed and its requests are done.
+func (c *resultCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+	if c.dir != "" {
+		err := os.RemoveAll(c.dir)
+		if err != nil {
+			logger.Info("Result Cache Error", "err", err)
+		}
+	}
+}
+
+type resultCacheKey struct{}
+
+// withoutResultCache returns a context whose queries bypass the result cache, i.e. the
+// re-executions of live queries which have to return current results.
+func withoutResultCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, resultCacheKey{}, false)
+}
+
+func useResultCache(ctx context.Context) bool {
+	use, ok := ctx.Value(resultCacheKey{}).(bool)
+	return !ok || use
+}
+
+// normalizeSQL returns the statements of the query string without comments and with
+// the tokens separated by single spaces and upper case keywords, so queries differing
+// only in formatting share the cached result.
+func normalizeSQL(queryString string) string {
+	var b strings.Builder
+	for _, token := range tokenizeSQL(queryString) {
+		if token.kind == formatLineComment || token.kind == formatBlockComment {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(token.text)
+	}
+	return b.String()
+}
+
+// credentialScope identifies the credentials the query of the user is executed with.
+// Users sharing the credentials of the datasource or of a credential mapping share
+// cached results, with OAuth pass-through results are cached per user.
+func (d *Datasource) credentialScope(pCtx backend.PluginContext) string {
+	if d.oauthPassThru {
+		return runningQueryKey(pCtx, "")
+	}
+	for i, pool := range d.credentialMappings {
+		if pool.mapping.matches(pCtx) {
+			return fmt.Sprintf("mapping/%d", i)
+		}
+	}
+	return ""
+}
+
+// resultKey identifies the result of the query with the expanded query string. Besides
+// the normalized SQL, the time range and the options shaping the frames are part of the
+// key, the ids of the query, its dashboard and panel are ignored.
+func (d *Datasource) resultKey(pCtx backend.PluginContext, query backend.DataQuery, qm queryModel, queryString string) (string, error) {
+	uid := ""
+	if pCtx.DataSourceInstanceSettings != nil {
+		uid = pCtx.DataSourceInstanceSettings.UID
+	}
+	qm.RawSqlQuery = ""
+	qm.QueryId = ""
+	qm.DashboardUid = ""
+	qm.PanelId = 0
+	b, err := json.Marshal(struct {
+		Datasource    string
+		Credentials   string
+		SQL           string
+		Query         queryModel
+		From          time.Time
+		To            time.Time
+		Interval      time.Duration
+		MaxDataPoints int64
+	}{uid, d.credentialScope(pCtx), normalizeSQL(queryString), qm, query.TimeRange.From, query.TimeRange.To, query.Interval, query.MaxDataPoints})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// get returns the cached response for the key. The frames are decoded for every
+// request, so responses can be modified. The age of the result is added to the stats
+// of the first frame.
+func (c *resultCache) get(key string) (backend.DataResponse, bool) {
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return backend.DataResponse{}, false
+	}
+	entry := element.Value.(*resultCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		c.mu.Unlock()
+		return backend.DataResponse{}, false
+	}
+	c.lru.MoveToFront(element)
+	encoded := entry.frames
+	c.mu.Unlock()
+
+	if encoded == nil {
+		b, err := os.ReadFile(c.path(key))
+		if err == nil {
+			err = json.Unmarshal(b, &encoded)
+		}
+		if err != nil {
+			logger.Info("Result Cache Error", "err", err)
+			c.mu.Lock()
+			if c.entries[key] == element {
+				c.remove(element)
+			}
+			c.mu.Unlock()
+			return backend.DataResponse{}, false
+		}
+	}
+	frames, err := data.UnmarshalArrowFrames(encoded)
+	if err != nil {
+		logger.Info("Result Cache Error", "err", err)
+		return backend.DataResponse{}, false
+	}
+	if len(frames) > 0 {
+		if frames[0].Meta == nil {
+			frames[0].SetMeta(&data.FrameMeta{})
+		}
+		frames[0].Meta.Stats = append(frames[0].Meta.Stats, data.QueryStat{
+			FieldConfig: data.FieldConfig{DisplayName: "Cache age", Unit: "s"},
+			Value:       time.Since(entry.cached).Seconds(),
+		})
+	}
+	return backend.DataResponse{Frames: frames}, true
+}
+
+// set caches the frames of the response. Results larger than the size of the cache are
+// not cached.
+func (c *resultCache) set(key string, response backend.DataResponse) {
+	encoded, err := response.Frames.MarshalArrow()
+	if err != nil {
+		logger.Info("Result Cache Error", "err", err)
+		return
+	}
+	var size int64
+	for _, frame := range encoded {
+		size += int64(len(frame))
+	}
+	if size > c.maxSize {
+		return
+	}
+	now := time.Now()
+	entry := &resultCacheEntry{key: key, size: size, cached: now, expires: now.Add(c.ttl), frames: encoded}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	// The previous entry of the key is removed before its file is replaced, removing it
+	// afterwards would delete the file of the new entry
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	if c.dir != "" {
+		err := c.writeFile(key, encoded)
+		if err != nil {
+			logger.Info("Result Cache Error", "err", err)
+			return
+		}
+		entry.frames = nil
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+	for c.size > c.maxSize || (c.lru.Len() > 0 && now.After(c.lru.Back().Value.(*resultCacheEntry).expires)) {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops the entry of the element, it has to be called with the lock held.
+func (c *resultCache) remove(element *list.Element) {
+	entry := element.Value.(*resultCacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	if c.dir != "" {
+		err := os.Remove(c.path(entry.key))
+		if err != nil && !os.IsNotExist(err) {
+			logger.Info("Result Cache Error", "err", err)
+		}
+	}
+}
+
+// writeFile stores the encoded frames of the key. The file is written under a temporary
+// name and renamed, so concurrent reads never see a partially written file.
+func (c *resultCache) writeFile(key string, encoded [][]byte) error {
+	b, err := json.Marshal(encoded)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(b)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+func (c *resultCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+)
+
+// defaultMaxRetries is used if the number of retries is not configured.
+const defaultMaxRetries = 3
+
+// transientErrorRgx matches errors which are likely to succeed when retried, i.e. the
+// warehouse is still starting, requests are rate limited or the connection was reset.
+var transientErrorRgx = regexp.MustCompile(`(?i)\b429\b|too many requests|\b503\b|service unavailable|temporarily unavailable|warehouse is starting|is not running|connection reset|broken pipe|connection refused|unexpected EOF`)
+
+// isTransientError reports whether the query failed because of a transient error.
+func isTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return transientErrorRgx.MatchString(err.Error())
+}
+
+// retryPolicy retries transient failures with exponential backoff.
+type retryPolicy struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// newRetryPolicy creates a retry policy for the configured number of retries. Zero
+// uses the default, a negative value disables retries.
+func newRetryPolicy(maxRetries int) retryPolicy {
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	return retryPolicy{
+		maxRetries:     maxRetries,
+		initialBackoff: time.Second,
+		maxBackoff:     30 * time.Second,
+	}
+}
+
+// do runs fn until it succeeds, fails with a non transient error, the retries are
+// exhausted or the context is done. It returns the number of retries made.
+func (p retryPolicy) do(ctx context.Context, fn func() error) (int, error) {
+	backoff := p.initialBackoff
+	retries := 0
+	for {
+		err := fn()
+		if err == nil || retries >= p.maxRetries || !isTransientError(err) {
+			return retries, err
+		}
+		logger.Info("Transient Error, retrying", "err", err, "retry", retries+1, "backoff", backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return retries, err
+		case <-timer.C:
+		}
+
+		retries++
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+// isIdempotent reports whether a statement can safely be executed again after a failure,
+// which is only the case for statements allowed in read only mode.
+func isIdempotent(statement string) bool {
+	return checkStatementReadOnly(statement) == nil
+}
@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"regexp"
+	"sync"
+)
+
+// cancelQueryPathRgx matches the resource path cancelling a running query.
+var cancelQueryPathRgx = regexp.MustCompile(`^queries/([^/]+)/cancel$`)
+
+// runningQueries tracks the queries with an id assigned by the frontend, so they can be
+// cancelled while a panel is still loading.
+type runningQueries struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newRunningQueries() *runningQueries {
+	return &runningQueries{cancels: make(map[string]context.CancelFunc)}
+}
+
+// runningQueryKey scopes query ids to the org and user of the request, so queries can
+// only be cancelled by the user who started them.
+func runningQueryKey(pCtx backend.PluginContext, queryId string) string {
+	login := ""
+	if pCtx.User != nil {
+		login = pCtx.User.Login
+	}
+	return fmt.Sprintf("%d/%s/%s", pCtx.OrgID, login, queryId)
+}
+
+// register returns a context which is cancelled once the query is cancelled, together
+// with a function which has to be called once the query is done.
+func (r *runningQueries) register(ctx context.Context, key string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	r.cancels[key] = cancel
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.cancels, key)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels the query and reports whether it was running. Cancelling the context
+// also cancels the statement on the warehouse.
+func (r *runningQueries) cancel(key string) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[key]
+	delete(r.cancels, key)
+	r.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// cancelQuery handles the resource request cancelling a running query.
+func (d *Datasource) cancelQuery(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, queryId string) error {
+	if req.Method != http.MethodPost {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusMethodNotAllowed,
+			Body:   []byte("Method not allowed"),
+		})
+	}
+	cancelled := d.running.cancel(runningQueryKey(req.PluginContext, queryId))
+	logger.Info("Cancel Query", "queryId", queryId, "cancelled", cancelled)
+	status := http.StatusOK
+	if !cancelled {
+		status = http.StatusNotFound
+	}
+	body, err := json.Marshal(map[string]bool{"cancelled": cancelled})
+	if err != nil {
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: status,
+		Body:   body,
+	})
+}
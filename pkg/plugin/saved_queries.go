@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// savedQueryParameterRgx matches the {{ name }} parameter markers of saved queries,
+// which are replaced by :name markers.
+var savedQueryParameterRgx = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// maxSavedQueryPages limits the pages of saved queries listed, 100 queries each.
+const maxSavedQueryPages = 10
+
+// savedQueryInfo is a Databricks SQL saved query, as returned by the saved-queries
+// resource.
+type savedQueryInfo struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+}
+
+// listSavedQueries handles the resource request listing the saved queries of the
+// workspace visible to the user, sorted by name.
+func (d *Datasource) listSavedQueries(ctx context.Context, sender backend.CallResourceResponseSender, authenticator auth.Authenticator) error {
+	queries := make([]savedQueryInfo, 0)
+	pageToken := ""
+	for page := 0; page < maxSavedQueryPages; page++ {
+		var body struct {
+			Results []struct {
+				Id            string `json:"id"`
+				DisplayName   string `json:"display_name"`
+				Description   string `json:"description"`
+				OwnerUserName string `json:"owner_user_name"`
+			} `json:"results"`
+			NextPageToken string `json:"next_page_token"`
+		}
+		path := "/api/2.0/sql/queries?page_size=100"
+		if pageToken != "" {
+			path += "&page_token=" + url.QueryEscape(pageToken)
+		}
+		err := d.apiRequest(ctx, authenticator, http.MethodGet, path, nil, &body)
+		if err != nil {
+			return sendError(sender, http.StatusBadGateway, err)
+		}
+		for _, q := range body.Results {
+			queries = append(queries, savedQueryInfo{Id: q.Id, Name: q.DisplayName, Description: q.Description, Owner: q.OwnerUserName})
+		}
+		pageToken = body.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	jsonBody, err := json.Marshal(queries)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
+
+// savedQuery returns the SQL of the saved query, with its {{ name }} parameter markers
+// replaced by :name markers, so they are bound to the parameters of the Grafana query.
+// The SQL is cached like the metadata.
+func (d *Datasource) savedQuery(ctx context.Context, pCtx backend.PluginContext, authenticator auth.Authenticator, id string) (string, error) {
+	body, err := d.metadataCache.get(runningQueryKey(pCtx, "saved-queries/"+id), func() ([]byte, error) {
+		var body struct {
+			QueryText string `json:"query_text"`
+		}
+		err := d.apiRequest(ctx, authenticator, http.MethodGet, "/api/2.0/sql/queries/"+url.PathEscape(id), nil, &body)
+		if err != nil {
+			return nil, err
+		}
+		if body.QueryText == "" {
+			return nil, fmt.Errorf("the saved query %s has no SQL", id)
+		}
+		return []byte(body.QueryText), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return savedQueryParameterRgx.ReplaceAllString(string(body), ":$1"), nil
+}
@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// secretRefreshInterval defines how long a resolved secret is used before it is
+// resolved again, so rotated secrets are picked up without re-saving the datasource.
+const secretRefreshInterval = 5 * time.Minute
+
+// Prefixes of the token field referencing a secret stored outside of Grafana.
+const (
+	secretEnvPrefix   = "env:"
+	secretVaultPrefix = "vault:"
+	secretAWSPrefix   = "aws-sm:"
+)
+
+// secretPrefixEnv is the environment variable holding the comma separated prefixes of
+// the secret references datasources are allowed to resolve, e.g.
+// "env:DATABRICKS_,vault:secret/data/grafana/". The references are chosen by the users
+// editing the datasource and are resolved with the privileges of the Grafana server, so
+// no references are allowed unless the operator configured them.
+const secretPrefixEnv = "GF_PLUGIN_DATABRICKS_SECRET_PREFIX"
+
+// checkSecretReference returns an error unless the reference starts with one of the
+// prefixes allowed by the operator. References leaving their prefix via ".." are
+// rejected.
+func checkSecretReference(reference string) error {
+	if strings.Contains(reference, "..") {
+		return fmt.Errorf("secret reference must not contain ..")
+	}
+	for _, prefix := range strings.Split(os.Getenv(secretPrefixEnv), ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" && strings.HasPrefix(reference, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("secret reference is not allowed, the allowed prefixes are configured by %s", secretPrefixEnv)
+}
+
+// isSecretReference reports whether the token references an external secret.
+func isSecretReference(token string) bool {
+	return strings.HasPrefix(token, secretEnvPrefix) ||
+		strings.HasPrefix(token, secretVaultPrefix) ||
+		strings.HasPrefix(token, secretAWSPrefix)
+}
+
+// secretReferenceTokenProvider resolves the access token from an external secret store
+// whenever the token is refreshed. Supported references are:
+//
+//	env:DATABRICKS_TOKEN                          environment variable
+//	vault:secret/data/databricks#token            HashiCorp Vault KV secret and key
+//	aws-sm:arn:aws:secretsmanager:...:secret:name AWS Secrets Manager secret
+//
+// Vault is accessed using the VAULT_ADDR and VAULT_TOKEN environment variables, AWS using
+// the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+// Only references allowed by secretPrefixEnv are resolved.
+type secretReferenceTokenProvider struct {
+	reference  string
+	httpClient *http.Client
+}
+
+func newSecretReferenceTokenProvider(reference string) *secretReferenceTokenProvider {
+	return &secretReferenceTokenProvider{
+		reference:  reference,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *secretReferenceTokenProvider) fetchToken() (string, time.Time, error) {
+	err := checkSecretReference(p.reference)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to resolve the access token secret: %w", err)
+	}
+	var token string
+	switch {
+	case strings.HasPrefix(p.reference, secretEnvPrefix):
+		name := strings.TrimPrefix(p.reference, secretEnvPrefix)
+		token = os.Getenv(name)
+		if token == "" {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+	case strings.HasPrefix(p.reference, secretVaultPrefix):
+		token, err = p.resolveVaultSecret(strings.TrimPrefix(p.reference, secretVaultPrefix))
+	case strings.HasPrefix(p.reference, secretAWSPrefix):
+		token, err = p.resolveAWSSecret(strings.TrimPrefix(p.reference, secretAWSPrefix))
+	default:
+		err = fmt.Errorf("unsupported secret reference")
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to resolve the access token secret: %w", err)
+	}
+	return token, time.Now().Add(secretRefreshInterval), nil
+}
+
+// resolveVaultSecret reads a key of a Vault KV secret. The reference has the format
+// <path>#<key>, both KV version 1 and 2 secrets are supported.
+func (p *secretReferenceTokenProvider) resolveVaultSecret(reference string) (string, error) {
+	path, key, found := strings.Cut(reference, "#")
+	if !found || key == "" {
+		return "", fmt.Errorf("vault secret reference must have the format vault:<path>#<key>")
+	}
+	vaultAddr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if vaultAddr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/%s", vaultAddr, strings.TrimPrefix(path, "/")), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault request failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+
+	data := body.Data
+	// KV version 2 nests the secret data in an additional data field
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[key].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("vault secret does not contain key %s", key)
+	}
+	return value, nil
+}
+
+// resolveAWSSecret reads the string value of an AWS Secrets Manager secret. If the
+// secret value is a JSON object, the reference can select a key using <arn>#<key>.
+func (p *secretReferenceTokenProvider) resolveAWSSecret(reference string) (string, error) {
+	arn, key, _ := strings.Cut(reference, "#")
+	arnParts := strings.Split(arn, ":")
+	if len(arnParts) < 7 || arnParts[2] != "secretsmanager" {
+		return "", fmt.Errorf("invalid secrets manager ARN")
+	}
+	region := arnParts[3]
+
+	payload, err := json.Marshal(map[string]string{"SecretId": arn})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", region), strings.NewReader(string(payload)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	err = signAWSRequest(req, payload, region, "secretsmanager", time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secrets manager request failed with status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		SecretString string `json:"SecretString"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return body.SecretString, nil
+	}
+
+	var values map[string]interface{}
+	err = json.Unmarshal([]byte(body.SecretString), &values)
+	if err != nil {
+		return "", fmt.Errorf("secret value is not a JSON object: %w", err)
+	}
+	value, ok := values[key].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("secret does not contain key %s", key)
+	}
+	return value, nil
+}
+
+// signAWSRequest signs the request using AWS Signature Version 4 with the credentials
+// from the environment.
+func signAWSRequest(req *http.Request, payload []byte, region string, service string, now time.Time) error {
+	accessKeyId := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKeyId == "" || secretAccessKey == "" {
+		return fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY have to be set")
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if sessionToken := os.Getenv("AWS_SESSION_TOKEN"); sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	// Signed headers have to be sorted by name
+	signedHeaderNames := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date", "x-amz-target"}
+	if req.Header.Get("X-Amz-Security-Token") != "" {
+		signedHeaderNames = []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date", "x-amz-security-token", "x-amz-target"}
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaderNames {
+		canonicalHeaders.WriteString(fmt.Sprintf("%s:%s\n", name, strings.TrimSpace(req.Header.Get(name))))
+	}
+	signedHeaders := strings.Join(signedHeaderNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		credentialScope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyId, credentialScope, signedHeaders, signature))
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
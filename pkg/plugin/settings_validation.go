@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// settingsError describes an invalid or missing datasource setting.
+type settingsError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// settingsErrors is the list of problems found validating the datasource settings.
+type settingsErrors []settingsError
+
+func (e settingsErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Message)
+	}
+	return strings.Join(messages, " ")
+}
+
+// validateSettings checks the settings for missing or malformed values, so problems can
+// be reported per field instead of surfacing as generic connection errors.
+func validateSettings(settings *DatasourceSettings, secureJSONData map[string]string) settingsErrors {
+	var errs settingsErrors
+	add := func(field string, format string, args ...interface{}) {
+		errs = append(errs, settingsError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	hostname := strings.TrimSpace(settings.Hostname)
+	switch {
+	case hostname == "":
+		add("hostname", "Server Hostname is missing.")
+	case strings.Contains(strings.TrimPrefix(strings.TrimPrefix(hostname, "https://"), "http://"), "/"):
+		add("hostname", "Server Hostname must not contain a path, i.e. XXX.cloud.databricks.com.")
+	}
+
+	if settings.Port != "" {
+		port, err := strconv.Atoi(settings.Port)
+		if err != nil || port < 1 || port > 65535 {
+			add("port", "Server Port %q is not a valid port.", settings.Port)
+		}
+	}
+
+	path := strings.TrimSpace(settings.Path)
+	switch {
+	case path == "":
+		add("path", "HTTP Path is missing.")
+	case strings.Contains(path, "://"):
+		add("path", "HTTP Path must be the path only, i.e. sql/1.0/warehouses/XXX.")
+	}
+
+	errs = append(errs, validateCredentials("", credentials{
+		AuthenticationMethod: settings.AuthenticationMethod,
+		TenantId:             settings.TenantId,
+		ClientId:             settings.ClientId,
+		Token:                secureJSONData["token"],
+		ClientSecret:         secureJSONData["clientSecret"],
+	}, settings.OAuthPassThru)...)
+
+	for i, mapping := range settings.CredentialMappings {
+		secretKey := func(name string) string {
+			return secureJSONData[fmt.Sprintf("credentialMappings.%d.%s", i, name)]
+		}
+		errs = append(errs, validateCredentials(fmt.Sprintf("credentialMappings.%d.", i), credentials{
+			AuthenticationMethod: mapping.AuthenticationMethod,
+			TenantId:             mapping.TenantId,
+			ClientId:             mapping.ClientId,
+			Token:                secretKey("token"),
+			ClientSecret:         secretKey("clientSecret"),
+		}, false)...)
+	}
+
+	names := make(map[string]bool, len(settings.Warehouses))
+	for i, w := range settings.Warehouses {
+		field := fmt.Sprintf("warehouses.%d", i)
+		switch {
+		case w.Name == "":
+			add(field+".name", "Warehouse %d has no name.", i+1)
+		case names[w.Name]:
+			add(field+".name", "Warehouse name %q is used more than once.", w.Name)
+		}
+		names[w.Name] = true
+		if w.Path == "" {
+			add(field+".path", "Warehouse %q has no HTTP Path.", w.Name)
+		}
+	}
+
+	macroNames := make(map[string]bool, len(settings.Macros))
+	for i, macro := range settings.Macros {
+		field := fmt.Sprintf("macros.%d", i)
+		name := macro.macroName()
+		switch {
+		case !userMacroNameRgx.MatchString(name):
+			add(field+".name", "Macro name %q is invalid, it must start with a letter followed by letters, digits or underscores.", macro.Name)
+		case macroNames[name]:
+			add(field+".name", "Macro name %q is used more than once.", macro.Name)
+		}
+		macroNames[name] = true
+		if strings.TrimSpace(macro.Template) == "" {
+			add(field+".template", "Macro %q has no template.", macro.Name)
+		}
+	}
+
+	if settings.FiscalYearStartMonth < 0 || settings.FiscalYearStartMonth > 12 {
+		add("fiscalYearStartMonth", "Fiscal Year Start Month %d is invalid, expected 1 (January) to 12 (December).", settings.FiscalYearStartMonth)
+	}
+
+	switch strings.ToLower(settings.TimestampNtzTimezone) {
+	case "", ntzTimezoneUTC, ntzTimezoneDashboard:
+	default:
+		add("timestampNtzTimezone", "TIMESTAMP_NTZ Timezone %q is not supported, expected %q or %q.", settings.TimestampNtzTimezone, ntzTimezoneUTC, ntzTimezoneDashboard)
+	}
+
+	switch settings.ExecutionMode {
+	case "", executionModeDriver:
+	case executionModeStatementAPI:
+		if path != "" && warehouseIdFromPath(path) == "" {
+			add("path", "The Statement Execution API requires the HTTP Path of a SQL warehouse, i.e. /sql/1.0/warehouses/XXX.")
+		}
+		switch strings.ToUpper(settings.ResultDisposition) {
+		case "", dispositionInline, dispositionExternalLinks:
+		default:
+			add("resultDisposition", "Result Disposition %q is not supported.", settings.ResultDisposition)
+		}
+	default:
+		add("executionMode", "Execution Mode %q is not supported.", settings.ExecutionMode)
+	}
+
+	return errs
+}
+
+// validateCredentials checks that the values required by the authentication method are
+// set. Fields are prefixed with prefix.
+func validateCredentials(prefix string, creds credentials, oauthPassThru bool) settingsErrors {
+	var errs settingsErrors
+	missing := func(field string, name string) {
+		errs = append(errs, settingsError{Field: prefix + field, Message: fmt.Sprintf("%s is required for the selected authentication method.", name)})
+	}
+
+	switch creds.AuthenticationMethod {
+	case "", "pat":
+		// The token isn't used if the identity of the user is forwarded
+		if creds.Token == "" && !oauthPassThru {
+			missing("token", "Access Token")
+		}
+	case "m2m":
+		if creds.ClientId == "" {
+			missing("clientId", "Client ID")
+		}
+		if creds.ClientSecret == "" {
+			missing("clientSecret", "Client Secret")
+		}
+	case "azure":
+		if creds.TenantId == "" {
+			missing("tenantId", "Tenant ID")
+		}
+		if creds.ClientId == "" {
+			missing("clientId", "Client ID")
+		}
+		if creds.ClientSecret == "" {
+			missing("clientSecret", "Client Secret")
+		}
+	case "azure-msi", "gcp":
+	default:
+		errs = append(errs, settingsError{Field: prefix + "authenticationMethod", Message: fmt.Sprintf("Authentication Method %q is not supported.", creds.AuthenticationMethod)})
+	}
+	return errs
+}
@@ -0,0 +1,370 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"strings"
+)
+
+// formatIndent is the indentation of a nesting level of formatted SQL.
+const formatIndent = "  "
+
+// formatKeywords are the keywords upper cased by formatSQL. Identifiers are case
+// insensitive in Databricks, so columns named like keywords are upper cased too.
+var formatKeywords = map[string]bool{
+	"ALL": true, "AND": true, "ANTI": true, "AS": true, "ASC": true, "BETWEEN": true, "BY": true,
+	"CASE": true, "CLUSTER": true, "CROSS": true, "CURRENT": true, "DESC": true, "DISTINCT": true,
+	"DISTRIBUTE": true, "ELSE": true, "END": true, "EXCEPT": true, "EXISTS": true, "FALSE": true,
+	"FILTER": true, "FIRST": true, "FOLLOWING": true, "FROM": true, "FULL": true, "GROUP": true,
+	"HAVING": true, "ILIKE": true, "IN": true, "INNER": true, "INTERSECT": true, "INTERVAL": true,
+	"IS": true, "JOIN": true, "LAST": true, "LATERAL": true, "LEFT": true, "LIKE": true, "LIMIT": true,
+	"NATURAL": true, "NOT": true, "NULL": true, "NULLS": true, "OFFSET": true, "ON": true, "OR": true,
+	"ORDER": true, "OUTER": true, "OVER": true, "PARTITION": true, "PERCENT": true, "PRECEDING": true,
+	"QUALIFY": true, "RANGE": true, "RIGHT": true, "RLIKE": true, "ROW": true, "ROWS": true,
+	"SELECT": true, "SEMI": true, "SORT": true, "TABLESAMPLE": true, "THEN": true, "TRUE": true,
+	"UNBOUNDED": true, "UNION": true, "USING": true, "VALUES": true, "VIEW": true, "WHEN": true,
+	"WHERE": true, "WINDOW": true, "WITH": true,
+}
+
+// functionKeywords are keywords which are also functions, i.e. left(str, len), so no
+// space is put between them and an opening parenthesis.
+var functionKeywords = map[string]bool{"LEFT": true, "RIGHT": true, "FIRST": true, "LAST": true}
+
+type formatTokenKind int
+
+const (
+	formatWord formatTokenKind = iota
+	formatKeyword
+	formatQuoted
+	formatNumber
+	formatVariable
+	formatOperator
+	formatPunctuation
+	formatLineComment
+	formatBlockComment
+)
+
+type formatToken struct {
+	kind formatTokenKind
+	text string
+	// unary is set for signs of numbers and expressions, i.e. -1
+	unary bool
+}
+
+// tokenizeSQL splits the query string into tokens, dropping whitespace. Keywords are
+// upper cased, unless they are part of a qualified name like t.value.
+func tokenizeSQL(queryString string) []formatToken {
+	var tokens []formatToken
+	i := 0
+	for i < len(queryString) {
+		c := queryString[i]
+		start := i
+		var kind formatTokenKind
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+			continue
+		case c == '\'' || c == '"' || c == '`':
+			i = quotedEnd(queryString, i)
+			kind = formatQuoted
+		case strings.HasPrefix(queryString[i:], "--"):
+			end := strings.IndexByte(queryString[i:], '\n')
+			if end == -1 {
+				i = len(queryString)
+			} else {
+				i += end
+			}
+			kind = formatLineComment
+		case strings.HasPrefix(queryString[i:], "/*"):
+			end := strings.Index(queryString[i+2:], "*/")
+			if end == -1 {
+				i = len(queryString)
+			} else {
+				i += end + 4
+			}
+			kind = formatBlockComment
+		case c == '$' && strings.HasPrefix(queryString[i:], "${"):
+			end := strings.IndexByte(queryString[i:], '}')
+			if end == -1 {
+				i = len(queryString)
+			} else {
+				i += end + 1
+			}
+			kind = formatVariable
+		case (c == '$' || (c == ':' && !jsonPathColon(queryString, i))) && i+1 < len(queryString) && isIdentifierStart(queryString[i+1]):
+			i++
+			for i < len(queryString) && isIdentifierPart(queryString[i]) {
+				i++
+			}
+			kind = formatVariable
+		case isIdentifierStart(c):
+			for i < len(queryString) && isIdentifierPart(queryString[i]) {
+				i++
+			}
+			kind = formatWord
+		case c >= '0' && c <= '9':
+			for i < len(queryString) && (isIdentifierPart(queryString[i]) || queryString[i] == '.') {
+				i++
+			}
+			kind = formatNumber
+		case strings.ContainsRune("(),;.[]", rune(c)):
+			i++
+			kind = formatPunctuation
+		default:
+			for i < len(queryString) && strings.ContainsRune("=<>!|&+-*/%^~:", rune(queryString[i])) {
+				// a sign following another operator is unary, i.e. = -1
+				if i > start && (queryString[i] == '-' || queryString[i] == '+') {
+					break
+				}
+				i++
+				// a following comment starts a new token
+				if i < len(queryString) && (strings.HasPrefix(queryString[i:], "--") || strings.HasPrefix(queryString[i:], "/*")) {
+					break
+				}
+			}
+			if i == start {
+				i++
+			}
+			kind = formatOperator
+		}
+		tokens = append(tokens, formatToken{kind: kind, text: queryString[start:i]})
+	}
+
+	for i, token := range tokens {
+		if token.text == "-" || token.text == "+" {
+			tokens[i].unary = i == 0 || tokens[i-1].kind == formatOperator || tokens[i-1].kind == formatKeyword ||
+				tokens[i-1].text == "(" || tokens[i-1].text == "[" || tokens[i-1].text == ","
+		}
+		if token.kind != formatWord || !formatKeywords[strings.ToUpper(token.text)] {
+			continue
+		}
+		if (i > 0 && tokens[i-1].text == ".") || (i+1 < len(tokens) && tokens[i+1].text == ".") {
+			continue
+		}
+		tokens[i] = formatToken{kind: formatKeyword, text: strings.ToUpper(token.text)}
+	}
+	return tokens
+}
+
+// formatScope is a level of parentheses of a query being formatted. Clauses of queries
+// and subqueries start new lines, other parentheses are kept on one line.
+type formatScope struct {
+	query  bool
+	level  int
+	clause string
+	// between is set after BETWEEN, until the AND of its range
+	between bool
+}
+
+// formatSQL formats the query string, with each clause on its own line, the selected
+// expressions and the conditions of filters on separate indented lines, and subqueries
+// indented. Keywords are upper cased, string literals, quoted identifiers, comments,
+// macros and variables are kept as they are.
+func formatSQL(queryString string) string {
+	tokens := tokenizeSQL(queryString)
+	var out bytes.Buffer
+	lineStart := true
+	lineLevel := 0
+	// lineOffset is the offset of the current line, which is re-indented if a new line
+	// is started before anything was written to it
+	lineOffset := 0
+	newline := func(level int) {
+		if lineStart {
+			out.Truncate(lineOffset)
+		} else {
+			out.WriteString("\n")
+			lineOffset = out.Len()
+		}
+		if out.Len() > 0 {
+			out.WriteString(strings.Repeat(formatIndent, level))
+		}
+		lineStart = true
+		lineLevel = level
+	}
+
+	scopes := []*formatScope{{query: true}}
+	breakAfterSelect := false
+	var prev *formatToken
+	for i := range tokens {
+		token := tokens[i]
+		scope := scopes[len(scopes)-1]
+		upper := strings.ToUpper(token.text)
+
+		if breakAfterSelect && !(token.kind == formatKeyword && (upper == "DISTINCT" || upper == "ALL")) {
+			breakAfterSelect = false
+			newline(scope.level + 1)
+		}
+
+		if scope.query && token.kind == formatKeyword {
+			switch upper {
+			case "SELECT", "FROM", "WHERE", "HAVING", "QUALIFY", "WINDOW", "LIMIT", "OFFSET", "UNION", "INTERSECT", "VALUES":
+				newline(scope.level)
+				scope.clause = upper
+			case "EXCEPT":
+				// SELECT * EXCEPT (column) excludes columns
+				if i+1 < len(tokens) && tokens[i+1].text != "(" {
+					newline(scope.level)
+					scope.clause = upper
+				}
+			case "WITH":
+				if prev == nil || prev.text == ";" || prev.text == "(" {
+					scope.clause = upper
+				}
+			case "GROUP", "ORDER", "CLUSTER", "DISTRIBUTE", "SORT":
+				if i+1 < len(tokens) && tokens[i+1].text == "BY" {
+					newline(scope.level)
+					scope.clause = upper
+				}
+			case "LEFT", "RIGHT", "FULL", "INNER", "CROSS", "NATURAL", "LATERAL":
+				if startsJoin(tokens[i:]) && (prev == nil || !joinModifier(prev.text)) {
+					newline(scope.level)
+					scope.clause = "JOIN"
+				}
+			case "JOIN":
+				if prev == nil || !joinModifier(prev.text) {
+					newline(scope.level)
+				}
+				scope.clause = "JOIN"
+			case "BETWEEN":
+				scope.between = true
+			case "AND", "OR":
+				if upper == "AND" && scope.between {
+					scope.between = false
+				} else if scope.clause == "WHERE" || scope.clause == "HAVING" || scope.clause == "QUALIFY" || scope.clause == "JOIN" {
+					newline(scope.level + 1)
+				}
+			}
+		}
+
+		switch {
+		case token.text == ")" && scope.query && len(scopes) > 1:
+			scopes = scopes[:len(scopes)-1]
+			newline(scopes[len(scopes)-1].level)
+		case token.text == ")" && len(scopes) > 1:
+			scopes = scopes[:len(scopes)-1]
+		}
+
+		if !lineStart && spaceBefore(prev, token) {
+			out.WriteString(" ")
+		}
+		out.WriteString(token.text)
+		lineStart = false
+
+		switch {
+		case token.text == "(":
+			next := ""
+			if i+1 < len(tokens) {
+				next = strings.ToUpper(tokens[i+1].text)
+			}
+			if next == "SELECT" || next == "WITH" {
+				scopes = append(scopes, &formatScope{query: true, level: scope.level + 1})
+				newline(scope.level + 1)
+			} else {
+				scopes = append(scopes, &formatScope{level: scope.level})
+			}
+		case token.text == "," && scope.query && scope.clause == "SELECT":
+			newline(scope.level + 1)
+		case token.text == "," && scope.query && scope.clause == "WITH":
+			newline(scope.level)
+		case token.kind == formatKeyword && upper == "SELECT" && scope.query:
+			breakAfterSelect = true
+		case token.kind == formatKeyword && (upper == "DISTINCT" || upper == "ALL") && prev != nil && prev.text == "SELECT":
+			breakAfterSelect = true
+		case token.text == ";":
+			scopes = []*formatScope{{query: true}}
+			out.WriteString("\n")
+			lineStart = false
+			newline(0)
+		case token.kind == formatLineComment:
+			newline(lineLevel)
+		}
+		prev = &tokens[i]
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// startsJoin reports whether the tokens start with a join type followed by JOIN, i.e.
+// LEFT OUTER JOIN.
+func startsJoin(tokens []formatToken) bool {
+	for _, token := range tokens {
+		switch {
+		case token.text == "JOIN":
+			return true
+		case !joinModifier(token.text):
+			return false
+		}
+	}
+	return false
+}
+
+func joinModifier(keyword string) bool {
+	switch keyword {
+	case "LEFT", "RIGHT", "FULL", "INNER", "CROSS", "NATURAL", "OUTER", "SEMI", "ANTI", "LATERAL":
+		return true
+	}
+	return false
+}
+
+// spaceBefore reports whether the token is separated from the previous one by a space.
+func spaceBefore(prev *formatToken, token formatToken) bool {
+	if prev == nil {
+		return false
+	}
+	switch token.text {
+	case ",", ")", "]", ".", ";":
+		return false
+	case "(", "[":
+		switch prev.kind {
+		case formatWord, formatVariable, formatQuoted:
+			return prev.text[0] == '\'' || prev.text[0] == '"'
+		case formatKeyword:
+			return !functionKeywords[prev.text]
+		case formatPunctuation:
+			return prev.text != ")" && prev.text != "]" && prev.text != "(" && prev.text != "["
+		}
+		return true
+	case "::", ":":
+		return false
+	}
+	switch prev.text {
+	case "(", "[", ".", "::", ":":
+		return false
+	}
+	return !prev.unary
+}
+
+// jsonPathColon reports whether the colon at i extracts a path of a JSON string, i.e.
+// raw:field, rather than starting a parameter marker like :name.
+func jsonPathColon(queryString string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	c := queryString[i-1]
+	return isIdentifierPart(c) || c == '`' || c == ']' || c == ')'
+}
+
+type formatRequestBody struct {
+	RawSqlQuery string `json:"rawSqlQuery"`
+}
+
+// formatQuery handles the resource request formatting the SQL of the request body,
+// without sending it to Databricks.
+func (d *Datasource) formatQuery(req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	var body formatRequestBody
+	err := json.Unmarshal(req.Body, &body)
+	if err != nil {
+		return sendError(sender, http.StatusBadRequest, err)
+	}
+
+	jsonBody, err := json.Marshal(previewResponseBody{Sql: formatSQL(body.RawSqlQuery)})
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
@@ -0,0 +1,500 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Execution modes of the datasource.
+const (
+	executionModeDriver       = "driver"
+	executionModeStatementAPI = "statementApi"
+)
+
+// Result dispositions of the Statement Execution API.
+const (
+	dispositionInline        = "INLINE"
+	dispositionExternalLinks = "EXTERNAL_LINKS"
+)
+
+// Result formats of the Statement Execution API.
+const (
+	formatJSONArray   = "JSON_ARRAY"
+	formatArrowStream = "ARROW_STREAM"
+)
+
+// statementPollInterval is the maximum interval the state of a running statement is polled.
+const statementPollInterval = 5 * time.Second
+
+// statementAPIClient executes statements using the Databricks SQL Statement Execution
+// API instead of the driver. Statements are submitted asynchronously and polled over
+// plain HTTPS requests, which works through proxies terminating long-lived connections
+// and doesn't hold sessions open between queries.
+type statementAPIClient struct {
+	hostname    string
+	catalog     string
+	schema      string
+	disposition string
+	format      string
+	httpClient  *http.Client
+	// downloadClient fetches external links, which are pre-signed cloud storage URLs and
+	// must not receive the Databricks credentials.
+	downloadClient  *http.Client
+	downloadThreads int
+}
+
+// newStatementAPIClient creates a client returning results with the given disposition.
+// If cloud fetch is enabled, results are always downloaded from external links as Arrow
+// streams.
+func newStatementAPIClient(connection connectionSettings, disposition string) *statementAPIClient {
+	disposition = strings.ToUpper(disposition)
+	if disposition != dispositionExternalLinks {
+		disposition = dispositionInline
+	}
+	format := formatJSONArray
+	if connection.cloudFetch {
+		disposition = dispositionExternalLinks
+		format = formatArrowStream
+	}
+	return &statementAPIClient{
+		hostname:        strings.TrimPrefix(strings.TrimPrefix(connection.hostname, "https://"), "http://"),
+		catalog:         connection.catalog,
+		schema:          connection.schema,
+		disposition:     disposition,
+		format:          format,
+		httpClient:      &http.Client{Transport: connection.transport, Timeout: time.Minute},
+		downloadClient:  &http.Client{Transport: connection.downloadTransport, Timeout: 5 * time.Minute},
+		downloadThreads: connection.downloadThreads(),
+	}
+}
+
+type statementStatus struct {
+	State string `json:"state"`
+	Error *struct {
+		ErrorCode string `json:"error_code"`
+		Message   string `json:"message"`
+	} `json:"error"`
+}
+
+type statementColumn struct {
+	Name     string `json:"name"`
+	TypeName string `json:"type_name"`
+	Position int    `json:"position"`
+}
+
+type statementResult struct {
+	ChunkIndex     int         `json:"chunk_index"`
+	DataArray      [][]*string `json:"data_array"`
+	NextChunkIndex *int        `json:"next_chunk_index"`
+	ExternalLinks  []struct {
+		ExternalLink   string `json:"external_link"`
+		NextChunkIndex *int   `json:"next_chunk_index"`
+	} `json:"external_links"`
+}
+
+type statementResponse struct {
+	StatementId string          `json:"statement_id"`
+	Status      statementStatus `json:"status"`
+	Manifest    *struct {
+		Schema struct {
+			Columns []statementColumn `json:"columns"`
+		} `json:"schema"`
+		// Truncated is set if the result exceeded the row limit.
+		Truncated bool `json:"truncated"`
+	} `json:"manifest"`
+	Result *statementResult `json:"result"`
+}
+
+// useStatementRgx matches USE statements selecting the catalog and schema, capturing the
+// type of the object and its name.
+var useStatementRgx = regexp.MustCompile(`(?is)^USE\s+(?:(CATALOG|SCHEMA|DATABASE|NAMESPACE)\s+)?(.+)$`)
+
+// statementAPIExecutor executes the statements of a query on one warehouse. Every
+// statement is executed in its own session, so the catalog and schema selected by USE
+// statements are sent with the following statements instead.
+type statementAPIExecutor struct {
+	client        *statementAPIClient
+	authenticator auth.Authenticator
+	warehouseId   string
+	conversion    conversionOptions
+	catalog       string
+	schema        string
+}
+
+// exec executes a statement followed by other statements. USE statements set the
+// catalog and schema of the following statements, SET statements are rejected as they
+// would have no effect on them.
+func (e *statementAPIExecutor) exec(ctx context.Context, statement string) error {
+	switch statementKeyword(statement) {
+	case "USE":
+		return e.use(statement)
+	case "SET":
+		return fmt.Errorf("SET statements can't be followed by other statements in the Statement Execution API mode, every statement is executed in its own session")
+	}
+	_, err := e.client.execute(ctx, e.authenticator, e.warehouseId, e.catalog, e.schema, statement, -1)
+	return err
+}
+
+// use sets the catalog and schema selected by a USE statement.
+func (e *statementAPIExecutor) use(statement string) error {
+	statement = strings.TrimSpace(sqlCommentRgx.ReplaceAllString(statement, " "))
+	match := useStatementRgx.FindStringSubmatch(statement)
+	if match == nil {
+		return fmt.Errorf("invalid USE statement %q", statement)
+	}
+	name := splitIdentifier(match[2])
+	switch {
+	case strings.EqualFold(match[1], "CATALOG") && len(name) == 1:
+		// The default schema of the catalog is used
+		e.catalog, e.schema = name[0], ""
+	case !strings.EqualFold(match[1], "CATALOG") && len(name) == 1:
+		e.schema = name[0]
+	case !strings.EqualFold(match[1], "CATALOG") && len(name) == 2:
+		e.catalog, e.schema = name[0], name[1]
+	default:
+		return fmt.Errorf("invalid USE statement %q", statement)
+	}
+	return nil
+}
+
+func (e *statementAPIExecutor) query(ctx context.Context, statement string, maxRows int64) (*data.Frame, error) {
+	resp, err := e.client.execute(ctx, e.authenticator, e.warehouseId, e.catalog, e.schema, statement, maxRows)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := e.client.frame(ctx, e.authenticator, resp, e.conversion)
+	if err != nil {
+		return nil, err
+	}
+	frameStats(frame).QueryId = resp.StatementId
+	if resp.Manifest != nil && resp.Manifest.Truncated {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Results have been limited to %v because the SQL row limit was reached", maxRows),
+		})
+	}
+	return frame, nil
+}
+
+// execute submits a statement in the catalog and schema and waits until it finished.
+// If the context is done before, the statement is cancelled. The result is limited to
+// maxRows rows unless it is negative.
+func (c *statementAPIClient) execute(ctx context.Context, authenticator auth.Authenticator, warehouseId string, catalog string, schema string, statement string, maxRows int64) (*statementResponse, error) {
+	body := map[string]interface{}{
+		"statement":       statement,
+		"warehouse_id":    warehouseId,
+		"disposition":     c.disposition,
+		"format":          c.format,
+		"wait_timeout":    "10s",
+		"on_wait_timeout": "CONTINUE",
+	}
+	if catalog != "" {
+		body["catalog"] = catalog
+	}
+	if schema != "" {
+		body["schema"] = schema
+	}
+	if maxRows >= 0 {
+		body["row_limit"] = maxRows
+	}
+
+	resp := new(statementResponse)
+	err := c.do(ctx, authenticator, http.MethodPost, "/api/2.0/sql/statements", body, resp)
+	if err != nil {
+		return nil, err
+	}
+	statementStarted(ctx, resp.StatementId)
+
+	pollInterval := 500 * time.Millisecond
+	for resp.Status.State == "PENDING" || resp.Status.State == "RUNNING" {
+		timer := time.NewTimer(pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			c.cancel(authenticator, resp.StatementId)
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if pollInterval *= 2; pollInterval > statementPollInterval {
+			pollInterval = statementPollInterval
+		}
+
+		statementId := resp.StatementId
+		resp = new(statementResponse)
+		err = c.do(ctx, authenticator, http.MethodGet, "/api/2.0/sql/statements/"+statementId, nil, resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.Status.State != "SUCCEEDED" {
+		if resp.Status.Error != nil {
+			return nil, fmt.Errorf("statement %s: [%s] %s", strings.ToLower(resp.Status.State), resp.Status.Error.ErrorCode, resp.Status.Error.Message)
+		}
+		return nil, fmt.Errorf("statement %s", strings.ToLower(resp.Status.State))
+	}
+	return resp, nil
+}
+
+// cancel cancels a running statement, independent of the context of the request which
+// is already done.
+func (c *statementAPIClient) cancel(authenticator auth.Authenticator, statementId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := c.do(ctx, authenticator, http.MethodPost, fmt.Sprintf("/api/2.0/sql/statements/%s/cancel", statementId), nil, nil)
+	if err != nil {
+		logger.Info("Statement Cancel Error", "statementId", statementId, "err", err)
+	}
+}
+
+// frame fetches all result chunks of a finished statement and converts them to a frame.
+func (c *statementAPIClient) frame(ctx context.Context, authenticator auth.Authenticator, resp *statementResponse, conversion conversionOptions) (*data.Frame, error) {
+	frame := data.NewFrame("response")
+	if resp.Manifest == nil {
+		return frame, nil
+	}
+	columns := resp.Manifest.Schema.Columns
+	for _, column := range columns {
+		frame.Fields = append(frame.Fields, newStatementField(column, conversion))
+	}
+	if c.format == formatArrowStream {
+		return c.arrowFrame(ctx, authenticator, resp, frame, conversion)
+	}
+
+	invalid := make(invalidValues)
+	result := resp.Result
+	for result != nil {
+		rows := result.DataArray
+		var next *int
+		if len(result.ExternalLinks) > 0 {
+			var err error
+			rows, err = c.downloadJSON(ctx, result.ExternalLinks[0].ExternalLink)
+			if err != nil {
+				return nil, err
+			}
+			next = result.ExternalLinks[0].NextChunkIndex
+		} else {
+			next = result.NextChunkIndex
+		}
+
+		for _, row := range rows {
+			for i, column := range columns {
+				var value *string
+				if i < len(row) {
+					value = row[i]
+				}
+				err := appendStatementValue(frame.Fields[i], column, value, conversion, invalid)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if next == nil {
+			break
+		}
+		result = new(statementResult)
+		err := c.do(ctx, authenticator, http.MethodGet, fmt.Sprintf("/api/2.0/sql/statements/%s/result/chunks/%d", resp.StatementId, *next), nil, result)
+		if err != nil {
+			return nil, err
+		}
+	}
+	frame.AppendNotices(invalid.notices()...)
+	return frame, nil
+}
+
+// arrowFrame downloads the Arrow streams of all result chunks in parallel and appends
+// them to frame in order. The fields of frame are replaced by the ones of the Arrow
+// schema once a chunk was downloaded.
+func (c *statementAPIClient) arrowFrame(ctx context.Context, authenticator auth.Authenticator, resp *statementResponse, frame *data.Frame, conversion conversionOptions) (*data.Frame, error) {
+	var links []string
+	result := resp.Result
+	for result != nil && len(result.ExternalLinks) > 0 {
+		for _, link := range result.ExternalLinks {
+			links = append(links, link.ExternalLink)
+		}
+		next := result.ExternalLinks[len(result.ExternalLinks)-1].NextChunkIndex
+		if next == nil {
+			break
+		}
+		result = new(statementResult)
+		err := c.do(ctx, authenticator, http.MethodGet, fmt.Sprintf("/api/2.0/sql/statements/%s/result/chunks/%d", resp.StatementId, *next), nil, result)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(links) == 0 {
+		return frame, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chunks := make([][]*data.Field, len(links))
+	errs := make([]error, len(links))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, c.downloadThreads)
+	for i, link := range links {
+		wg.Add(1)
+		go func(i int, link string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			err := c.download(ctx, link, func(body io.Reader) error {
+				var err error
+				chunks[i], err = arrowStreamFields(body, conversion)
+				return err
+			})
+			if err != nil {
+				errs[i] = err
+				cancel()
+			}
+		}(i, link)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	frame.Fields = chunks[0]
+	for _, chunk := range chunks[1:] {
+		for i, field := range chunk {
+			for j := 0; j < field.Len(); j++ {
+				frame.Fields[i].Append(field.At(j))
+			}
+		}
+	}
+	// Complex types are serialized as JSON strings in Arrow results
+	for i, column := range resp.Manifest.Schema.Columns {
+		if i < len(frame.Fields) && complexTypes[column.TypeName] && frame.Fields[i].Type() == data.FieldTypeNullableString {
+			frame.Fields[i] = jsonField(frame.Fields[i])
+		}
+	}
+	return frame, nil
+}
+
+// downloadJSON fetches a JSON_ARRAY result chunk from an external link.
+func (c *statementAPIClient) downloadJSON(ctx context.Context, link string) ([][]*string, error) {
+	var rows [][]*string
+	err := c.download(ctx, link, func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&rows)
+	})
+	return rows, err
+}
+
+// download fetches a result chunk from an external link and passes its body to read.
+func (c *statementAPIClient) download(ctx context.Context, link string, read func(body io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.downloadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("result download failed with status %d", resp.StatusCode)
+	}
+	return read(resp.Body)
+}
+
+func (c *statementAPIClient) do(ctx context.Context, authenticator auth.Authenticator, method string, path string, body interface{}, result interface{}) error {
+	return doAPIRequest(ctx, c.httpClient, c.hostname, authenticator, method, path, body, result)
+}
+
+// newStatementField creates a nullable field matching the type of the column.
+func newStatementField(column statementColumn, conversion conversionOptions) *data.Field {
+	if (column.TypeName == "DECIMAL" && conversion.decimalAsString) || (column.TypeName == "LONG" && conversion.bigintAsString) {
+		return data.NewField(column.Name, nil, []*string{})
+	}
+	switch column.TypeName {
+	case "BOOLEAN":
+		return data.NewField(column.Name, nil, []*bool{})
+	case "BYTE", "SHORT", "INT", "LONG":
+		return data.NewField(column.Name, nil, []*int64{})
+	case "FLOAT", "DOUBLE", "DECIMAL":
+		return data.NewField(column.Name, nil, []*float64{})
+	case "DATE", "TIMESTAMP", "TIMESTAMP_NTZ":
+		return data.NewField(column.Name, nil, []*time.Time{})
+	case "ARRAY", "MAP", "STRUCT", "VARIANT":
+		return data.NewField(column.Name, nil, []*json.RawMessage{})
+	case "INTERVAL":
+		return conversion.newIntervalField(column.Name)
+	default:
+		return data.NewField(column.Name, nil, []*string{})
+	}
+}
+
+// appendStatementValue parses a JSON_ARRAY result value and appends it to the field
+// created by newStatementField for the column. Invalid dates and timestamps are
+// appended as null and added to invalid.
+func appendStatementValue(field *data.Field, column statementColumn, value *string, conversion conversionOptions, invalid invalidValues) error {
+	if value == nil {
+		field.Append(nil)
+		return nil
+	}
+	v := *value
+	switch column.TypeName {
+	case "BOOLEAN":
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		field.Append(&b)
+	case "BYTE", "SHORT", "INT", "LONG":
+		if field.Type() == data.FieldTypeNullableString {
+			field.Append(&v)
+			return nil
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.Append(&i)
+	case "FLOAT", "DOUBLE", "DECIMAL":
+		if field.Type() == data.FieldTypeNullableString {
+			field.Append(&v)
+			return nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		field.Append(&f)
+	case "DATE", "TIMESTAMP", "TIMESTAMP_NTZ":
+		t, err := parseTimestamp(v)
+		if err != nil {
+			invalid.add(column.Name, err)
+			field.Append(nil)
+			return nil
+		}
+		if column.TypeName == "TIMESTAMP_NTZ" {
+			t = conversion.ntzTime(t)
+		}
+		field.Append(&t)
+	case "ARRAY", "MAP", "STRUCT", "VARIANT":
+		j := jsonValue(v)
+		field.Append(&j)
+	case "INTERVAL":
+		months, d, err := parseInterval(v)
+		if err != nil {
+			return err
+		}
+		field.Append(conversion.intervalValue(months, d))
+	default:
+		field.Append(&v)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"strings"
+)
+
+// resultKeywords are the leading keywords of statements returning rows.
+var resultKeywords = []string{"SELECT", "WITH", "SHOW", "DESCRIBE", "DESC", "EXPLAIN", "VALUES", "TABLE", "FROM", "LIST"}
+
+// splitStatements splits a query string into its statements, omitting empty ones.
+// Semicolons inside string literals, quoted identifiers, comments and BEGIN ... END
+// blocks don't terminate a statement.
+func splitStatements(queryString string) []string {
+	statements := make([]string, 0, 1)
+	appendStatement := func(statement string) {
+		if strings.TrimSpace(sqlCommentRgx.ReplaceAllString(statement, " ")) != "" {
+			statements = append(statements, statement)
+		}
+	}
+
+	start := 0
+	// depth of nested BEGIN ... END and CASE ... END blocks
+	depth := 0
+	i := 0
+	for i < len(queryString) {
+		c := queryString[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = quotedEnd(queryString, i)
+		case c == '-' && strings.HasPrefix(queryString[i:], "--"):
+			end := strings.IndexByte(queryString[i:], '\n')
+			if end == -1 {
+				i = len(queryString)
+			} else {
+				i += end
+			}
+		case c == '/' && strings.HasPrefix(queryString[i:], "/*"):
+			end := strings.Index(queryString[i+2:], "*/")
+			if end == -1 {
+				i = len(queryString)
+			} else {
+				i += end + 4
+			}
+		case isIdentifierStart(c) && (i == 0 || !isIdentifierPart(queryString[i-1])):
+			end := i + 1
+			for end < len(queryString) && isIdentifierPart(queryString[end]) {
+				end++
+			}
+			switch strings.ToUpper(queryString[i:end]) {
+			case "BEGIN", "CASE":
+				depth++
+			case "END":
+				// END IF, END LOOP, ... close blocks which didn't increase the depth
+				if depth > 0 && !closesUncountedBlock(queryString[end:]) {
+					depth--
+				}
+			}
+			i = end
+		case c == ';' && depth == 0:
+			appendStatement(queryString[start:i])
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+	appendStatement(queryString[start:])
+	return statements
+}
+
+// closesUncountedBlock reports whether the keyword following an END closes a block
+// other than BEGIN or CASE.
+func closesUncountedBlock(rest string) bool {
+	keyword := strings.ToUpper(sqlKeywordRgx.FindString(strings.TrimLeft(rest, " \t\r\n")))
+	switch keyword {
+	case "IF", "LOOP", "WHILE", "REPEAT", "FOR":
+		return true
+	}
+	return false
+}
+
+// producesResult reports whether a statement returns rows, i.e. a SELECT statement, as
+// opposed to statements like SET or INSERT.
+func producesResult(statement string) bool {
+	keyword := statementKeyword(statement)
+	for _, resultKeyword := range resultKeywords {
+		if keyword == resultKeyword {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTopLevelKeyword reports whether the statement contains the keyword outside of
+// parentheses, string literals and comments, i.e. a LIMIT of the outermost query.
+func hasTopLevelKeyword(statement string, keyword string) bool {
+	depth := 0
+	i := 0
+	for i < len(statement) {
+		c := statement[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = quotedEnd(statement, i)
+		case c == '-' && strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end == -1 {
+				return false
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end == -1 {
+				return false
+			}
+			i += end + 4
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case isIdentifierStart(c) && (i == 0 || !isIdentifierPart(statement[i-1])):
+			end := i + 1
+			for end < len(statement) && isIdentifierPart(statement[end]) {
+				end++
+			}
+			if depth == 0 && strings.EqualFold(statement[i:end], keyword) {
+				return true
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return false
+}
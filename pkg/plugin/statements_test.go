@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"single", "SELECT 1", []string{"SELECT 1"}},
+		{"trailing semicolon", "SELECT 1;", []string{"SELECT 1"}},
+		{"multiple", "USE main; SELECT 1", []string{"USE main", " SELECT 1"}},
+		{"empty statements", ";; SELECT 1 ;;", []string{" SELECT 1 "}},
+		{"comment only statement", "SELECT 1; -- done\n", []string{"SELECT 1"}},
+		{"semicolon in single quotes", "SELECT ';' AS a", []string{"SELECT ';' AS a"}},
+		{"semicolon in double quotes", `SELECT ";" AS a`, []string{`SELECT ";" AS a`}},
+		{"semicolon in backticks", "SELECT 1 AS `a;b`", []string{"SELECT 1 AS `a;b`"}},
+		{"escaped quote", `SELECT 'it\'s;' AS a; SELECT 2`, []string{`SELECT 'it\'s;' AS a`, " SELECT 2"}},
+		{"doubled quote", "SELECT 'it''s;' AS a; SELECT 2", []string{"SELECT 'it''s;' AS a", " SELECT 2"}},
+		{"escaped backslash", `SELECT '\\'; SELECT 2`, []string{`SELECT '\\'`, " SELECT 2"}},
+		{"semicolon in line comment", "SELECT 1 -- a;b\n; SELECT 2", []string{"SELECT 1 -- a;b\n", " SELECT 2"}},
+		{"semicolon in block comment", "SELECT /* a;b */ 1; SELECT 2", []string{"SELECT /* a;b */ 1", " SELECT 2"}},
+		{"unterminated quote", "SELECT 'a; SELECT 2", []string{"SELECT 'a; SELECT 2"}},
+		{"unterminated comment", "SELECT 1 /* a; SELECT 2", []string{"SELECT 1 /* a; SELECT 2"}},
+		{"compound statement", "BEGIN SELECT 1; SELECT 2; END; SELECT 3", []string{"BEGIN SELECT 1; SELECT 2; END", " SELECT 3"}},
+		{
+			"nested blocks",
+			"BEGIN IF x THEN SELECT 1; END IF; BEGIN SELECT 2; END; END; SELECT 3",
+			[]string{"BEGIN IF x THEN SELECT 1; END IF; BEGIN SELECT 2; END; END", " SELECT 3"},
+		},
+		{
+			"loops",
+			"BEGIN WHILE x DO SELECT 1; END WHILE; LOOP SELECT 2; END LOOP; END; SELECT 3",
+			[]string{"BEGIN WHILE x DO SELECT 1; END WHILE; LOOP SELECT 2; END LOOP; END", " SELECT 3"},
+		},
+		{
+			"case in compound statement",
+			"BEGIN SELECT CASE WHEN a THEN 1 ELSE 2 END; SELECT 3; END; SELECT 4",
+			[]string{"BEGIN SELECT CASE WHEN a THEN 1 ELSE 2 END; SELECT 3; END", " SELECT 4"},
+		},
+		{"case", "SELECT CASE WHEN a THEN 1 END AS b; SELECT 2", []string{"SELECT CASE WHEN a THEN 1 END AS b", " SELECT 2"}},
+		{"keywords in identifiers", "SELECT case_id, begin_ts, weekend FROM t; SELECT 2", []string{"SELECT case_id, begin_ts, weekend FROM t", " SELECT 2"}},
+		{"keywords in strings", "SELECT 'BEGIN'; SELECT 2", []string{"SELECT 'BEGIN'", " SELECT 2"}},
+		{"casts", "SELECT a::STRING, b::INT; SELECT 2", []string{"SELECT a::STRING, b::INT", " SELECT 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.query)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasTopLevelKeyword(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      bool
+	}{
+		{"SELECT * FROM t LIMIT 10", true},
+		{"SELECT * FROM (SELECT * FROM t LIMIT 10)", false},
+		{"SELECT 'LIMIT' FROM t", false},
+		{"SELECT * FROM t -- LIMIT 10", false},
+		{"SELECT * FROM t /* LIMIT 10 */", false},
+		{"SELECT limit_rows FROM t", false},
+	}
+	for _, tt := range tests {
+		if got := hasTopLevelKeyword(tt.statement, "LIMIT"); got != tt.want {
+			t.Errorf("hasTopLevelKeyword(%q, LIMIT) = %v, want %v", tt.statement, got, tt.want)
+		}
+	}
+}
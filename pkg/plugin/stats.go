@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"context"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// queryStats are added to the custom meta of the frames returned by a statement, so the
+// query inspector shows how expensive the statement was.
+type queryStats struct {
+	// QueryId is the id of the statement in the Databricks query history.
+	QueryId    string `json:"queryId,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Rows       int    `json:"rows"`
+	// BytesScanned is only set if query metrics are enabled.
+	BytesScanned *int64 `json:"bytesScanned,omitempty"`
+}
+
+// frameStats returns the stats in the custom meta of the frame, adding them if missing.
+func frameStats(frame *data.Frame) *queryStats {
+	if frame.Meta == nil {
+		frame.SetMeta(&data.FrameMeta{})
+	}
+	stats, ok := frame.Meta.Custom.(*queryStats)
+	if !ok {
+		stats = &queryStats{}
+		frame.Meta.Custom = stats
+	}
+	return stats
+}
+
+// queryMetrics looks up the metrics of finished statements in the query history.
+type queryMetrics struct {
+	hostname   string
+	httpClient *http.Client
+}
+
+func newQueryMetrics(connection connectionSettings) *queryMetrics {
+	return &queryMetrics{
+		hostname:   strings.TrimPrefix(strings.TrimPrefix(connection.hostname, "https://"), "http://"),
+		httpClient: &http.Client{Transport: connection.transport, Timeout: 10 * time.Second},
+	}
+}
+
+// bytesScanned returns the bytes read by the statement, or nil if the metrics are not
+// available yet. Errors are only logged, metrics are informational.
+func (m *queryMetrics) bytesScanned(ctx context.Context, authenticator auth.Authenticator, queryId string) *int64 {
+	var body struct {
+		Res []struct {
+			QueryId string `json:"query_id"`
+			Metrics *struct {
+				ReadBytes int64 `json:"read_bytes"`
+			} `json:"metrics"`
+		} `json:"res"`
+	}
+	err := doAPIRequest(ctx, m.httpClient, m.hostname, authenticator, http.MethodGet, "/api/2.0/sql/history/queries", map[string]interface{}{
+		"filter_by":       map[string]interface{}{"statement_ids": []string{queryId}},
+		"include_metrics": true,
+	}, &body)
+	if err != nil {
+		logger.Info("Query Metrics Error", "queryId", queryId, "err", err)
+		return nil
+	}
+	for _, query := range body.Res {
+		if query.QueryId == queryId && query.Metrics != nil {
+			return &query.Metrics.ReadBytes
+		}
+	}
+	return nil
+}
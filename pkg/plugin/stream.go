@@ -0,0 +1,446 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// liveDefaultInterval is the interval live queries are executed in if none is set.
+	liveDefaultInterval = 10 * time.Second
+	// liveMinInterval is the shortest interval live queries can be executed in.
+	liveMinInterval = 5 * time.Second
+	// liveQueryTTL is the time a live query without subscribers is kept, the frontend
+	// subscribes right after the query returned the channel.
+	liveQueryTTL = 10 * time.Minute
+	// livePathPrefix is the prefix of the channel paths of live queries.
+	livePathPrefix = "live/"
+)
+
+const (
+	liveTriggerInterval = "interval"
+	liveTriggerVersion  = "version"
+	liveTriggerChanges  = "changes"
+	liveTriggerTail     = "tail"
+)
+
+// changeDataColumns are the metadata columns added by table_changes, which are dropped
+// so the changes have the columns of the table.
+const changeDataColumns = "_change_type, _commit_version, _commit_timestamp"
+
+// liveQuery is a query re-executed by the stream of its channel.
+type liveQuery struct {
+	pCtx    backend.PluginContext
+	headers backend.ForwardHTTPHeaders
+	query   backend.DataQuery
+	qm      queryModel
+	// lastTime is the latest time of the result returned to the panel, from which tail
+	// queries continue.
+	lastTime time.Time
+	lastUsed time.Time
+}
+
+// liveQueries holds the live queries by the path of their channel. Queries are
+// registered by QueryData and looked up once a panel subscribes to the channel.
+type liveQueries struct {
+	mu      sync.Mutex
+	queries map[string]*liveQuery
+}
+
+func newLiveQueries() *liveQueries {
+	return &liveQueries{queries: make(map[string]*liveQuery)}
+}
+
+// add registers the query under the path and drops queries which weren't used within
+// the TTL.
+func (l *liveQueries) add(path string, q *liveQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for p, other := range l.queries {
+		if time.Since(other.lastUsed) > liveQueryTTL {
+			delete(l.queries, p)
+		}
+	}
+	q.lastUsed = time.Now()
+	l.queries[path] = q
+}
+
+// get returns the query registered under the path.
+func (l *liveQueries) get(path string) (*liveQuery, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	q, ok := l.queries[path]
+	if ok {
+		q.lastUsed = time.Now()
+	}
+	return q, ok
+}
+
+// liveInterval returns the interval the query is executed in, at least liveMinInterval.
+func liveInterval(seconds int) time.Duration {
+	if seconds <= 0 {
+		return liveDefaultInterval
+	}
+	interval := time.Duration(seconds) * time.Second
+	if interval < liveMinInterval {
+		return liveMinInterval
+	}
+	return interval
+}
+
+// livePath returns the channel path of the query. The path doesn't depend on the time
+// range, so refreshing the dashboard keeps the subscription, but on the user, so
+// subscribers can't receive results of queries run with other credentials.
+func livePath(pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) (string, error) {
+	qm.QueryId = ""
+	b, err := json.Marshal(struct {
+		Key           string
+		RefID         string
+		Query         queryModel
+		Range         time.Duration
+		Interval      time.Duration
+		MaxDataPoints int64
+	}{runningQueryKey(pCtx, ""), query.RefID, qm, query.TimeRange.To.Sub(query.TimeRange.From), query.Interval, query.MaxDataPoints})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return livePathPrefix + hex.EncodeToString(sum[:]), nil
+}
+
+// publishLive registers the live query and sets the channel of its stream on the last
+// frame of the response, to which the frontend subscribes.
+func (d *Datasource) publishLive(pCtx backend.PluginContext, pools *requestPools, query backend.DataQuery, qm queryModel, response *backend.DataResponse) {
+	if pCtx.DataSourceInstanceSettings == nil || len(response.Frames) == 0 {
+		return
+	}
+	path, err := livePath(pCtx, query, qm)
+	if err != nil {
+		logger.Info("Live Query Error", "err", err)
+		return
+	}
+	// The frames can be shared with identical queries, the channel is only set on a copy
+	frame := copyFrame(response.Frames[len(response.Frames)-1])
+	response.Frames[len(response.Frames)-1] = frame
+	lastTime, _ := latestTime(frame)
+	d.liveQueries.add(path, &liveQuery{pCtx: pCtx, headers: pools.headers, query: query, qm: qm, lastTime: lastTime})
+
+	if frame.Meta == nil {
+		frame.SetMeta(&data.FrameMeta{})
+	}
+	frame.Meta.Channel = fmt.Sprintf("ds/%s/%s", pCtx.DataSourceInstanceSettings.UID, path)
+}
+
+// SubscribeStream is called when a panel subscribes to the channel of a live query, to
+// the progress of a query or to the utilization of a warehouse. Only the user who ran
+// the query can subscribe to a live query, the progress of queries is looked up by the
+// id of the query and the subscribing user. Warehouses have to be configured in the
+// datasource and can't be streamed with OAuth pass-through.
+func (d *Datasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if strings.HasPrefix(req.Path, progressPathPrefix) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+	}
+	if warehouseId, ok := strings.CutPrefix(req.Path, warehousePathPrefix); ok {
+		if _, ok := d.configuredWarehouse(warehouseId); !ok {
+			return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+		}
+		if d.oauthPassThru {
+			return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+		}
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+	}
+	if !strings.HasPrefix(req.Path, livePathPrefix) {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	q, ok := d.liveQueries.get(req.Path)
+	if !ok {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	if runningQueryKey(q.pCtx, "") != runningQueryKey(req.PluginContext, "") {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	}
+	// The version and changes triggers poll the history of the table, which has to pass
+	// the statement filter like the query itself
+	trigger := q.qm.QuerySettings.LiveTrigger
+	if trigger == liveTriggerVersion || trigger == liveTriggerChanges {
+		if table := queryTable(q.qm.RawSqlQuery); table != nil {
+			err := d.checkStatements(tableVersionStatement(table))
+			if err != nil {
+				logger.Info("Live Query rejected", "err", err, "path", req.Path)
+				return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+			}
+		}
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream is called when a client publishes to a channel, which isn't supported.
+func (d *Datasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream re-executes the live query of the channel while it has subscribers and
+// sends the frame of its last statement. The time range keeps its duration and is moved
+// to end at the time of each execution. With the version trigger the query is only
+// executed once the version of the Delta table it selects from changed. With the changes
+// trigger only the rows inserted or updated since the last version are selected from the
+// change data feed of the table, with the tail trigger only the rows newer than the
+// latest time delivered, which are appended to the frame of the panel.
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	if queryId, ok := strings.CutPrefix(req.Path, progressPathPrefix); ok {
+		return d.runProgressStream(ctx, req.PluginContext, queryId, sender)
+	}
+	if warehouseId, ok := strings.CutPrefix(req.Path, warehousePathPrefix); ok {
+		return d.runWarehouseStream(ctx, req.PluginContext, warehouseId, sender)
+	}
+	q, ok := d.liveQueries.get(req.Path)
+	if !ok {
+		return fmt.Errorf("the live query %s was not found, it may have expired or the datasource settings changed", req.Path)
+	}
+	settings := q.qm.QuerySettings
+	var table []string
+	switch settings.LiveTrigger {
+	case liveTriggerVersion:
+		table = queryTable(q.qm.RawSqlQuery)
+		if table == nil {
+			logger.Info("Live Query Error", "err", "no table found, falling back to the interval trigger", "path", req.Path)
+		}
+	case liveTriggerChanges:
+		table = queryTable(q.qm.RawSqlQuery)
+		if table == nil {
+			return fmt.Errorf("the changes of the live query %s can't be read, it doesn't select from a table", req.Path)
+		}
+	}
+	logger.Info("Live Query started", "path", req.Path, "trigger", settings.LiveTrigger)
+	defer logger.Info("Live Query stopped", "path", req.Path)
+
+	state := &liveState{lastTime: q.lastTime}
+	if table != nil {
+		// The version of the result the panel already shows is recorded right away, so
+		// no changes are missed until the first tick
+		_, err := d.runLiveQuery(ctx, q, table, state)
+		if err != nil {
+			logger.Info("Live Query Error", "err", err, "path", req.Path)
+		}
+	}
+	ticker := time.NewTicker(liveInterval(settings.LiveInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if d.isDisposed() {
+			return nil
+		}
+		// Refreshing the panel registers the query again with the current credentials,
+		// which also keeps it from expiring while it is streamed
+		if latest, ok := d.liveQueries.get(req.Path); ok {
+			q = latest
+			if q.lastTime.After(state.lastTime) {
+				state.lastTime = q.lastTime
+			}
+		}
+
+		frame, err := d.runLiveQuery(ctx, q, table, state)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Info("Live Query Error", "err", err, "path", req.Path)
+			continue
+		}
+		if frame == nil || (appendsRows(settings.LiveTrigger) && frame.Rows() == 0) {
+			continue
+		}
+		err = sender.SendFrame(frame, data.IncludeAll)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// liveState is the state of the stream of a live query between its executions.
+type liveState struct {
+	// version is the last version seen of the table of the query, empty until the first
+	// version was recorded.
+	version string
+	// lastTime is the latest time delivered by a tail query.
+	lastTime time.Time
+}
+
+// appendsRows reports whether the frames streamed with the trigger are appended to the
+// frame of the panel, instead of replacing it.
+func appendsRows(trigger string) bool {
+	return trigger == liveTriggerChanges || trigger == liveTriggerTail
+}
+
+// runLiveQuery executes the live query and returns the frame of its last statement. If
+// the table is set, the query is only executed if its version differs from the version
+// of the state, otherwise no frame is returned. The first version seen only records the
+// version of the result the panel already shows. Tail queries are executed from the
+// latest time of the state and only return the rows after it.
+func (d *Datasource) runLiveQuery(ctx context.Context, q *liveQuery, table []string, state *liveState) (*data.Frame, error) {
+	ctx, cancel := d.withDrainDeadline(ctx)
+	defer cancel()
+	pools := d.poolsForRequest(q.pCtx, q.headers)
+	defer pools.release()
+
+	version := ""
+	if table != nil {
+		db, err := pools.db(q.qm.Warehouse)
+		if err != nil {
+			return nil, err
+		}
+		version, err = tableVersion(ctx, db, table, d.checkStatements)
+		if err != nil {
+			return nil, err
+		}
+		if state.version == "" || version == state.version {
+			state.version = version
+			return nil, nil
+		}
+	}
+
+	qm := q.qm
+	if qm.QuerySettings.LiveTrigger == liveTriggerChanges {
+		rawSqlQuery, err := changesQuery(qm.RawSqlQuery, state.version, version)
+		if err != nil {
+			return nil, err
+		}
+		qm.RawSqlQuery = rawSqlQuery
+	}
+
+	query := q.query
+	now := time.Now()
+	query.TimeRange = backend.TimeRange{From: now.Add(-query.TimeRange.To.Sub(query.TimeRange.From)), To: now}
+	tail := qm.QuerySettings.LiveTrigger == liveTriggerTail && !state.lastTime.IsZero()
+	if tail {
+		query.TimeRange.From = state.lastTime
+	}
+	response := d.runQuery(withoutResultCache(ctx), pools, q.pCtx, query, qm)
+	if response.Error != nil {
+		return nil, response.Error
+	}
+	if table != nil {
+		state.version = version
+	}
+	if len(response.Frames) == 0 {
+		return nil, nil
+	}
+	frame := response.Frames[len(response.Frames)-1]
+	if qm.QuerySettings.LiveTrigger == liveTriggerTail {
+		if tail {
+			var err error
+			frame, err = rowsAfter(frame, state.lastTime)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if latest, ok := latestTime(frame); ok {
+			state.lastTime = latest
+		}
+	}
+	return frame, nil
+}
+
+// latestTime returns the latest value of the first time field of the frame.
+func latestTime(frame *data.Frame) (time.Time, bool) {
+	indices := frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+	if len(indices) == 0 {
+		return time.Time{}, false
+	}
+	var latest time.Time
+	field := frame.Fields[indices[0]]
+	for i := 0; i < field.Len(); i++ {
+		if t, ok := field.ConcreteAt(i); ok && t.(time.Time).After(latest) {
+			latest = t.(time.Time)
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+// rowsAfter returns the rows of the frame whose first time field is after the time. The
+// time filter of tail queries includes the latest time delivered, whose rows were
+// already sent.
+func rowsAfter(frame *data.Frame, after time.Time) (*data.Frame, error) {
+	indices := frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("the tail query returned no time column")
+	}
+	return frame.FilterRowsByField(indices[0], func(value interface{}) (bool, error) {
+		switch t := value.(type) {
+		case time.Time:
+			return t.After(after), nil
+		case *time.Time:
+			return t != nil && t.After(after), nil
+		}
+		return false, nil
+	})
+}
+
+// tableVersionStatement returns the statement reading the latest version of the Delta
+// table from its history.
+func tableVersionStatement(table []string) string {
+	quoted := make([]string, len(table))
+	for i, part := range table {
+		quoted[i] = quoteIdentifier(part)
+	}
+	return "DESCRIBE HISTORY " + strings.Join(quoted, ".") + " LIMIT 1"
+}
+
+// tableVersion returns the latest version of the Delta table from its history. The
+// statement is checked with check before it is executed.
+func tableVersion(ctx context.Context, db *sql.DB, table []string, check func(string) error) (string, error) {
+	statement := tableVersionStatement(table)
+	err := check(statement)
+	if err != nil {
+		return "", err
+	}
+	history, err := queryRecords(ctx, db, statement)
+	if err != nil {
+		return "", err
+	}
+	if len(history) == 0 {
+		return "", fmt.Errorf("no history returned for table %s", strings.Join(table, "."))
+	}
+	return history[0]["version"], nil
+}
+
+// changesQuery replaces the first table the query selects from by the rows inserted or
+// updated after lastVersion up to version, read from the change data feed of the table.
+func changesQuery(rawSqlQuery string, lastVersion string, version string) (string, error) {
+	from, err := strconv.ParseInt(lastVersion, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid table version %q: %w", lastVersion, err)
+	}
+	to, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid table version %q: %w", version, err)
+	}
+	match := queryTableRgx.FindStringSubmatchIndex(rawSqlQuery)
+	if match == nil {
+		return "", fmt.Errorf("the query doesn't select from a table")
+	}
+	table := splitIdentifier(rawSqlQuery[match[2]:match[3]])
+	quoted := make([]string, len(table))
+	for i, part := range table {
+		quoted[i] = quoteIdentifier(part)
+	}
+	changes := fmt.Sprintf(
+		"(SELECT * EXCEPT (%s) FROM table_changes(%s, %d, %d) WHERE _change_type IN ('insert', 'update_postimage'))",
+		changeDataColumns, quoteString(strings.Join(quoted, ".")), from+1, to,
+	)
+	return rawSqlQuery[:match[2]] + changes + rawSqlQuery[match[3]:], nil
+}
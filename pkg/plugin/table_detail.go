@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// largeTableBytes is the size above which querying a table without filter on its
+// partition columns is warned about.
+const largeTableBytes = 10 << 30
+
+// tableStatisticsRowsRgx extracts the row count of the statistics of DESCRIBE TABLE
+// EXTENDED, i.e. 1024 bytes, 10 rows.
+var tableStatisticsRowsRgx = regexp.MustCompile(`(\d+) rows`)
+
+// tableDetail describes the layout and size of a table, so the query editor can warn
+// about expensive queries.
+type tableDetail struct {
+	Name              string   `json:"name"`
+	Format            string   `json:"format"`
+	PartitionColumns  []string `json:"partitionColumns"`
+	ClusteringColumns []string `json:"clusteringColumns"`
+	NumFiles          *int64   `json:"numFiles,omitempty"`
+	SizeInBytes       *int64   `json:"sizeInBytes,omitempty"`
+	// RowCount is the row count of the table statistics, only set if the table was
+	// analyzed.
+	RowCount     *int64   `json:"rowCount,omitempty"`
+	LastModified string   `json:"lastModified,omitempty"`
+	LastOptimize string   `json:"lastOptimize,omitempty"`
+	LastVacuum   string   `json:"lastVacuum,omitempty"`
+	Warnings     []string `json:"warnings"`
+}
+
+// describeTableDetail looks up the detail of the table using DESCRIBE DETAIL, DESCRIBE
+// TABLE EXTENDED and DESCRIBE HISTORY. Only DESCRIBE DETAIL is required, the statistics
+// and history are not available for all tables and errors are only logged. The
+// statements are only executed if check allows them.
+func describeTableDetail(ctx context.Context, db *sql.DB, table []string, check func(string) error) (*tableDetail, error) {
+	quoted := make([]string, len(table))
+	for i, part := range table {
+		quoted[i] = quoteIdentifier(part)
+	}
+	name := strings.Join(quoted, ".")
+
+	statement := "DESCRIBE DETAIL " + name
+	err := check(statement)
+	if err != nil {
+		return nil, err
+	}
+	records, err := queryRecords(ctx, db, statement)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no detail returned for table %s", name)
+	}
+	record := records[0]
+	detail := &tableDetail{
+		Name:              record["name"],
+		Format:            record["format"],
+		PartitionColumns:  stringArray(record["partitionColumns"]),
+		ClusteringColumns: stringArray(record["clusteringColumns"]),
+		NumFiles:          parseCount(record["numFiles"]),
+		SizeInBytes:       parseCount(record["sizeInBytes"]),
+		LastModified:      record["lastModified"],
+		Warnings:          make([]string, 0),
+	}
+
+	var rows [][]string
+	statement = "DESCRIBE TABLE EXTENDED " + name
+	err = check(statement)
+	if err == nil {
+		rows, err = queryStrings(ctx, db, statement)
+	}
+	if err != nil {
+		logger.Info("Table Statistics Error", "err", err)
+	}
+	for _, row := range rows {
+		if len(row) >= 2 && row[0] == "Statistics" {
+			if match := tableStatisticsRowsRgx.FindStringSubmatch(row[1]); match != nil {
+				detail.RowCount = parseCount(match[1])
+			}
+		}
+	}
+
+	var history []map[string]string
+	statement = "DESCRIBE HISTORY " + name + " LIMIT 1000"
+	err = check(statement)
+	if err == nil {
+		history, err = queryRecords(ctx, db, statement)
+	}
+	if err != nil {
+		logger.Info("Table History Error", "err", err)
+	}
+	// the history is ordered by version, newest first
+	for _, entry := range history {
+		switch {
+		case entry["operation"] == "OPTIMIZE" && detail.LastOptimize == "":
+			detail.LastOptimize = entry["timestamp"]
+		case strings.HasPrefix(entry["operation"], "VACUUM") && detail.LastVacuum == "":
+			detail.LastVacuum = entry["timestamp"]
+		}
+	}
+
+	if detail.SizeInBytes != nil && *detail.SizeInBytes > largeTableBytes {
+		if len(detail.PartitionColumns) == 0 && len(detail.ClusteringColumns) == 0 {
+			detail.Warnings = append(detail.Warnings, fmt.Sprintf("The table has %d GiB and is neither partitioned nor clustered, queries without selective filters scan the whole table.", *detail.SizeInBytes>>30))
+		} else {
+			columns := append(append([]string{}, detail.PartitionColumns...), detail.ClusteringColumns...)
+			detail.Warnings = append(detail.Warnings, fmt.Sprintf("The table has %d GiB, filter on %s to avoid scanning the whole table.", *detail.SizeInBytes>>30, strings.Join(columns, ", ")))
+		}
+	}
+	return detail, nil
+}
+
+// stringArray parses an array of strings returned as JSON.
+func stringArray(value string) []string {
+	values := make([]string, 0)
+	_ = json.Unmarshal([]byte(value), &values)
+	return values
+}
+
+func parseCount(value string) *int64 {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
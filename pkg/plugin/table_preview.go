@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tablePreviewPathRgx matches the resource path previewing the rows of a table.
+var tablePreviewPathRgx = regexp.MustCompile(`^tables/([^/]+)/([^/]+)/([^/]+)/preview$`)
+
+const (
+	defaultPreviewRows = 10
+	maxPreviewRows     = 100
+)
+
+// previewTable handles the resource request returning the first rows of a table as
+// frame, so tables can be inspected in the query editor without writing SQL. The number
+// of rows is set by the limit parameter, 10 by default and at most 100. The statement
+// has to be allowed by the read only mode and the statement filter.
+func (d *Datasource) previewTable(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, pools *requestPools, names []string) error {
+	table := make([]string, len(names))
+	for i, name := range names {
+		unescaped, err := url.PathUnescape(name)
+		if err != nil {
+			return sendError(sender, http.StatusBadRequest, err)
+		}
+		table[i] = quoteIdentifier(unescaped)
+	}
+	limit := defaultPreviewRows
+	if u, err := url.Parse(req.URL); err == nil && u.Query().Get("limit") != "" {
+		limit, err = strconv.Atoi(u.Query().Get("limit"))
+		if err != nil || limit < 1 || limit > maxPreviewRows {
+			return sendError(sender, http.StatusBadRequest, fmt.Errorf("invalid limit %q, expected a number between 1 and %d", u.Query().Get("limit"), maxPreviewRows))
+		}
+	}
+
+	statement := fmt.Sprintf("SELECT * FROM %s LIMIT %d", strings.Join(table, "."), limit)
+	err := d.checkStatements(statement)
+	if err != nil {
+		return sendError(sender, http.StatusForbidden, err)
+	}
+
+	db, err := pools.db("")
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	executor, err := d.executor(pools, "", db, d.conversion)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	logger.Info("CallResource called", "queryString", statement)
+	frame, _, err := d.queryFrame(ctx, db, executor, statement, int64(limit))
+	if err != nil {
+		return sendError(sender, http.StatusBadRequest, err)
+	}
+	truncateCells(frame, d.maxCellSize)
+	frame.Name = strings.Join(table, ".")
+
+	jsonBody, err := frame.MarshalJSON()
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"regexp"
+	"strings"
+)
+
+// unsafeTagValueRgx matches characters not allowed in tag values, which could otherwise
+// close the comment the tags are added in.
+var unsafeTagValueRgx = regexp.MustCompile(`[^a-zA-Z0-9_.@:-]`)
+
+// queryTagsPrefix starts the comment of the query tags.
+const queryTagsPrefix = "/* grafana: "
+
+// queryTags identify the dashboard, panel and user a statement was executed for. They
+// are added to the statements as comment, so the Databricks query history can be traced
+// back to Grafana.
+type queryTags struct {
+	DashboardUid string
+	PanelId      string
+	User         string
+}
+
+// newQueryTags reads the tags from the headers Grafana forwards with the request,
+// falling back to the values sent by the frontend with the query.
+func newQueryTags(pCtx backend.PluginContext, headers backend.ForwardHTTPHeaders, qm queryModel) queryTags {
+	tags := queryTags{
+		DashboardUid: headers.GetHTTPHeader("X-Dashboard-Uid"),
+		PanelId:      headers.GetHTTPHeader("X-Panel-Id"),
+	}
+	if tags.DashboardUid == "" {
+		tags.DashboardUid = qm.DashboardUid
+	}
+	if tags.PanelId == "" && qm.PanelId != 0 {
+		tags.PanelId = fmt.Sprint(qm.PanelId)
+	}
+	if pCtx.User != nil {
+		tags.User = pCtx.User.Login
+	}
+	return tags
+}
+
+// comment renders the tags as SQL comment, or returns an empty string if no tag is set.
+func (t queryTags) comment() string {
+	var values []string
+	add := func(name string, value string) {
+		if value != "" {
+			values = append(values, fmt.Sprintf("%s=%s", name, unsafeTagValueRgx.ReplaceAllString(value, "_")))
+		}
+	}
+	add("dashboard_uid", t.DashboardUid)
+	add("panel_id", t.PanelId)
+	add("user", t.User)
+	if len(values) == 0 {
+		return ""
+	}
+	return queryTagsPrefix + strings.Join(values, ", ") + " */"
+}
+
+// tag prefixes the statement with the comment of the tags.
+func (t queryTags) tag(statement string) string {
+	comment := t.comment()
+	if comment == "" {
+		return statement
+	}
+	return comment + "\n" + strings.TrimLeft(statement, " \t\r\n")
+}
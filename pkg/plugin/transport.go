@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/proxy"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type tlsSettings struct {
+	SkipVerify bool
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	ClientAuth bool
+	WithCACert bool
+	ServerName string
+}
+
+func (s tlsSettings) enabled() bool {
+	return s.SkipVerify || s.ClientAuth || s.WithCACert || s.ServerName != ""
+}
+
+// newTLSSettings collects the TLS related options of the datasource. Certificates are
+// stored in the secure json data using the same keys as the Grafana core datasources.
+func newTLSSettings(datasourceSettings *DatasourceSettings, secureJSONData map[string]string) tlsSettings {
+	settings := tlsSettings{
+		SkipVerify: datasourceSettings.TLSSkipVerify,
+		ClientAuth: datasourceSettings.TLSAuth,
+		WithCACert: datasourceSettings.TLSAuthWithCACert,
+		ServerName: datasourceSettings.ServerName,
+	}
+	if settings.WithCACert {
+		settings.CACert = secureJSONData["tlsCACert"]
+	}
+	if settings.ClientAuth {
+		settings.ClientCert = secureJSONData["tlsClientCert"]
+		settings.ClientKey = secureJSONData["tlsClientKey"]
+	}
+	return settings
+}
+
+// newProxyURL parses the HTTP(S) proxy configured on the datasource. It returns nil
+// if no proxy is configured, in which case the HTTP_PROXY/HTTPS_PROXY environment
+// variables are respected.
+func newProxyURL(datasourceSettings *DatasourceSettings, secureJSONData map[string]string) (*url.URL, error) {
+	if datasourceSettings.ProxyUrl == "" {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(datasourceSettings.ProxyUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the proxy URL: %w", err)
+	}
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported proxy URL scheme %q, expected http or https", proxyURL.Scheme)
+	}
+	if datasourceSettings.ProxyUsername != "" {
+		proxyURL.User = url.UserPassword(datasourceSettings.ProxyUsername, secureJSONData["proxyPassword"])
+	}
+	return proxyURL, nil
+}
+
+// newTLSConfig builds the tls.Config used to connect to the Databricks workspace.
+func newTLSConfig(settings tlsSettings) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: settings.SkipVerify,
+		ServerName:         settings.ServerName,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if settings.WithCACert {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM([]byte(settings.CACert)) {
+			return nil, fmt.Errorf("failed to parse the TLS CA certificate")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+
+	if settings.ClientAuth {
+		cert, err := tls.X509KeyPair([]byte(settings.ClientCert), []byte(settings.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// newTransport creates the http transport used by the databricks sql driver. It returns
+// nil if no custom transport options are configured, so the driver default is used.
+func newTransport(settings tlsSettings, proxyURL *url.URL, proxyOptions *proxy.Options) (http.RoundTripper, error) {
+	proxyClient := proxy.New()
+	secureSocksProxyEnabled := proxyClient.SecureSocksProxyEnabled(proxyOptions)
+	if !settings.enabled() && proxyURL == nil && !secureSocksProxyEnabled {
+		return nil, nil
+	}
+
+	tlsConfig, err := newTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyFunc := http.ProxyFromEnvironment
+	if proxyURL != nil {
+		logger.Info("Routing Databricks connection through the HTTP proxy", "proxyHost", proxyURL.Host)
+		proxyFunc = http.ProxyURL(proxyURL)
+	}
+
+	// Same defaults as the pooled transport of the databricks sql driver
+	transport := &http.Transport{
+		Proxy: proxyFunc,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       180 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConnsPerHost:   10,
+		MaxConnsPerHost:       100,
+	}
+
+	if secureSocksProxyEnabled {
+		logger.Info("Routing Databricks connection through the secure socks proxy")
+		err = proxyClient.ConfigureSecureSocksHTTPProxy(transport, proxyOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure the secure socks proxy: %w", err)
+		}
+	}
+	return transport, nil
+}
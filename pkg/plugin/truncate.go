@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"unicode/utf8"
+)
+
+// defaultMaxCellSize is the size in bytes string and JSON values are truncated to if
+// no maximum cell size is configured.
+const defaultMaxCellSize = 1 << 20
+
+// maxCellSize returns the maximum cell size for the configured value. Zero uses the
+// default, a negative value disables truncation.
+func maxCellSize(limit int) int {
+	if limit == 0 {
+		return defaultMaxCellSize
+	}
+	if limit < 0 {
+		return -1
+	}
+	return limit
+}
+
+// truncateCells truncates string and JSON values exceeding limit bytes and appends an
+// ellipsis, so large values don't exhaust the memory of the browser. Truncated JSON
+// values are replaced by a JSON string of the truncated text. A notice is added if
+// values were truncated.
+func truncateCells(frame *data.Frame, limit int) {
+	if limit < 0 {
+		return
+	}
+	truncated := 0
+	for _, field := range frame.Fields {
+		isJSON := field.Type() == data.FieldTypeJSON || field.Type() == data.FieldTypeNullableJSON
+		if !isStringField(field) && !isJSON {
+			continue
+		}
+		for i := 0; i < field.Len(); i++ {
+			v, ok := field.ConcreteAt(i)
+			if !ok {
+				continue
+			}
+			var s string
+			if isJSON {
+				s = string(v.(json.RawMessage))
+			} else {
+				s = v.(string)
+			}
+			if len(s) <= limit {
+				continue
+			}
+			truncated++
+			s = truncateString(s, limit) + "…"
+			if isJSON {
+				b, _ := json.Marshal(s)
+				field.SetConcrete(i, json.RawMessage(b))
+			} else {
+				field.SetConcrete(i, s)
+			}
+		}
+	}
+	if truncated > 0 {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("%d values exceeded the maximum cell size of %d bytes and were truncated.", truncated, limit),
+		})
+	}
+}
+
+// truncateString returns the longest prefix of s with at most limit bytes which doesn't
+// split a character.
+func truncateString(s string, limit int) string {
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit]
+}
@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// readOnlyKeywords are the leading keywords of the statements allowed when the
+// datasource is in read only mode.
+var readOnlyKeywords = map[string]bool{
+	"SELECT": true, "WITH": true, "VALUES": true, "SHOW": true, "DESCRIBE": true, "DESC": true,
+	"EXPLAIN": true, "USE": true, "SET": true,
+}
+
+// readOnlyForbiddenKeywords are the keywords of statements modifying data, schema objects
+// or permissions, rejected anywhere in a statement when the datasource is in read only
+// mode, i.e. following a common table expression.
+var readOnlyForbiddenKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "CREATE": true, "DROP": true,
+	"ALTER": true, "TRUNCATE": true, "COPY": true, "RESTORE": true, "VACUUM": true,
+	"OPTIMIZE": true, "GRANT": true, "REVOKE": true, "REFRESH": true, "EXECUTE": true,
+	"IMMEDIATE": true, "CALL": true, "MSCK": true, "REPAIR": true, "CACHE": true, "UNCACHE": true,
+	"LOAD": true, "ANALYZE": true,
+}
+
+var sqlCommentRgx = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+
+var sqlKeywordRgx = regexp.MustCompile(`^[a-zA-Z_]+`)
+
+// statementKeyword returns the leading keyword of a sql statement in upper case,
+// ignoring comments and whitespace.
+func statementKeyword(statement string) string {
+	statement = strings.TrimSpace(sqlCommentRgx.ReplaceAllString(statement, " "))
+	statement = strings.TrimLeft(statement, "( \t\r\n")
+	return strings.ToUpper(sqlKeywordRgx.FindString(statement))
+}
+
+// checkReadOnly returns an error if any of the statements in the query string would
+// modify data or schema objects.
+func checkReadOnly(queryString string) error {
+	for _, statement := range splitStatements(queryString) {
+		err := checkStatementReadOnly(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStatementReadOnly returns an error unless the statement starts with one of the
+// readOnlyKeywords and contains none of the readOnlyForbiddenKeywords. Keywords in string
+// literals, quoted identifiers and comments and parts of qualified names are ignored, so
+// columns named like a forbidden keyword have to be quoted with backticks.
+func checkStatementReadOnly(statement string) error {
+	var words []string
+	tokens := tokenizeSQL(statement)
+	for i, token := range tokens {
+		if token.kind == formatLineComment || token.kind == formatBlockComment {
+			continue
+		}
+		if token.kind != formatWord && token.kind != formatKeyword {
+			// statements have to start with a keyword, i.e. not with a quoted identifier
+			if len(words) == 0 && token.text != "(" {
+				return fmt.Errorf("statement is not allowed, the datasource is configured as read only")
+			}
+			continue
+		}
+		if (i > 0 && tokens[i-1].text == ".") || (i+1 < len(tokens) && tokens[i+1].text == ".") {
+			continue
+		}
+		words = append(words, strings.ToUpper(token.text))
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	if !readOnlyKeywords[words[0]] {
+		return fmt.Errorf("%s statements are not allowed, the datasource is configured as read only", words[0])
+	}
+	for i, word := range words {
+		// SHOW CREATE TABLE only returns the statement creating the table
+		if i == 1 && words[0] == "SHOW" && word == "CREATE" {
+			continue
+		}
+		if readOnlyForbiddenKeywords[word] {
+			return fmt.Errorf("%s statements are not allowed, the datasource is configured as read only", word)
+		}
+	}
+	return nil
+}
+
+// checkStatements returns an error if the query string isn't allowed by the read only
+// mode or the statement filter of the datasource. Statements generated by resource
+// requests are checked like the queries of panels.
+func (d *Datasource) checkStatements(queryString string) error {
+	if d.readOnly {
+		err := checkReadOnly(queryString)
+		if err != nil {
+			return err
+		}
+	}
+	return d.statementFilter.check(queryString)
+}
+
+// statementFilter holds the admin configured allow and deny lists. Patterns are
+// case-insensitive regular expressions matched against every statement of a query.
+type statementFilter struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+	// err is set if the configuration is invalid, in which case every query is
+	// rejected instead of silently ignoring the filter.
+	err error
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		rgx, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid statement filter pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, rgx)
+	}
+	return compiled, nil
+}
+
+func newStatementFilter(allowlist []string, denylist []string) *statementFilter {
+	allow, err := compilePatterns(allowlist)
+	if err != nil {
+		return &statementFilter{err: err}
+	}
+	deny, err := compilePatterns(denylist)
+	if err != nil {
+		return &statementFilter{err: err}
+	}
+	return &statementFilter{allow: allow, deny: deny}
+}
+
+// check returns an error if a statement matches the deny list or, if an allow list is
+// configured, doesn't match any of its patterns.
+func (f *statementFilter) check(queryString string) error {
+	if f == nil {
+		return nil
+	}
+	if f.err != nil {
+		return f.err
+	}
+	for _, statement := range splitStatements(queryString) {
+		statement = strings.TrimSpace(sqlCommentRgx.ReplaceAllString(statement, " "))
+		for _, rgx := range f.deny {
+			if rgx.MatchString(statement) {
+				return fmt.Errorf("statement is not allowed by the datasource configuration (matches deny pattern %q)", strings.TrimPrefix(rgx.String(), "(?i)"))
+			}
+		}
+		if len(f.allow) == 0 {
+			continue
+		}
+		allowed := false
+		for _, rgx := range f.allow {
+			if rgx.MatchString(statement) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("statement is not allowed by the datasource configuration (no allow pattern matches)")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import "testing"
+
+func TestCheckReadOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		allowed bool
+	}{
+		{"select", "SELECT * FROM t", true},
+		{"parenthesized select", "(SELECT 1) UNION (SELECT 2)", true},
+		{"cte", "WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"values", "VALUES (1), (2)", true},
+		{"show", "SHOW TABLES IN main.default", true},
+		{"show create table", "SHOW CREATE TABLE main.default.t", true},
+		{"describe", "DESCRIBE HISTORY t", true},
+		{"explain", "EXPLAIN SELECT 1", true},
+		{"use and set", "USE CATALOG main; SET spark.sql.ansi.enabled = true; SELECT 1", true},
+		{"leading comment", "-- INSERT\n/* DELETE */ SELECT 1", true},
+		{"keyword in string", "SELECT * FROM t WHERE action = 'DELETE'", true},
+		{"keyword as quoted identifier", "SELECT `update` FROM t", true},
+		{"keyword in qualified name", "SELECT t.update, copy.x FROM main.copy", true},
+		{"keyword as part of identifier", "SELECT created, updated_at FROM t", true},
+		{"empty statements", ";; -- nothing\n", true},
+
+		{"insert", "INSERT INTO t VALUES (1)", false},
+		{"update", "update t set a = 1", false},
+		{"delete", "DELETE FROM t", false},
+		{"merge", "MERGE INTO t USING s ON t.id = s.id WHEN MATCHED THEN DELETE", false},
+		{"create", "CREATE TABLE t (a INT)", false},
+		{"drop", "DROP TABLE t", false},
+		{"alter", "ALTER TABLE t SET TBLPROPERTIES ('a' = 'b')", false},
+		{"truncate", "TRUNCATE TABLE t", false},
+		{"copy into", "COPY INTO t FROM '/path' FILEFORMAT = CSV", false},
+		{"replace table", "REPLACE TABLE t AS SELECT 1", false},
+		{"restore", "RESTORE TABLE t TO VERSION AS OF 1", false},
+		{"vacuum", "VACUUM t", false},
+		{"optimize", "OPTIMIZE t ZORDER BY (a)", false},
+		{"grant", "GRANT SELECT ON TABLE t TO `users`", false},
+		{"revoke", "REVOKE SELECT ON TABLE t FROM `users`", false},
+		{"refresh", "REFRESH TABLE t", false},
+		{"execute immediate", "EXECUTE IMMEDIATE 'DELETE FROM t'", false},
+		{"from insert", "FROM s INSERT INTO t SELECT *", false},
+		{"cte insert", "WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x", false},
+		{"compound statement", "BEGIN SELECT 1; END", false},
+		{"set with subquery", "SET VAR v = (SELECT 1); DELETE FROM t", false},
+		{"second statement", "SELECT 1; DROP TABLE t", false},
+		{"commented keyword", "/* SELECT */ DELETE FROM t", false},
+		{"parenthesized delete", "(DELETE FROM t)", false},
+		{"quoted statement", "`t` SELECT 1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkReadOnly(tt.query)
+			if tt.allowed && err != nil {
+				t.Errorf("checkReadOnly(%q) = %v, want allowed", tt.query, err)
+			}
+			if !tt.allowed && err == nil {
+				t.Errorf("checkReadOnly(%q) allowed the query", tt.query)
+			}
+		})
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      bool
+	}{
+		{"SELECT 1", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"INSERT INTO t VALUES (1)", false},
+		{"TRUNCATE TABLE t", false},
+		{"COPY INTO t FROM '/path'", false},
+		{"WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x", false},
+		{"FROM s INSERT INTO t SELECT *", false},
+	}
+	for _, tt := range tests {
+		if got := isIdempotent(tt.statement); got != tt.want {
+			t.Errorf("isIdempotent(%q) = %v, want %v", tt.statement, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warehouseIdRgx extracts the warehouse id from the HTTP path of a SQL warehouse. The
+// HTTP path of all-purpose clusters doesn't match, those are not checked.
+var warehouseIdRgx = regexp.MustCompile(`/(?:warehouses|endpoints)/([0-9a-zA-Z]+)`)
+
+// Interval in which the state of a starting warehouse is polled.
+const warehousePollInterval = 5 * time.Second
+
+// Time a running warehouse is not checked again.
+const warehouseRunningCacheTTL = time.Minute
+
+const warehouseStateRunning = "RUNNING"
+
+// warehouseStartingError is returned if a query can't be executed because the SQL
+// warehouse isn't running yet.
+type warehouseStartingError struct {
+	warehouseId string
+	state       string
+}
+
+func (e *warehouseStartingError) Error() string {
+	return fmt.Sprintf("the SQL warehouse %s is %s, it is being started and queries will succeed once it is running. Please retry in a few minutes", e.warehouseId, strings.ToLower(e.state))
+}
+
+// warehouseMonitor checks the state of the SQL warehouse using the Databricks REST API
+// before queries are executed, so stopped warehouses produce a clear error instead of
+// hanging queries.
+type warehouseMonitor struct {
+	hostname    string
+	warehouseId string
+	// startTimeout is the time waited for a stopped warehouse to start, if zero an error
+	// is returned right away.
+	startTimeout time.Duration
+	httpClient   *http.Client
+
+	mu          sync.Mutex
+	lastRunning time.Time
+}
+
+// newWarehouseMonitor returns nil if the HTTP path is not the one of a SQL warehouse.
+func newWarehouseMonitor(connection connectionSettings, startTimeout time.Duration) *warehouseMonitor {
+	warehouseId := warehouseIdFromPath(connection.path)
+	if warehouseId == "" {
+		return nil
+	}
+	return &warehouseMonitor{
+		hostname:     strings.TrimPrefix(strings.TrimPrefix(connection.hostname, "https://"), "http://"),
+		warehouseId:  warehouseId,
+		startTimeout: startTimeout,
+		httpClient:   &http.Client{Transport: connection.transport, Timeout: 30 * time.Second},
+	}
+}
+
+// warehouseIdFromPath returns the warehouse id of the HTTP path, or an empty string if
+// it isn't the path of a SQL warehouse.
+func warehouseIdFromPath(path string) string {
+	match := warehouseIdRgx.FindStringSubmatch("/" + strings.TrimPrefix(path, "/"))
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// ensureRunning returns nil if the warehouse is running or was started within the start
+// timeout. Errors of the REST API are only logged, the query is executed anyway.
+func (m *warehouseMonitor) ensureRunning(ctx context.Context, authenticator auth.Authenticator) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	recentlyRunning := time.Since(m.lastRunning) < warehouseRunningCacheTTL
+	m.mu.Unlock()
+	if recentlyRunning {
+		return nil
+	}
+
+	state, err := m.state(ctx, authenticator)
+	if err != nil {
+		logger.Info("Warehouse State Error", "err", err)
+		return nil
+	}
+	if state != warehouseStateRunning {
+		logger.Info("Warehouse is not running", "warehouseId", m.warehouseId, "state", state)
+		err = m.start(ctx, authenticator)
+		if err != nil {
+			logger.Info("Warehouse Start Error", "err", err)
+		}
+		if m.startTimeout <= 0 {
+			return &warehouseStartingError{warehouseId: m.warehouseId, state: state}
+		}
+		err = m.waitUntilRunning(ctx, authenticator)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.lastRunning = time.Now()
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *warehouseMonitor) waitUntilRunning(ctx context.Context, authenticator auth.Authenticator) error {
+	ctx, cancel := context.WithTimeout(ctx, m.startTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(warehousePollInterval)
+	defer ticker.Stop()
+	state := "starting"
+	for {
+		select {
+		case <-ctx.Done():
+			return &warehouseStartingError{warehouseId: m.warehouseId, state: state}
+		case <-ticker.C:
+		}
+		current, err := m.state(ctx, authenticator)
+		if err != nil {
+			logger.Info("Warehouse State Error", "err", err)
+			continue
+		}
+		state = current
+		if state == warehouseStateRunning {
+			logger.Info("Warehouse is running", "warehouseId", m.warehouseId)
+			return nil
+		}
+	}
+}
+
+func (m *warehouseMonitor) state(ctx context.Context, authenticator auth.Authenticator) (string, error) {
+	var body struct {
+		State string `json:"state"`
+	}
+	err := m.do(ctx, authenticator, http.MethodGet, "", &body)
+	if err != nil {
+		return "", err
+	}
+	return body.State, nil
+}
+
+func (m *warehouseMonitor) start(ctx context.Context, authenticator auth.Authenticator) error {
+	return m.do(ctx, authenticator, http.MethodPost, "/start", nil)
+}
+
+func (m *warehouseMonitor) do(ctx context.Context, authenticator auth.Authenticator, method string, action string, result interface{}) error {
+	path := fmt.Sprintf("/api/2.0/sql/warehouses/%s%s", m.warehouseId, action)
+	return doAPIRequest(ctx, m.httpClient, m.hostname, authenticator, method, path, nil, result)
+}
+
+// warehouseInfo is a SQL warehouse of the workspace, as returned by the warehouses
+// resource.
+type warehouseInfo struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Size       string `json:"size"`
+	State      string `json:"state"`
+	Serverless bool   `json:"serverless"`
+	Path       string `json:"path"`
+	// Default is set for the warehouse of the HTTP path of the datasource settings.
+	Default bool `json:"default"`
+	// Configured is the name of the warehouse in the datasource settings, queries select
+	// it by this name.
+	Configured string `json:"configured,omitempty"`
+}
+
+// listWarehouses handles the resource request listing the SQL warehouses of the
+// workspace with their state, for the warehouse pickers of the editors.
+func (d *Datasource) listWarehouses(ctx context.Context, sender backend.CallResourceResponseSender, authenticator auth.Authenticator) error {
+	var body struct {
+		Warehouses []struct {
+			Id                      string `json:"id"`
+			Name                    string `json:"name"`
+			ClusterSize             string `json:"cluster_size"`
+			State                   string `json:"state"`
+			EnableServerlessCompute bool   `json:"enable_serverless_compute"`
+			OdbcParams              struct {
+				Path string `json:"path"`
+			} `json:"odbc_params"`
+		} `json:"warehouses"`
+	}
+	err := d.apiRequest(ctx, authenticator, http.MethodGet, "/api/2.0/sql/warehouses", nil, &body)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+
+	configured := make(map[string]string, len(d.connection.warehouses))
+	for name, path := range d.connection.warehouses {
+		configured[warehouseIdFromPath(path)] = name
+	}
+	defaultId := warehouseIdFromPath(d.connection.path)
+	warehouses := make([]warehouseInfo, 0, len(body.Warehouses))
+	for _, w := range body.Warehouses {
+		warehouses = append(warehouses, warehouseInfo{
+			Id:         w.Id,
+			Name:       w.Name,
+			Size:       w.ClusterSize,
+			State:      w.State,
+			Serverless: w.EnableServerlessCompute,
+			Path:       w.OdbcParams.Path,
+			Default:    w.Id == defaultId,
+			Configured: configured[w.Id],
+		})
+	}
+	sort.Slice(warehouses, func(i, j int) bool { return warehouses[i].Name < warehouses[j].Name })
+
+	jsonBody, err := json.Marshal(warehouses)
+	if err != nil {
+		logger.Error("CallResource Error", "err", err)
+		return err
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   jsonBody,
+	})
+}
@@ -0,0 +1,228 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"github.com/databricks/databricks-sql-go/auth"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// warehouseStreamInterval is the interval in which the utilization of a warehouse is
+	// sent.
+	warehouseStreamInterval = 10 * time.Second
+	// warehousePathPrefix is the prefix of the channel paths of warehouse utilization,
+	// followed by the id of the warehouse.
+	warehousePathPrefix = "warehouses/"
+	// maxWarehouseQueries is the number of running and queued queries of the query
+	// history which are counted.
+	maxWarehouseQueries = 1000
+)
+
+// queryTypeWarehouseUtilization is the type of queries returning the utilization of the
+// warehouse of the query instead of executing SQL.
+const queryTypeWarehouseUtilization = "warehouseUtilization"
+
+// warehouseUtilization is the state and load of a SQL warehouse at one point in time.
+type warehouseUtilization struct {
+	state          string
+	health         string
+	clusters       int64
+	minClusters    int64
+	maxClusters    int64
+	activeSessions int64
+	runningQueries int64
+	queuedQueries  int64
+}
+
+// configuredWarehouse returns the name queries select the warehouse with the id by, and
+// whether it is the warehouse of the HTTP path of the datasource or a configured
+// warehouse. Only those can be streamed.
+func (d *Datasource) configuredWarehouse(warehouseId string) (string, bool) {
+	if warehouseId == "" {
+		return "", false
+	}
+	if warehouseIdFromPath(d.connection.path) == warehouseId {
+		return "", true
+	}
+	for name, path := range d.connection.warehouses {
+		if warehouseIdFromPath(path) == warehouseId {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// warehouseUtilization looks up the state of the warehouse and counts its running and
+// queued queries in the query history.
+func (d *Datasource) warehouseUtilization(ctx context.Context, authenticator auth.Authenticator, warehouseId string) (*warehouseUtilization, error) {
+	var warehouse struct {
+		State             string `json:"state"`
+		NumClusters       int64  `json:"num_clusters"`
+		MinNumClusters    int64  `json:"min_num_clusters"`
+		MaxNumClusters    int64  `json:"max_num_clusters"`
+		NumActiveSessions int64  `json:"num_active_sessions"`
+		Health            struct {
+			Status string `json:"status"`
+		} `json:"health"`
+	}
+	err := d.apiRequest(ctx, authenticator, http.MethodGet, "/api/2.0/sql/warehouses/"+warehouseId, nil, &warehouse)
+	if err != nil {
+		return nil, err
+	}
+	utilization := &warehouseUtilization{
+		state:          warehouse.State,
+		health:         warehouse.Health.Status,
+		clusters:       warehouse.NumClusters,
+		minClusters:    warehouse.MinNumClusters,
+		maxClusters:    warehouse.MaxNumClusters,
+		activeSessions: warehouse.NumActiveSessions,
+	}
+
+	var history struct {
+		Res []struct {
+			Status string `json:"status"`
+		} `json:"res"`
+	}
+	err = d.apiRequest(ctx, authenticator, http.MethodGet, "/api/2.0/sql/history/queries", map[string]interface{}{
+		"filter_by": map[string]interface{}{
+			"warehouse_ids": []string{warehouseId},
+			"statuses":      []string{"QUEUED", "RUNNING"},
+		},
+		"max_results": maxWarehouseQueries,
+	}, &history)
+	if err != nil {
+		return nil, err
+	}
+	for _, query := range history.Res {
+		switch query.Status {
+		case "RUNNING":
+			utilization.runningQueries++
+		case "QUEUED":
+			utilization.queuedQueries++
+		}
+	}
+	return utilization, nil
+}
+
+// warehouseEvent describes how the warehouse changed since the previous utilization,
+// i.e. it was started or scaled, or returns an empty string.
+func warehouseEvent(previous *warehouseUtilization, current *warehouseUtilization) string {
+	if previous == nil {
+		return ""
+	}
+	var events []string
+	if previous.state != current.state {
+		events = append(events, fmt.Sprintf("state changed from %s to %s", strings.ToLower(previous.state), strings.ToLower(current.state)))
+	}
+	switch {
+	case current.clusters > previous.clusters:
+		events = append(events, fmt.Sprintf("scaled up from %d to %d clusters", previous.clusters, current.clusters))
+	case current.clusters < previous.clusters:
+		events = append(events, fmt.Sprintf("scaled down from %d to %d clusters", previous.clusters, current.clusters))
+	}
+	return strings.Join(events, ", ")
+}
+
+// utilizationFrame returns a frame with a single row of the utilization of the warehouse.
+func utilizationFrame(warehouseId string, utilization *warehouseUtilization, event string) *data.Frame {
+	return data.NewFrame("warehouse",
+		data.NewField("time", nil, []time.Time{time.Now()}),
+		data.NewField("warehouseId", nil, []string{warehouseId}),
+		data.NewField("state", nil, []string{utilization.state}),
+		data.NewField("health", nil, []string{utilization.health}),
+		data.NewField("clusters", nil, []int64{utilization.clusters}),
+		data.NewField("minClusters", nil, []int64{utilization.minClusters}),
+		data.NewField("maxClusters", nil, []int64{utilization.maxClusters}),
+		data.NewField("activeSessions", nil, []int64{utilization.activeSessions}),
+		data.NewField("runningQueries", nil, []int64{utilization.runningQueries}),
+		data.NewField("queuedQueries", nil, []int64{utilization.queuedQueries}),
+		data.NewField("event", nil, []string{event}),
+	)
+}
+
+// runWarehouseStream sends the utilization of the warehouse every warehouseStreamInterval
+// while the channel has subscribers. The REST API is called with the credentials
+// selected for the user who subscribed first, errors are logged and retried.
+func (d *Datasource) runWarehouseStream(ctx context.Context, pCtx backend.PluginContext, warehouseId string, sender *backend.StreamSender) error {
+	warehouse, ok := d.configuredWarehouse(warehouseId)
+	if !ok {
+		return fmt.Errorf("the warehouse %s is not configured in the datasource", warehouseId)
+	}
+	logger.Info("Warehouse Stream started", "warehouseId", warehouseId)
+	defer logger.Info("Warehouse Stream stopped", "warehouseId", warehouseId)
+
+	var previous *warehouseUtilization
+	ticker := time.NewTicker(warehouseStreamInterval)
+	defer ticker.Stop()
+	for {
+		if d.isDisposed() {
+			return nil
+		}
+		utilization, err := d.streamWarehouseUtilization(ctx, pCtx, warehouse, warehouseId)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.Info("Warehouse Stream Error", "warehouseId", warehouseId, "err", err)
+		} else {
+			err = sender.SendFrame(utilizationFrame(warehouseId, utilization, warehouseEvent(previous, utilization)), data.IncludeAll)
+			if err != nil {
+				return err
+			}
+			previous = utilization
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// warehouseUtilizationQuery returns the current utilization of the warehouse of the
+// query, with the channel streaming further updates to the panel.
+func (d *Datasource) warehouseUtilizationQuery(ctx context.Context, pools *requestPools, pCtx backend.PluginContext, qm queryModel) backend.DataResponse {
+	connection, err := d.connection.forWarehouse(qm.Warehouse)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	warehouseId := warehouseIdFromPath(connection.path)
+	if warehouseId == "" {
+		return backend.DataResponse{Error: fmt.Errorf("the warehouse utilization requires the HTTP Path of a SQL warehouse, got %q", connection.path)}
+	}
+	_, err = pools.db(qm.Warehouse)
+	if err != nil {
+		return backend.DataResponse{Error: err}
+	}
+	utilization, err := d.warehouseUtilization(ctx, pools.authenticator(qm.Warehouse), warehouseId)
+	if err != nil {
+		logger.Info("Warehouse Utilization Error", "err", err)
+		return backend.DataResponse{Error: err}
+	}
+	frame := utilizationFrame(warehouseId, utilization, "")
+	if pCtx.DataSourceInstanceSettings != nil && !d.oauthPassThru {
+		frame.SetMeta(&data.FrameMeta{Channel: fmt.Sprintf("ds/%s/%s%s", pCtx.DataSourceInstanceSettings.UID, warehousePathPrefix, warehouseId)})
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// streamWarehouseUtilization looks up the utilization of the warehouse using the pools
+// of the user. Streams don't carry the headers of a request, the OAuth token of the
+// user isn't available.
+func (d *Datasource) streamWarehouseUtilization(ctx context.Context, pCtx backend.PluginContext, warehouse string, warehouseId string) (*warehouseUtilization, error) {
+	ctx, cancel := d.withDrainDeadline(ctx)
+	defer cancel()
+	pools := d.poolsForRequest(pCtx, nil)
+	defer pools.release()
+	_, err := pools.db(warehouse)
+	if err != nil {
+		return nil, err
+	}
+	return d.warehouseUtilization(ctx, pools.authenticator(warehouse), warehouseId)
+}
@@ -0,0 +1,150 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// errNoTimeColumn is returned by longToWide for frames without time column, which are
+// expected for table queries.
+var errNoTimeColumn = errors.New("no time column found")
+
+// wideSeries are the fields of a label set of a long frame.
+type wideSeries struct {
+	labels data.Labels
+	fields []*data.Field
+}
+
+// longToWide converts a long frame to a wide one. Every distinct combination of the
+// values of the string and boolean columns becomes a label set, and the other columns
+// are split into one field per label set. Unlike data.LongToWide the rows don't have to
+// be ordered by time, rows without time are skipped. If multiple rows have the same time
+// and labels, the last one is used and a notice is added.
+func longToWide(frame *data.Frame, settings querySettings) (*data.Frame, error) {
+	schema := frame.TimeSeriesSchema()
+	switch {
+	case len(frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)) == 0:
+		return nil, errNoTimeColumn
+	case schema.Type == data.TimeSeriesTypeNot:
+		return nil, fmt.Errorf("no value column found besides the time and string columns")
+	case schema.Type == data.TimeSeriesTypeWide:
+		return nil, fmt.Errorf("no string column found to use as labels")
+	}
+	timeField := frame.Fields[schema.TimeIndex]
+	rows, err := frame.RowLen()
+	if err != nil {
+		return nil, err
+	}
+
+	var times []time.Time
+	timeRows := make(map[int64]int)
+	for row := 0; row < rows; row++ {
+		if v, ok := timeField.ConcreteAt(row); ok {
+			t := v.(time.Time)
+			if _, ok := timeRows[t.UnixNano()]; !ok {
+				timeRows[t.UnixNano()] = 0
+				times = append(times, t)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for i, t := range times {
+		timeRows[t.UnixNano()] = i
+	}
+
+	var series []*wideSeries
+	seriesByLabels := make(map[string]*wideSeries)
+	// set marks the times of the series with a row, to detect duplicates
+	set := make(map[*wideSeries][]bool)
+	duplicates := 0
+	for row := 0; row < rows; row++ {
+		v, ok := timeField.ConcreteAt(row)
+		if !ok {
+			continue
+		}
+		timeRow := timeRows[v.(time.Time).UnixNano()]
+
+		labels := make(data.Labels, len(schema.FactorIndices))
+		for _, i := range schema.FactorIndices {
+			factor := frame.Fields[i]
+			labels[factor.Name] = ""
+			if v, ok := factor.ConcreteAt(row); ok {
+				labels[factor.Name] = fmt.Sprint(v)
+			}
+		}
+		s, ok := seriesByLabels[labels.String()]
+		if !ok {
+			s = &wideSeries{labels: labels}
+			for _, i := range schema.ValueIndices {
+				value := frame.Fields[i]
+				field := data.NewFieldFromFieldType(value.Type().NullableType(), len(times))
+				field.Name = value.Name
+				field.Labels = labels
+				field.Config = value.Config
+				s.fields = append(s.fields, field)
+			}
+			seriesByLabels[labels.String()] = s
+			series = append(series, s)
+			set[s] = make([]bool, len(times))
+		}
+		if set[s][timeRow] {
+			duplicates++
+		}
+		set[s][timeRow] = true
+
+		for j, i := range schema.ValueIndices {
+			if v, ok := frame.Fields[i].ConcreteAt(row); ok {
+				s.fields[j].SetConcrete(timeRow, v)
+			}
+		}
+	}
+
+	wideTime := data.NewField(timeField.Name, nil, times)
+	wideTime.Config = timeField.Config
+	wide := data.NewFrame(frame.Name, wideTime)
+	for _, s := range series {
+		for _, field := range s.fields {
+			fillMissing(field, settings)
+			wide.Fields = append(wide.Fields, field)
+		}
+	}
+	wide.Meta = frame.Meta
+	if duplicates > 0 {
+		wide.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("%d rows have the same time and labels as a previous row, only the last value is shown. Add the distinguishing columns or aggregate the rows.", duplicates),
+		})
+	}
+	return wide, nil
+}
+
+// fillMissing fills the null values of a field as configured by the fill mode. The fill
+// value is only used for numeric fields.
+func fillMissing(field *data.Field, settings querySettings) {
+	switch settings.FillMode {
+	case data.FillModePrevious:
+		var previous interface{}
+		for i := 0; i < field.Len(); i++ {
+			if v, ok := field.ConcreteAt(i); ok {
+				previous = v
+			} else if previous != nil {
+				field.SetConcrete(i, previous)
+			}
+		}
+	case data.FillModeValue:
+		if !field.Type().Numeric() {
+			return
+		}
+		zero := data.NewFieldFromFieldType(field.Type().NonNullableType(), 1).At(0)
+		value := reflect.ValueOf(settings.FillValue).Convert(reflect.TypeOf(zero)).Interface()
+		for i := 0; i < field.Len(); i++ {
+			if _, ok := field.ConcreteAt(i); !ok {
+				field.SetConcrete(i, value)
+			}
+		}
+	}
+}
@@ -3,7 +3,9 @@ import {defaults} from 'lodash';
 import React, {FormEvent, useEffect, useState} from 'react';
 import {
     ActionMeta,
+    Alert,
     AutoSizeInput,
+    Button,
     CodeEditor,
     Collapse,
     InlineField,
@@ -11,12 +13,13 @@ import {
     InlineSwitch, Monaco,
     Select,
 } from '@grafana/ui';
-import {QueryEditorProps, SelectableValue} from '@grafana/data';
+import {DataFrame, LoadingState, QueryEditorProps, SelectableValue} from '@grafana/data';
 
 import { editor } from 'monaco-editor/esm/vs/editor/editor.api';
 
 import {DataSource} from '../../datasource';
-import {defaultQuery, MyDataSourceOptions, MyQuery} from '../../types';
+import {queryTable} from '../Suggestions/utils';
+import {defaultQuery, HistoryQuery, LintWarning, MyDataSourceOptions, MyQuery, PreviewResult, QueryProgress, SavedQueryInfo, ValidationResult, WarehouseInfo} from '../../types';
 
 type Props = QueryEditorProps<DataSource, MyQuery, MyDataSourceOptions>;
 
@@ -28,6 +31,44 @@ export function QueryEditor(props: Props) {
         { label: 'Value', value: 2, description: 'fills with a specific value' },
     ];
 
+    const formatOptions: Array<SelectableValue<string>> = [
+        { label: 'Default', value: '', description: 'returns the result as is, converting it to wide if enabled.' },
+        { label: 'Table', value: 'table' },
+        { label: 'Time Series', value: 'time_series', description: 'returns a wide time series, requires a time column.' },
+        { label: 'Logs', value: 'logs', description: 'returns log lines, requires a time and a string column.' },
+    ];
+
+    const aggregationOptions: Array<SelectableValue<string>> = [
+        { label: 'Average', value: 'avg' },
+        { label: 'Sum', value: 'sum' },
+        { label: 'Min', value: 'min' },
+        { label: 'Max', value: 'max' },
+        { label: 'Count', value: 'count' },
+    ];
+
+    const intervalFormatOptions: Array<SelectableValue<string>> = [
+        { label: 'Seconds', value: 'seconds', description: 'converts intervals to seconds, so they can be graphed.' },
+        { label: 'ISO-8601', value: 'iso', description: 'converts intervals to ISO-8601 durations, i.e. P1DT2H.' },
+    ];
+
+    const queryTypeOptions: Array<SelectableValue<string>> = [
+        { label: 'SQL', value: '', description: 'executes the SQL query.' },
+        { label: 'Warehouse Utilization', value: 'warehouseUtilization', description: 'streams the state, clusters and running and queued queries of the warehouse of the query.' },
+    ];
+
+    const liveTriggerOptions: Array<SelectableValue<string>> = [
+        { label: 'Interval', value: 'interval', description: 'executes the query every interval.' },
+        { label: 'Table Version', value: 'version', description: 'executes the query once the version of the queried Delta table changed, checked every interval.' },
+        { label: 'Change Data Feed', value: 'changes', description: 'appends the rows inserted or updated since the last version, read from the change data feed of the queried Delta table.' },
+        { label: 'Tail', value: 'tail', description: 'appends the rows newer than the latest time delivered, for append-only event tables filtered with $__timeFilter.' },
+    ];
+
+    const monthOptions: Array<SelectableValue<number>> = [
+        { label: 'Datasource default', value: 0 },
+        ...['January', 'February', 'March', 'April', 'May', 'June', 'July', 'August', 'September', 'October', 'November', 'December']
+            .map((month, i) => ({ label: month, value: i + 1 })),
+    ];
+
     const [cursorPosition, setCursorPosition] = useState({lineNumber: 0, column: 0});
     const { datasource  } = props;
 
@@ -39,9 +80,89 @@ export function QueryEditor(props: Props) {
 
     const [queryValue, setQueryValue] = useState(rawSqlQuery || "");
 
+    const [tableWarnings, setTableWarnings] = useState<string[]>([]);
+
+    // checkTable warns about queries of large tables without WHERE clause
+    const checkTable = (value: string) => {
+        const table = queryTable(value);
+        if (table.length === 0 || /\bWHERE\b/i.test(value)) {
+            setTableWarnings([]);
+            return;
+        }
+        datasource.tableDetail(table)
+            .then((detail) => setTableWarnings(detail.warnings))
+            .catch(() => setTableWarnings([]));
+    };
+
+    const [lintWarnings, setLintWarnings] = useState<LintWarning[]>([]);
+
     const onSQLQueryChange = (value: string) => {
         const { onChange, query } = props;
         onChange({ ...query, rawSqlQuery: value });
+        checkTable(value);
+        datasource.lintQuery({ ...query, rawSqlQuery: value })
+            .then(setLintWarnings)
+            .catch(() => setLintWarnings([]));
+    };
+
+    const [validation, setValidation] = useState<ValidationResult | undefined>(undefined);
+    const [isValidating, setIsValidating] = useState(false);
+
+    const onValidate = () => {
+        setIsValidating(true);
+        datasource.validateQuery({ ...props.query, rawSqlQuery: queryValue, dashboardUid: props.data?.request?.dashboardUID }, props.range?.from.valueOf(), props.range?.to.valueOf(), props.data?.request?.timezone)
+            .then(setValidation)
+            .catch((error) => setValidation({ valid: false, error: error.data?.message || String(error), statements: [] }))
+            .finally(() => setIsValidating(false));
+    };
+
+    const [preview, setPreview] = useState<PreviewResult | undefined>(undefined);
+
+    const onPreview = () => {
+        datasource.previewQuery({ ...props.query, rawSqlQuery: queryValue, dashboardUid: props.data?.request?.dashboardUID }, props.range?.from.valueOf(), props.range?.to.valueOf(), props.data?.request?.intervalMs, props.data?.request?.timezone)
+            .then(setPreview)
+            .catch((error) => setPreview({ sql: '', error: error.data?.message || String(error) }));
+    };
+
+    const onFormat = () => {
+        const { onChange, query } = props;
+        datasource.formatQuery(queryValue)
+            .then((sql) => {
+                setQueryValue(sql);
+                onChange({ ...query, rawSqlQuery: sql });
+            })
+            .catch((error) => console.log(error));
+    };
+
+    const [tablePreview, setTablePreview] = useState<{frame?: DataFrame, error?: string} | undefined>(undefined);
+
+    // onPreviewTable shows the first rows of the first table the query selects from
+    const onPreviewTable = () => {
+        const parts = queryTable(queryValue);
+        if (parts.length !== 3) {
+            setTablePreview({ error: 'Select from a table qualified with catalog and schema, i.e. FROM catalog.schema.table, to preview it.' });
+            return;
+        }
+        datasource.previewTable(parts[0], parts[1], parts[2])
+            .then((frame) => setTablePreview({ frame }))
+            .catch((error) => setTablePreview({ error: error.data?.error || error.data?.message || String(error) }));
+    };
+
+    const [history, setHistory] = useState<{queries: HistoryQuery[], error?: string} | undefined>(undefined);
+
+    const onHistory = () => {
+        datasource.queryHistory(props.query.warehouse, props.data?.request?.dashboardUID)
+            .then((queries) => setHistory({ queries }))
+            .catch((error) => setHistory({ queries: [], error: error.data?.error || error.data?.message || String(error) }));
+    };
+
+    // onUseHistoryQuery loads a statement of the history into the editor, without the comment of the query tags
+    const onUseHistoryQuery = (statement: string) => {
+        const { onChange, query } = props;
+        const rawSqlQuery = statement.replace(/^\/\* grafana: [^*]*\*\/\n/, '');
+        setQueryValue(rawSqlQuery);
+        onChange({ ...query, rawSqlQuery });
+        setHistory(undefined);
     };
 
     const onQueryValueChange = (value: string) => {
@@ -62,6 +183,67 @@ export function QueryEditor(props: Props) {
     };
 
 
+    const onLimitToMaxDataPointsChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, limitToMaxDataPoints: event.currentTarget.checked} });
+    };
+
+    const onDownsampleChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, downsample: event.currentTarget.checked} });
+    };
+
+    const onDownsampleAggregationChange = (value: SelectableValue<string>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, downsampleAggregation: value.value} });
+    };
+
+    const onIntervalFormatChange = (value: SelectableValue<string>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, intervalFormat: value.value} });
+    };
+
+    const onBigintAsStringChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, bigintAsString: event.currentTarget.checked} });
+    };
+
+    const onFlattenStructsChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, flattenStructs: event.currentTarget.checked} });
+    };
+
+    const onColumnMetadataChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, columnMetadata: event.currentTarget.checked} });
+    };
+
+    const onColumnTypesChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        const columnTypes: Record<string, string> = {};
+        event.currentTarget.value.split(',').forEach((override) => {
+            const [column, type] = override.split(':').map((s) => s.trim());
+            if (column && type) {
+                columnTypes[column] = type;
+            }
+        });
+        onChange({ ...query, querySettings: { ...querySettings, columnTypes: columnTypes} });
+    };
+
+    const onResampleChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, resample: event.currentTarget.checked} });
+    };
+
     const onFillValueChange = (event: FormEvent<HTMLInputElement>) => {
         const { onChange, query } = props;
         const { querySettings } = query
@@ -74,6 +256,127 @@ export function QueryEditor(props: Props) {
         onChange({ ...query, querySettings: { ...querySettings, fillMode: value.value} });
     };
 
+    const onTimeoutChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const timeout = Number(event.currentTarget.value);
+        onChange({ ...query, timeout: timeout > 0 ? timeout : undefined });
+    };
+
+    const onQueryTypeChange = (value: SelectableValue<string>) => {
+        const { onChange, query } = props;
+        onChange({ ...query, queryType: value.value || undefined });
+    };
+
+    const onFormatChange = (value: SelectableValue<string>) => {
+        const { onChange, query } = props;
+        onChange({ ...query, format: value.value || undefined });
+    };
+
+    const onFiscalYearStartMonthChange = (value: SelectableValue<number>) => {
+        const { onChange, query } = props;
+        onChange({ ...query, fiscalYearStartMonth: value.value || undefined });
+    };
+
+    const onAsyncChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        onChange({ ...query, async: event.currentTarget.checked || undefined });
+    };
+
+    const onSchemaOnlyChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        onChange({ ...query, schemaOnly: event.currentTarget.checked || undefined });
+    };
+
+    const onRoundTimeRangeChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, roundTimeRange: event.currentTarget.value.trim() || undefined} });
+    };
+
+    const onLiveChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, live: event.currentTarget.checked} });
+    };
+
+    const onLiveIntervalChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        const liveInterval = Number(event.currentTarget.value);
+        onChange({ ...query, querySettings: { ...querySettings, liveInterval: liveInterval > 0 ? liveInterval : undefined} });
+    };
+
+    const onLiveTriggerChange = (value: SelectableValue<string>) => {
+        const { onChange, query } = props;
+        const { querySettings } = query
+        onChange({ ...query, querySettings: { ...querySettings, liveTrigger: value.value} });
+    };
+
+    const onMaxRowsChange = (event: FormEvent<HTMLInputElement>) => {
+        const { onChange, query } = props;
+        const maxRows = Number(event.currentTarget.value);
+        onChange({ ...query, maxRows: maxRows > 0 ? maxRows : undefined });
+    };
+
+    const onWarehouseChange = (value: SelectableValue<string>) => {
+        const { onChange, query } = props;
+        onChange({ ...query, warehouse: value.value || undefined });
+    };
+
+    const [savedQueries, setSavedQueries] = useState<SavedQueryInfo[]>([]);
+
+    // The saved queries are only listed once the advanced options are opened
+    const onSavedQueriesOpen = () => {
+        datasource.listSavedQueries().then(setSavedQueries).catch(() => setSavedQueries([]));
+    };
+
+    const onSavedQueryChange = (value: SelectableValue<string> | null) => {
+        const { onChange, query } = props;
+        onChange({ ...query, savedQueryId: value?.value || undefined });
+    };
+
+    const savedQueryOptions: Array<SelectableValue<string>> = savedQueries.map((q) => ({
+        label: q.name,
+        value: q.id,
+        description: q.description || q.owner,
+    }));
+    if (props.query.savedQueryId && !savedQueries.some((q) => q.id === props.query.savedQueryId)) {
+        savedQueryOptions.push({ label: props.query.savedQueryId, value: props.query.savedQueryId });
+    }
+
+    // follow the progress of the query while the panel is loading
+    const [progress, setProgress] = useState<QueryProgress | undefined>(undefined);
+    const runningRequestId = props.data?.state === LoadingState.Loading ? props.data.request?.requestId : undefined;
+    useEffect(() => {
+        setProgress(undefined);
+        if (!runningRequestId) {
+            return;
+        }
+        const subscription = datasource.queryProgress(datasource.queryId(runningRequestId, props.query.refId)).subscribe(setProgress);
+        return () => subscription.unsubscribe();
+    }, [datasource, runningRequestId, props.query.refId]);
+
+    const [warehouseInfos, setWarehouseInfos] = useState<WarehouseInfo[]>([]);
+    useEffect(() => {
+        if (datasource.warehouses.length > 0) {
+            datasource.listWarehouses().then(setWarehouseInfos).catch(() => setWarehouseInfos([]));
+        }
+    }, [datasource]);
+
+    // warehouseDescription shows the state of the warehouse, if it could be looked up
+    const warehouseDescription = (info?: WarehouseInfo) => {
+        return info ? `${info.name}: ${info.state.toLowerCase()}, ${info.size}${info.serverless ? ', serverless' : ''}` : undefined;
+    };
+
+    const warehouseOptions: Array<SelectableValue<string>> = [
+        { label: 'Default', value: '', description: warehouseDescription(warehouseInfos.find((info) => info.default)) },
+        ...datasource.warehouses.map((name) => ({
+            label: name,
+            value: name,
+            description: warehouseDescription(warehouseInfos.find((info) => info.configured === name)),
+        })),
+    ];
+
     const getSuggestions = () => {
         return datasource.suggestionProvider.getSuggestions();
     }
@@ -102,8 +405,125 @@ export function QueryEditor(props: Props) {
                   onEditorDidMount={editorDidMount}
                   />
               </div>
+              <div>
+                  <Button size="sm" variant="secondary" icon={isValidating ? 'fa fa-spinner' : 'check'} disabled={isValidating} onClick={onValidate}>
+                      Validate
+                  </Button>
+                  <Button size="sm" variant="secondary" icon="eye" onClick={onPreview} style={{ marginLeft: "8px" }}>
+                      Preview SQL
+                  </Button>
+                  <Button size="sm" variant="secondary" icon="brackets-curly" onClick={onFormat} style={{ marginLeft: "8px" }}>
+                      Format
+                  </Button>
+                  <Button size="sm" variant="secondary" icon="table" onClick={onPreviewTable} style={{ marginLeft: "8px" }}>
+                      Preview Table
+                  </Button>
+                  <Button size="sm" variant="secondary" icon="history" onClick={onHistory} style={{ marginLeft: "8px" }}>
+                      History
+                  </Button>
+              </div>
+              {history && (
+                  <Alert title={history.error ? 'Query history could not be loaded' : 'Recent queries'} severity={history.error ? 'error' : 'info'} onRemove={() => setHistory(undefined)}>
+                      {history.error && <pre>{history.error}</pre>}
+                      {!history.error && history.queries.length === 0 && <span>No recent queries found.</span>}
+                      {history.queries.map((q) => (
+                          <div key={q.queryId} style={{ marginBottom: "8px" }}>
+                              <div>
+                                  {new Date(q.startTimeMs).toLocaleString()} · {q.user} · {q.status} · {q.durationMs} ms{q.bytesScanned !== undefined ? ` · ${q.bytesScanned} bytes` : ''}
+                                  <Button size="sm" variant="secondary" fill="text" icon="arrow-up" onClick={() => onUseHistoryQuery(q.statement)}>
+                                      Use
+                                  </Button>
+                              </div>
+                              <pre>{q.error || q.statement}</pre>
+                          </div>
+                      ))}
+                  </Alert>
+              )}
+              {progress && progress.state !== 'finished' && (
+                  <Alert title={progress.state === 'queued' ? 'Query queued' : 'Query running'} severity="info">
+                      {Math.round(progress.elapsed / 1000)} s{progress.statements > 1 ? ` · statement ${progress.statement} of ${progress.statements}` : ''}{progress.status !== progress.state ? ` · ${progress.status}` : ''}
+                      {progress.bytesRead != null ? ` · ${progress.bytesRead} bytes` : ''}{progress.rowsRead != null ? ` · ${progress.rowsRead} rows` : ''}{progress.filesRead != null ? ` · ${progress.filesRead} files read` : ''}
+                  </Alert>
+              )}
+              {lintWarnings.length > 0 && (
+                  <Alert title="Query warnings" severity="info" onRemove={() => setLintWarnings([])}>
+                      {lintWarnings.map((warning, i) => <div key={i}>{warning.message}</div>)}
+                  </Alert>
+              )}
+              {tableWarnings.length > 0 && (
+                  <Alert title="The query has no WHERE clause" severity="warning" onRemove={() => setTableWarnings([])}>
+                      {tableWarnings.map((warning, i) => <div key={i}>{warning}</div>)}
+                  </Alert>
+              )}
+              {tablePreview && (
+                  <Alert title={tablePreview.error ? 'Table could not be previewed' : `Preview of ${tablePreview.frame?.name}`} severity={tablePreview.error ? 'error' : 'info'} onRemove={() => setTablePreview(undefined)}>
+                      {tablePreview.error && <pre>{tablePreview.error}</pre>}
+                      {tablePreview.frame && (
+                          <div style={{ overflowX: "auto" }}>
+                              <table className="filter-table">
+                                  <thead>
+                                      <tr>{tablePreview.frame.fields.map((field) => <th key={field.name}>{field.name}</th>)}</tr>
+                                  </thead>
+                                  <tbody>
+                                      {Array.from({ length: tablePreview.frame.length }, (_, row) => (
+                                          <tr key={row}>
+                                              {tablePreview.frame!.fields.map((field) => <td key={field.name}>{String(field.values.get(row) ?? '')}</td>)}
+                                          </tr>
+                                      ))}
+                                  </tbody>
+                              </table>
+                          </div>
+                      )}
+                  </Alert>
+              )}
+              {preview && (
+                  <Alert title={preview.error ? 'Macros could not be expanded' : 'Executed SQL'} severity={preview.error ? 'error' : 'info'} onRemove={() => setPreview(undefined)}>
+                      <pre>{preview.error || preview.sql}</pre>
+                  </Alert>
+              )}
+              {validation && (
+                  <Alert title={validation.valid ? 'Query is valid' : 'Query is invalid'} severity={validation.valid ? 'success' : 'error'} onRemove={() => setValidation(undefined)}>
+                      {validation.error && <pre>{validation.error}</pre>}
+                      {validation.statements.map((statement, i) => (
+                          <pre key={i}>{statement.error || statement.plan}</pre>
+                      ))}
+                  </Alert>
+              )}
               <Collapse label="Advanced Options" isOpen={isAdvancedOpen} onToggle={() => setIsAdvancedOpen(!isAdvancedOpen)} >
                   <div className="gf-form" style={{ flexDirection: "column", rowGap: "8px"}}>
+                      <InlineFieldRow>
+                          <InlineField label="Query Type" labelWidth={32} tooltip="Warehouse Utilization ignores the SQL and streams the utilization of the selected warehouse every 10 seconds.">
+                              <Select
+                                  width={32}
+                                  options={queryTypeOptions}
+                                  value={query.queryType || ''}
+                                  onChange={onQueryTypeChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Format" labelWidth={32} tooltip="Shape of the returned data.">
+                              <Select
+                                  width={32}
+                                  options={formatOptions}
+                                  value={query.format || ''}
+                                  onChange={onFormatChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Saved Query" labelWidth={32} tooltip="Execute a Databricks SQL saved query instead of the SQL of the editor. Parameters of the saved query like {{ name }} are bound like :name.">
+                              <Select
+                                  width={32}
+                                  options={savedQueryOptions}
+                                  value={query.savedQueryId || null}
+                                  onChange={onSavedQueryChange}
+                                  onOpenMenu={onSavedQueriesOpen}
+                                  isClearable
+                                  placeholder="None"
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
                       <InlineFieldRow>
                           <InlineField label="Convert Long To Wide" labelWidth={32}>
                               <InlineSwitch
@@ -113,7 +533,15 @@ export function QueryEditor(props: Props) {
                           </InlineField>
                       </InlineFieldRow>
                       <InlineFieldRow>
-                          <InlineField disabled={!querySettings.convertLongToWide} label="Fill Mode" labelWidth={32} tooltip="Fill Mode denotes how missing values should be filled.">
+                          <InlineField label="Resample" labelWidth={32} tooltip="Resample time series to the interval of the panel, so every series has a value per interval and gaps are filled as configured by Fill Mode, also without Long To Wide conversion.">
+                              <InlineSwitch
+                                  value={querySettings.resample || false}
+                                  onChange={onResampleChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField disabled={!querySettings.convertLongToWide && !querySettings.resample} label="Fill Mode" labelWidth={32} tooltip="Fill Mode denotes how missing values should be filled.">
                               <Select
                                   width={32}
                                   options={options}
@@ -122,7 +550,7 @@ export function QueryEditor(props: Props) {
                               />
                           </InlineField>
                           {querySettings.fillMode === 2 && (
-                              <InlineField disabled={!querySettings.convertLongToWide} label="Fill Value" labelWidth={16}>
+                              <InlineField disabled={!querySettings.convertLongToWide && !querySettings.resample} label="Fill Value" labelWidth={16}>
                                   <AutoSizeInput
                                       value={querySettings.fillValue || ''}
                                       defaultValue={querySettings.fillValue || ''}
@@ -133,6 +561,180 @@ export function QueryEditor(props: Props) {
                               </InlineField>
                           )}
                       </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Limit To Max Data Points" labelWidth={32} tooltip="Append a LIMIT of the max data points of the panel to queries without LIMIT and coarsen the interval of $__timeWindow, so no more data points are fetched than the panel can show.">
+                              <InlineSwitch
+                                  value={querySettings.limitToMaxDataPoints || false}
+                                  onChange={onLimitToMaxDataPointsChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Downsample" labelWidth={32} tooltip="Aggregate the rows into windows of the dashboard interval. The first time column is truncated to the window, numeric columns are aggregated and all other columns are grouped by.">
+                              <InlineSwitch
+                                  value={querySettings.downsample || false}
+                                  onChange={onDownsampleChange}
+                              />
+                          </InlineField>
+                          {querySettings.downsample && (
+                              <InlineField label="Aggregation" labelWidth={16}>
+                                  <Select
+                                      width={16}
+                                      options={aggregationOptions}
+                                      value={querySettings.downsampleAggregation || 'avg'}
+                                      onChange={onDownsampleAggregationChange}
+                                  />
+                              </InlineField>
+                          )}
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Interval Format" labelWidth={32} tooltip="Format INTERVAL columns are converted to.">
+                              <Select
+                                  width={32}
+                                  options={intervalFormatOptions}
+                                  value={querySettings.intervalFormat || 'seconds'}
+                                  onChange={onIntervalFormatChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="BIGINT As String" labelWidth={32} tooltip="Return BIGINT columns as strings, so identifiers larger than 2^53 aren't rounded by the browser.">
+                              <InlineSwitch
+                                  value={querySettings.bigintAsString || false}
+                                  onChange={onBigintAsStringChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Flatten Structs" labelWidth={32} tooltip="Replace STRUCT columns by a column per nested field, named in dot notation like device.id.">
+                              <InlineSwitch
+                                  value={querySettings.flattenStructs || false}
+                                  onChange={onFlattenStructsChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Column Metadata" labelWidth={32} tooltip="Use the comments of the columns of the queried table as field descriptions and their unit tags as field units. Requires an additional statement per query.">
+                              <InlineSwitch
+                                  value={querySettings.columnMetadata || false}
+                                  onChange={onColumnMetadataChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Column Types" labelWidth={32} tooltip="Override the types of columns as comma separated list of column:type, where type is time, number, string or boolean. Numbers are converted to time as epoch in seconds or milliseconds.">
+                              <AutoSizeInput
+                                  value={Object.entries(querySettings.columnTypes || {}).map(([column, type]) => `${column}:${type}`).join(', ')}
+                                  defaultValue={Object.entries(querySettings.columnTypes || {}).map(([column, type]) => `${column}:${type}`).join(', ')}
+                                  onCommitChange={onColumnTypesChange}
+                                  minWidth={32}
+                                  placeholder="epoch:time, id:string"
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      {datasource.warehouses.length > 0 && (
+                          <InlineFieldRow>
+                              <InlineField label="Warehouse" labelWidth={32} tooltip="SQL warehouse the query is executed on.">
+                                  <Select
+                                      width={32}
+                                      options={warehouseOptions}
+                                      value={query.warehouse || ''}
+                                      onChange={onWarehouseChange}
+                                  />
+                              </InlineField>
+                          </InlineFieldRow>
+                      )}
+                      <InlineFieldRow>
+                          <InlineField label="Timeout" labelWidth={32} tooltip="Timeout of the query in seconds, overrides the timeout of the datasource.">
+                              <AutoSizeInput
+                                  type="number"
+                                  value={query.timeout || ''}
+                                  defaultValue={query.timeout || ''}
+                                  onCommitChange={onTimeoutChange}
+                                  minWidth={32}
+                                  placeholder="Datasource default"
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Async" labelWidth={32} tooltip="Execute the query in the background and poll for the result, for queries running longer than the timeout of the Grafana data proxy.">
+                              <InlineSwitch
+                                  value={query.async || false}
+                                  onChange={onAsyncChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Schema Only" labelWidth={32} tooltip="Return only the columns of the result without any rows, so fields can be picked without scanning data.">
+                              <InlineSwitch
+                                  value={query.schemaOnly || false}
+                                  onChange={onSchemaOnlyChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Round Time Range" labelWidth={32} tooltip="Round the time range to multiples of the interval before the macros are expanded, so queries of relative time ranges issued shortly after each other produce identical SQL and hit the result cache. A duration like 1m, or $__interval.">
+                              <AutoSizeInput
+                                  value={querySettings.roundTimeRange || ''}
+                                  defaultValue={querySettings.roundTimeRange || ''}
+                                  onCommitChange={onRoundTimeRangeChange}
+                                  minWidth={32}
+                                  placeholder="1m"
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Live" labelWidth={32} tooltip="Stream the results of the query to the panel. The backend executes the query again every interval, with the time range moved to the current time, instead of the panel refreshing.">
+                              <InlineSwitch
+                                  value={querySettings.live || false}
+                                  onChange={onLiveChange}
+                              />
+                          </InlineField>
+                          {querySettings.live && (
+                              <InlineField label="Interval" labelWidth={16} tooltip="Interval in seconds, at least 5.">
+                                  <AutoSizeInput
+                                      type="number"
+                                      value={querySettings.liveInterval || ''}
+                                      defaultValue={querySettings.liveInterval || ''}
+                                      onCommitChange={onLiveIntervalChange}
+                                      minWidth={8}
+                                      placeholder="10"
+                                  />
+                              </InlineField>
+                          )}
+                          {querySettings.live && (
+                              <InlineField label="Trigger" labelWidth={16}>
+                                  <Select
+                                      width={24}
+                                      options={liveTriggerOptions}
+                                      value={querySettings.liveTrigger || 'interval'}
+                                      onChange={onLiveTriggerChange}
+                                  />
+                              </InlineField>
+                          )}
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Max Rows" labelWidth={32} tooltip="Maximum number of rows returned, overrides the row limit of the datasource.">
+                              <AutoSizeInput
+                                  type="number"
+                                  value={query.maxRows || ''}
+                                  defaultValue={query.maxRows || ''}
+                                  onCommitChange={onMaxRowsChange}
+                                  minWidth={32}
+                                  placeholder="Datasource default"
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
+                      <InlineFieldRow>
+                          <InlineField label="Fiscal Year Start Month" labelWidth={32} tooltip="First month of the fiscal year used by the fiscal macros, overrides the one of the datasource.">
+                              <Select
+                                  width={32}
+                                  options={monthOptions}
+                                  value={query.fiscalYearStartMonth || 0}
+                                  onChange={onFiscalYearStartMonthChange}
+                              />
+                          </InlineField>
+                      </InlineFieldRow>
                   </div>
               </Collapse>
       </div>
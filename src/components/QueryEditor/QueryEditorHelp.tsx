@@ -1,6 +1,7 @@
-import React from 'react';
+import React, {useEffect, useState} from 'react';
 import { QueryEditorHelpProps} from '@grafana/data';
-import { MyQuery } from '../../types';
+import { MacroInfo, MyQuery } from '../../types';
+import { DataSource } from '../../datasource';
 
 const examples = [
     {
@@ -24,6 +25,13 @@ const examples = [
 
 type Props = QueryEditorHelpProps<MyQuery>;
 export function QueryEditorHelp(props: Props) {
+    const [macros, setMacros] = useState<MacroInfo[]>([]);
+    useEffect(() => {
+        (props.datasource as DataSource).listMacros()
+            .then(setMacros)
+            .catch(() => setMacros([]));
+    }, [props.datasource]);
+
     return (
         <div>
             <h2>SQL Query Documentation</h2>
@@ -43,6 +51,18 @@ export function QueryEditorHelp(props: Props) {
                     {item.resultingQuery && (<code>{item.resultingQuery}</code>)}
                 </div>
             ))}
+            {macros.length > 0 && (
+                <>
+                    <h3>Macro Reference</h3>
+                    {macros.map((macro) => (
+                        <div className="cheat-sheet-item" key={macro.name}>
+                            <div className="cheat-sheet-item__title"><code>{macro.signature}</code></div>
+                            <div className="cheat-sheet-item__label">{macro.description}</div>
+                            {macro.example && (<code>{macro.example}</code>)}
+                        </div>
+                    ))}
+                </>
+            )}
         </div>
     );
 };
@@ -6,8 +6,8 @@ import {
     functions,
     templateVariables
 } from "./constants";
-import {Column, Suggestions} from "../../types";
-import {getCursorPositionClause, positionToIndex} from "./utils";
+import {Column, ColumnStats, FunctionCatalog, MacroInfo, Suggestions, TableInfo} from "../../types";
+import {describeObject, getCursorPositionClause, positionToIndex, qualifiedName} from "./utils";
 
 type ClauseSuggestionsType = {
     [clause in Clause]: CodeEditorSuggestionItem[]
@@ -53,11 +53,15 @@ export class QuerySuggestions {
 
     private tableSuggestions: CodeEditorSuggestionItem[] = [];
     private columnSuggestions: CodeEditorSuggestionItem[] = [];
+    private filterSuggestions: CodeEditorSuggestionItem[] = [];
+    private columnStatsCache: Map<string, ColumnStats | undefined> = new Map<string, ColumnStats | undefined>();
 
     constructor(dataSource: DataSource) {
         this.dataSource = dataSource;
         this.initConstantSuggestions();
         this.catalogSchemaTableInit();
+        this.functionCatalogInit();
+        this.macroCatalogInit();
     }
     // ts-ignore are used since the grafana-ui types for the suggestions do not contain all the fields of the
     // underlying monaco editor suggestions, additional fields are needed to insert snippets & sort the suggestions
@@ -104,6 +108,16 @@ export class QuerySuggestions {
             // @ts-ignore
             sortText: "d",
         });
+        this.constantSuggestions.templateVariables.push({
+            label: "$__timeGroup(timeColumn, interval)",
+            kind: CodeEditorSuggestionItemKind.Constant,
+            detail: "Template Variable",
+            insertText: "\\\$__timeGroup(${1:timeColumn}, ${2:\\\$__interval})",
+            // @ts-ignore
+            insertTextRules: 4,
+            // @ts-ignore
+            sortText: "d",
+        });
     }
 
     private async tryFetchTable(table: string): Promise<void> {
@@ -194,6 +208,61 @@ export class QuerySuggestions {
         if (this.currentClause === "SELECT" || this.currentClause === "FROM") {
             this.checkMetaDataRefresh(value, cursorPosition);
         }
+        if (this.currentClause === "WHERE") {
+            this.checkFilterColumn(value, cursorPosition);
+        } else {
+            this.filterSuggestions = [];
+        }
+    }
+
+    private checkFilterColumn(value: string, cursorPosition: {lineNumber: number, column: number}): void {
+        // Check if the cursor follows a column of the table in the WHERE clause, and suggest filters on it based on
+        // the statistics of the column
+
+        const cursorIndex = positionToIndex(value, cursorPosition);
+        const match = /(`[^`]+`|\w+)\s+$/.exec(value.substring(0, cursorIndex));
+        const column = match ? match[1].replace(/^`|`$/g, '') : "";
+        if (!this.suggestions.columns.some((c) => c.name === column)) {
+            this.filterSuggestions = [];
+            return;
+        }
+        const key = this.fetchedTableColumns + "/" + column;
+        if (this.columnStatsCache.has(key)) {
+            this.filterSuggestions = this.buildFilterSuggestions(this.columnStatsCache.get(key));
+            return;
+        }
+        this.columnStatsCache.set(key, undefined);
+        this.dataSource.getResource(`tables/${encodeURIComponent(this.fetchedTableColumns)}/columns/${encodeURIComponent(column)}/stats`).then((stats: ColumnStats) => {
+            this.columnStatsCache.set(key, stats);
+            this.filterSuggestions = this.buildFilterSuggestions(stats);
+        }).catch((error) => {
+            console.log(error);
+        })
+    }
+
+    private buildFilterSuggestions(stats?: ColumnStats): CodeEditorSuggestionItem[] {
+        if (!stats) {
+            return [];
+        }
+        const hints = [`${stats.rowCount} rows`, `~${stats.distinctCount} distinct values`];
+        if (stats.nullFraction !== undefined) {
+            hints.push(`${(stats.nullFraction * 100).toFixed(1)}% null`);
+        }
+        if (stats.min !== undefined && stats.max !== undefined) {
+            hints.push(`min ${stats.min}, max ${stats.max}`);
+        }
+        // The filters start with the quoted column, which is already typed
+        return stats.suggestedFilters.map((filter): CodeEditorSuggestionItem => {
+            const condition = filter.substring(filter.indexOf("` ") + 2);
+            return {
+                label: condition,
+                kind: CodeEditorSuggestionItemKind.Snippet,
+                detail: "Suggested filter",
+                documentation: hints.join(", "),
+                // @ts-ignore
+                sortText: "0",
+            }
+        });
     }
 
     private checkUseClause(value: string): void {
@@ -230,17 +299,69 @@ export class QuerySuggestions {
         }
     }
 
+    // functionCatalogInit replaces the function suggestions of the documented built-in functions with ones showing
+    // their signature and description, and adds the user defined functions of the workspace
+    private async functionCatalogInit() {
+        this.dataSource.getResource("functions", {udfs: true}).then((catalog: FunctionCatalog) => {
+            const documented = new Set(catalog.functions.map((func) => func.name));
+            this.constantSuggestions.functions = this.constantSuggestions.functions
+                .filter((suggestion) => !documented.has(suggestion.label.replace(/\(\)$/, "")))
+                .concat(catalog.functions.map((func): CodeEditorSuggestionItem => {
+                    return {
+                        label: func.name + "()",
+                        kind: CodeEditorSuggestionItemKind.Property,
+                        detail: func.signature || (func.kind === "udf" ? "User Function" : "Function"),
+                        documentation: func.description,
+                        insertText: func.name + "(${0})",
+                        // @ts-ignore
+                        insertTextRules: 4,
+                        // @ts-ignore
+                        sortText: "d",
+                    }
+                }));
+            this.rebuildClauseSuggestions();
+        }).catch((error) => {
+            console.log(error);
+        })
+    }
+
+    // macroCatalogInit replaces the suggestions of the macros with the ones expanded by the backend, including the user
+    // defined macros of the datasource, documented with their signature and description
+    private async macroCatalogInit() {
+        this.dataSource.listMacros().then((macros: MacroInfo[]) => {
+            const escape = (text: string) => text.replace(/[$}\\]/g, "\\$&");
+            this.constantSuggestions.templateVariables = this.constantSuggestions.templateVariables
+                .filter((suggestion) => !suggestion.label.startsWith("$__"))
+                .concat(macros.map((macro): CodeEditorSuggestionItem => {
+                    return {
+                        label: macro.signature,
+                        kind: CodeEditorSuggestionItemKind.Constant,
+                        detail: macro.kind === "user" ? "User Macro" : "Macro",
+                        documentation: macro.example ? `${macro.description}\n\n${macro.example}` : macro.description,
+                        insertText: macro.signature.includes("(") ? escape(macro.name) + "(${0})" : escape(macro.name),
+                        // @ts-ignore
+                        insertTextRules: 4,
+                        // @ts-ignore
+                        sortText: "d",
+                    }
+                }));
+            this.rebuildClauseSuggestions();
+        }).catch((error) => {
+            console.log(error);
+        })
+    }
+
     private async catalogSchemaTableInit() {
 
-        await this.dataSource.postResource("defaults", {}).then((defaults: {defaultCatalog: string, defaultSchema: string}) => {
+        await this.dataSource.getResource("defaults").then((defaults: {defaultCatalog: string, defaultSchema: string}) => {
             this.currentCatalog = defaults.defaultCatalog;
             this.currentSchema = defaults.defaultSchema;
         }).catch((error) => {
             console.log(error);
         });
 
-        this.dataSource.postResource("catalogs", {}).then((catalogs) => {
-            this.suggestions.catalogs = catalogs;
+        this.dataSource.getResource("catalogs").then((catalogs: Array<{name: string}>) => {
+            this.suggestions.catalogs = catalogs.map((catalog) => catalog.name);
             this.suggestions.catalogs.forEach((catalog) => {
                 this.tableSuggestions.push({
                     label: catalog,
@@ -253,8 +374,8 @@ export class QuerySuggestions {
         }).catch((error) => {
             console.log(error);
         })
-        this.dataSource.postResource("schemas", {catalog: this.currentCatalog}).then((schemas) => {
-            this.suggestions.schemas = schemas;
+        this.dataSource.getResource(`catalogs/${encodeURIComponent(this.currentCatalog)}/schemas`).then((schemas: Array<{name: string}>) => {
+            this.suggestions.schemas = schemas.map((schema) => schema.name);
             this.suggestions.schemas.forEach((schema) => {
                 this.tableSuggestions.push({
                     label: this.currentCatalog + '.' + schema,
@@ -267,8 +388,8 @@ export class QuerySuggestions {
         }).catch((error) => {
             console.log(error);
         })
-        this.dataSource.postResource("tables", {catalog: this.currentCatalog, schema: this.currentSchema}).then((tables) => {
-            this.suggestions.tables = tables;
+        this.dataSource.getResource(`schemas/${qualifiedName(this.currentCatalog, this.currentSchema)}/tables`).then((tables: Array<{name: string}>) => {
+            this.suggestions.tables = tables.map((table) => table.name);
             this.suggestions.tables.forEach((table) => {
                 this.tableSuggestions.push({
                     label: this.currentCatalog + '.' + this.currentSchema + '.' + table,
@@ -300,7 +421,7 @@ export class QuerySuggestions {
                     label: column.name,
                     kind: CodeEditorSuggestionItemKind.Field,
                     detail: column.type,
-                    documentation: 'Column',
+                    documentation: describeObject('Column', column),
                     // @ts-ignore
                     sortText: "a",
                 }
@@ -310,7 +431,7 @@ export class QuerySuggestions {
         }
         this.fetchedTableColumns = table;
         this.tableColumnsCache.set(table, []);
-        this.dataSource.postResource("columns", {table: table}).then((columns) => {
+        this.dataSource.getResource(`tables/${encodeURIComponent(table)}/columns`).then((columns) => {
             this.suggestions.columns = columns;
             this.tableColumnsCache.set(table, columns);
             this.columnSuggestions = this.suggestions.columns.map((column): CodeEditorSuggestionItem => {
@@ -318,7 +439,7 @@ export class QuerySuggestions {
                     label: column.name,
                     kind: CodeEditorSuggestionItemKind.Field,
                     detail: column.type,
-                    documentation: 'Column',
+                    documentation: describeObject('Column', column),
                     // @ts-ignore
                     sortText: "a",
                 }
@@ -337,8 +458,8 @@ export class QuerySuggestions {
             return;
         }
         this.loadedSchemas.push(catalog);
-        this.dataSource.postResource("schemas", {catalog: catalog}).then((schemas) => {
-            schemas.forEach((schema: string) => {
+        this.dataSource.getResource(`catalogs/${encodeURIComponent(catalog)}/schemas`).then((schemas: Array<{name: string}>) => {
+            schemas.map((s) => s.name).forEach((schema: string) => {
                 this.suggestions.schemas.push(catalog + "." + schema);
                 this.suggestions.schemas.push(schema);
                 this.tableSuggestions.push({
@@ -370,8 +491,9 @@ export class QuerySuggestions {
             return;
         }
         this.loadedTables.push(catalog + "." + schema);
-        this.dataSource.postResource("tables", {catalog: catalog, schema: schema}).then((tables) => {
-            tables.forEach((table: string) => {
+        this.dataSource.getResource(`schemas/${qualifiedName(catalog, schema)}/tables`).then((tables: TableInfo[]) => {
+            tables.forEach((info) => {
+                const table = info.name;
                 this.suggestions.tables.push(catalog + "." + schema + "." + table);
                 this.suggestions.tables.push(schema + "." + table);
                 this.suggestions.tables.push(table);
@@ -379,6 +501,7 @@ export class QuerySuggestions {
                     label: catalog + "." + schema + "." + table,
                     kind: CodeEditorSuggestionItemKind.Method,
                     detail: "Table",
+                    documentation: describeObject('Table', info),
                     // @ts-ignore
                     sortText: "a",
                 });
@@ -386,6 +509,7 @@ export class QuerySuggestions {
                     label: schema + "." + table,
                     kind: CodeEditorSuggestionItemKind.Method,
                     detail: "Table",
+                    documentation: describeObject('Table', info),
                     // @ts-ignore
                     sortText: "b",
                 });
@@ -393,6 +517,7 @@ export class QuerySuggestions {
                     label: table,
                     kind: CodeEditorSuggestionItemKind.Method,
                     detail: "Table",
+                    documentation: describeObject('Table', info),
                     // @ts-ignore
                     sortText: "c",
                 });
@@ -404,6 +529,9 @@ export class QuerySuggestions {
     }
 
     public getSuggestions(): CodeEditorSuggestionItem[] {
+        if (this.currentClause === "WHERE") {
+            return this.filterSuggestions.concat(this.clauseSuggestions.WHERE);
+        }
         if (this.currentClause in Clause) {
             return this.clauseSuggestions[this.currentClause as Clause];
         }
@@ -41,3 +41,31 @@ export function getCursorPositionClause(value: string, cursorPosition: {lineNumb
         index: 0
     };
 }
+
+// qualifiedName renders the URL encoded name of a schema or table for the metadata resources,
+// quoting every part so names containing dots are not split.
+export function qualifiedName(...parts: string[]): string {
+    return encodeURIComponent(parts.map((part) => '`' + part.replace(/`/g, '``') + '`').join('.'));
+}
+
+// queryTable returns the unquoted parts of the name of the first table the query selects from, or an empty array.
+export function queryTable(query: string): string[] {
+    const match = /\bFROM\s+((?:`[^`]+`|\w+)(?:\s*\.\s*(?:`[^`]+`|\w+)){0,2})/i.exec(query);
+    return match ? (match[1].match(/`[^`]+`|\w+/g) || []).map((part) => part.replace(/^`|`$/g, '')) : [];
+}
+
+// describeObject renders the documentation of a suggested table or column, with its comment, owner and Unity Catalog tags.
+export function describeObject(kind: string, object: {comment?: string, owner?: string, tags?: Record<string, string>}): string {
+    const lines = [kind];
+    if (object.comment) {
+        lines.push(object.comment);
+    }
+    if (object.owner) {
+        lines.push(`Owner: ${object.owner}`);
+    }
+    const tags = Object.entries(object.tags || {}).map(([name, value]) => value ? `${name}=${value}` : name);
+    if (tags.length > 0) {
+        lines.push(`Tags: ${tags.join(', ')}`);
+    }
+    return lines.join('\n\n');
+}
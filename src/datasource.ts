@@ -1,25 +1,201 @@
-import {DataFrame, DataQueryRequest, DataSourceInstanceSettings, MetricFindValue, ScopedVars} from '@grafana/data';
-import {DataSourceWithBackend, getTemplateSrv} from '@grafana/runtime';
-import {MyDataSourceOptions, MyQuery} from './types';
-import {switchMap} from 'rxjs/operators';
-import {firstValueFrom} from 'rxjs';
+import {DataFrame, dataFrameFromJSON, DataFrameJSON, DataQueryRequest, DataQueryResponse, DataSourceInstanceSettings, LiveChannelScope, LoadingState, MetricFindValue, ScopedVars} from '@grafana/data';
+import {DataSourceWithBackend, getGrafanaLiveSrv, getTemplateSrv, StreamingFrameAction} from '@grafana/runtime';
+import {HistoryQuery, LintWarning, MacroInfo, MyDataSourceOptions, MyQuery, PreviewResult, QueryProgress, SavedQueryInfo, TableDetail, ValidationResult, WarehouseInfo} from './types';
+import {qualifiedName} from "./components/Suggestions/utils";
+import {filter, map, mergeMap, startWith, switchMap} from 'rxjs/operators';
+import {firstValueFrom, Observable, of, timer} from 'rxjs';
 import {QuerySuggestions} from "./components/Suggestions/QuerySuggestions";
 
+// Interval in which the results of async queries are polled.
+const asyncPollInterval = 2000;
+
+// queryTimezone returns the timezone of the dashboard the time macros are rendered in.
+// Dashboards using the timezone of the browser keep the macros in UTC.
+export function queryTimezone(timezone?: string): string | undefined {
+    return timezone && timezone !== 'browser' ? timezone : undefined;
+}
+
 export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptions> {
     public suggestionProvider: QuerySuggestions;
     public autoCompletionEnabled: boolean;
+    public warehouses: string[];
+    // session distinguishes the query ids of this browser session, as request ids are
+    // only counted up per session
+    private session = Math.random().toString(36).slice(2);
     constructor(instanceSettings: DataSourceInstanceSettings<MyDataSourceOptions>) {
         super(instanceSettings);
         this.annotations = {}
         this.suggestionProvider = new QuerySuggestions(this);
         this.autoCompletionEnabled = instanceSettings.jsonData.autoCompletion || false;
+        this.warehouses = (instanceSettings.jsonData.warehouses || []).map((warehouse) => warehouse.name);
+        // Live queries stream the whole result of every execution, which replaces the
+        // frame shown by the panel, except for the change data feed and tail queries which
+        // stream new rows only, like the warehouse utilization
+        this.streamOptionsProvider = (request, frame) => {
+            const target = request.targets.find((target) => target.refId === frame.refId);
+            const trigger = target?.querySettings?.liveTrigger;
+            const append = trigger === 'changes' || trigger === 'tail' || target?.queryType === 'warehouseUtilization';
+            return {
+                maxLength: request.maxDataPoints ?? 500,
+                action: append ? StreamingFrameAction.Append : StreamingFrameAction.Replace,
+            };
+        };
+    }
+
+    query(request: DataQueryRequest<MyQuery>): Observable<DataQueryResponse> {
+        // Assign each query an id, so it can be cancelled while it is running, and tag it
+        // with the dashboard and panel it belongs to
+        const targets = request.targets.map((target) => ({
+            ...target,
+            queryId: this.queryId(request.requestId, target.refId),
+            dashboardUid: request.dashboardUID,
+            panelId: request.panelId,
+            timezone: queryTimezone(request.timezone),
+        }));
+        return super.query({...request, targets}).pipe(
+            mergeMap((response) => this.pollAsyncQueries(request, targets, response))
+        );
+    }
+
+    // pollAsyncQueries polls the async queries of the response which are still running,
+    // emitting the results of the finished queries in the meantime.
+    private pollAsyncQueries(request: DataQueryRequest<MyQuery>, targets: MyQuery[], response: DataQueryResponse): Observable<DataQueryResponse> {
+        const handles = new Map<string, string>();
+        for (const frame of response.data as DataFrame[]) {
+            if (frame.refId && frame.meta?.custom?.asyncStatus === 'running') {
+                handles.set(frame.refId, frame.meta.custom.asyncHandle);
+            }
+        }
+        if (handles.size === 0) {
+            return of(response);
+        }
+        const done = (response.data as DataFrame[]).filter((frame) => !frame.refId || !handles.has(frame.refId));
+        const pending = targets
+            .filter((target) => handles.has(target.refId))
+            .map((target) => ({...target, async: false, asyncHandle: handles.get(target.refId)}));
+        return timer(asyncPollInterval).pipe(
+            mergeMap(() => super.query({...request, targets: pending})),
+            mergeMap((next) => this.pollAsyncQueries(request, pending, next)),
+            map((next) => ({...next, data: [...done, ...next.data]})),
+            startWith({...response, data: done, state: LoadingState.Loading})
+        );
+    }
+
+    // queryId returns the id of the query of the request, which is used to cancel it and
+    // to follow its progress
+    queryId(requestId: string, refId: string): string {
+        return `${this.session}-${requestId}-${refId}`;
+    }
+
+    // queryProgress streams the progress of the running query with the id, until it finished
+    queryProgress(queryId: string): Observable<QueryProgress> {
+        return getGrafanaLiveSrv().getDataStream({
+            addr: {scope: LiveChannelScope.DataSource, namespace: this.uid, path: `progress/${queryId}`},
+        }).pipe(
+            filter((response) => response.data.length > 0 && response.data[0].length > 0),
+            map((response) => {
+                const frame = response.data[0] as DataFrame;
+                const progress: Record<string, any> = {};
+                for (const field of frame.fields) {
+                    progress[field.name] = field.values.get(field.values.length - 1) ?? undefined;
+                }
+                return progress as QueryProgress;
+            })
+        );
+    }
+
+    async cancelQuery(queryId: string): Promise<boolean> {
+        return this.postResource(`queries/${encodeURIComponent(queryId)}/cancel`, {})
+            .then((response: {cancelled: boolean}) => response.cancelled)
+            .catch(() => false);
+    }
+
+    // previewTable returns the first rows of the table, given by its catalog, schema and name
+    async previewTable(catalog: string, schema: string, table: string, limit?: number): Promise<DataFrame> {
+        const path = [catalog, schema, table].map(encodeURIComponent).join('/');
+        return this.getResource(`tables/${path}/preview`, limit ? {limit: limit} : undefined)
+            .then((frame: DataFrameJSON) => dataFrameFromJSON(frame));
+    }
+
+    // queryHistory returns the recent statements of the warehouse, only the ones of the dashboard if set
+    async queryHistory(warehouse?: string, dashboardUid?: string): Promise<HistoryQuery[]> {
+        return this.getResource("history", {
+            ...(warehouse ? {warehouse: warehouse} : {}),
+            ...(dashboardUid ? {dashboardUid: dashboardUid} : {}),
+        });
+    }
+
+    // listMacros returns the macros expanded by the backend, including the user defined macros of the datasource
+    async listMacros(): Promise<MacroInfo[]> {
+        return this.getResource('macros');
+    }
+
+    // tableDetail returns the layout and size of the table, given by the parts of its name
+    async tableDetail(table: string[]): Promise<TableDetail> {
+        return this.getResource(`tables/${qualifiedName(...table)}/detail`);
+    }
+
+    async listSavedQueries(): Promise<SavedQueryInfo[]> {
+        return this.getResource("saved-queries");
+    }
+
+    async listWarehouses(): Promise<WarehouseInfo[]> {
+        return this.getResource("warehouses");
+    }
+
+    async validateQuery(query: MyQuery, from?: number, to?: number, timezone?: string): Promise<ValidationResult> {
+        const templateSrv = getTemplateSrv();
+        return this.postResource("validate", {
+            rawSqlQuery: templateSrv.replace(query.rawSqlQuery || ''),
+            parameters: query.parameters,
+            warehouse: query.warehouse,
+            from: from,
+            to: to,
+            timezone: queryTimezone(timezone),
+            fiscalYearStartMonth: query.fiscalYearStartMonth,
+            dashboardUid: query.dashboardUid,
+        });
+    }
+
+    async previewQuery(query: MyQuery, from?: number, to?: number, intervalMs?: number, timezone?: string): Promise<PreviewResult> {
+        const templateSrv = getTemplateSrv();
+        return this.postResource("preview", {
+            rawSqlQuery: templateSrv.replace(query.rawSqlQuery || ''),
+            parameters: query.parameters,
+            from: from,
+            to: to,
+            intervalMs: intervalMs,
+            timezone: queryTimezone(timezone),
+            fiscalYearStartMonth: query.fiscalYearStartMonth,
+            dashboardUid: query.dashboardUid,
+        });
+    }
+
+    // lintQuery returns the warnings of the static checks of the query, macros are checked before being expanded
+    async lintQuery(query: MyQuery): Promise<LintWarning[]> {
+        return this.postResource("lint", {rawSqlQuery: query.rawSqlQuery || '', format: query.format || ''})
+            .then((result: {warnings: LintWarning[]}) => result.warnings);
+    }
+
+    // formatQuery returns the SQL formatted by the backend, macros and variables are kept as they are
+    async formatQuery(rawSqlQuery: string): Promise<string> {
+        return this.postResource("format", {rawSqlQuery: rawSqlQuery}).then((result: PreviewResult) => result.sql);
     }
 
     applyTemplateVariables(query: MyQuery, scopedVars: ScopedVars) {
         const templateSrv = getTemplateSrv();
+        // The interval variables are expanded by the backend, which also expands them for
+        // alert rules and coarsens them if the query is limited to the max data points
+        scopedVars = {...scopedVars};
+        delete scopedVars.__interval;
+        delete scopedVars.__interval_ms;
+        const parameters = query.parameters ? Object.fromEntries(Object.entries(query.parameters).map(([name, value]) => {
+            return [name, typeof value === 'string' ? templateSrv.replace(value, scopedVars) : value];
+        })) : undefined;
         return {
             ...query,
-            rawSqlQuery: query.rawSqlQuery ? templateSrv.replace(query.rawSqlQuery, scopedVars) : ''
+            rawSqlQuery: query.rawSqlQuery ? templateSrv.replace(query.rawSqlQuery, scopedVars) : '',
+            parameters: parameters
         };
     }
 
@@ -32,6 +208,7 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
             targets: [
                 {
                     rawSqlQuery: getTemplateSrv().replace(queryText, options.scopedVars),
+                    searchFilter: options.searchFilter,
                     refId: 'metricFindQuery'
                 },
             ],
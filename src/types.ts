@@ -4,10 +4,38 @@ interface QuerySettings {
   convertLongToWide: boolean
   fillMode?: number
   fillValue?: number
+  limitToMaxDataPoints?: boolean
+  downsample?: boolean
+  downsampleAggregation?: string
+  intervalFormat?: string
+  bigintAsString?: boolean
+  columnTypes?: Record<string, string>
+  flattenStructs?: boolean
+  columnMetadata?: boolean
+  resample?: boolean
+  live?: boolean
+  liveInterval?: number
+  liveTrigger?: string
+  roundTimeRange?: string
 }
 export interface MyQuery extends DataQuery {
   rawSqlQuery?: string;
+  format?: string;
   querySettings: QuerySettings;
+  parameters?: Record<string, any>;
+  timeout?: number;
+  maxRows?: number;
+  warehouse?: string;
+  queryId?: string;
+  async?: boolean;
+  asyncHandle?: string;
+  dashboardUid?: string;
+  panelId?: number;
+  schemaOnly?: boolean;
+  timezone?: string;
+  searchFilter?: string;
+  fiscalYearStartMonth?: number;
+  savedQueryId?: string;
 }
 
 export const defaultQuery: Partial<MyQuery> = {
@@ -26,6 +54,79 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
   port?: string;
   path?: string;
   autoCompletion?: boolean;
+  authenticationMethod?: string;
+  clientId?: string;
+  tenantId?: string;
+  oauthPassThru?: boolean;
+  tlsSkipVerify?: boolean;
+  tlsAuth?: boolean;
+  tlsAuthWithCACert?: boolean;
+  serverName?: string;
+  enableSecureSocksProxy?: boolean;
+  proxyUrl?: string;
+  proxyUsername?: string;
+  readOnly?: boolean;
+  statementAllowlist?: string[];
+  statementDenylist?: string[];
+  credentialMappings?: CredentialMapping[];
+  catalog?: string;
+  schema?: string;
+  sessionParameters?: Record<string, string>;
+  maxOpenConns?: number;
+  maxIdleConns?: number;
+  connMaxLifetime?: number;
+  connMaxIdleTime?: number;
+  queryTimeout?: number;
+  maxRetries?: number;
+  warehouseStartTimeout?: number;
+  warehouses?: Warehouse[];
+  keepAlive?: boolean;
+  keepAliveInterval?: number;
+  userAgent?: string;
+  circuitBreakerThreshold?: number;
+  circuitBreakerCooldown?: number;
+  poolDrainTimeout?: number;
+  maxConcurrentQueries?: number;
+  executionMode?: string;
+  resultDisposition?: string;
+  cloudFetch?: boolean;
+  maxDownloadThreads?: number;
+  maxRows?: number;
+  maxRunningQueries?: number;
+  queueTimeout?: number;
+  queryDedupWindow?: number;
+  queryMetrics?: boolean;
+  queryTags?: boolean;
+  alertQueryTimeout?: number;
+  macros?: Macro[];
+  fiscalYearStartMonth?: number;
+  decimalAsString?: boolean;
+  timestampNtzTimezone?: string;
+  maxCellSize?: number;
+  metadataCacheTtl?: number;
+  filterMetadata?: boolean;
+  resultCacheTtl?: number;
+  resultCacheMaxSize?: number;
+  resultCacheDir?: string;
+}
+
+export interface Macro {
+  name: string;
+  template: string;
+}
+
+export interface Warehouse {
+  name: string;
+  path: string;
+}
+
+export interface CredentialMapping {
+  orgId?: number;
+  roles?: string[];
+  users?: string[];
+  authenticationMethod?: string;
+  tenantId?: string;
+  clientId?: string;
 }
 
 /**
@@ -33,6 +134,12 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
  */
 export interface MySecureJsonData {
   token?: string;
+  clientSecret?: string;
+  tlsCACert?: string;
+  tlsClientCert?: string;
+  tlsClientKey?: string;
+  proxyPassword?: string;
+  serviceAccountKey?: string;
 }
 
 export interface MyVariableQuery {
@@ -40,9 +147,131 @@ export interface MyVariableQuery {
   rawQuery: string;
 }
 
+export interface StatementValidation {
+  statement: string
+  plan?: string
+  error?: string
+}
+
+export interface ValidationResult {
+  valid: boolean
+  error?: string
+  statements: StatementValidation[]
+}
+
+export interface PreviewResult {
+  sql: string
+  error?: string
+}
+
+export interface TableDetail {
+  name: string
+  format: string
+  partitionColumns: string[]
+  clusteringColumns: string[]
+  numFiles?: number
+  sizeInBytes?: number
+  rowCount?: number
+  lastModified?: string
+  lastOptimize?: string
+  lastVacuum?: string
+  warnings: string[]
+}
+
+export interface SavedQueryInfo {
+  id: string
+  name: string
+  description?: string
+  owner?: string
+}
+
+export interface HistoryQuery {
+  queryId: string
+  statement: string
+  status: string
+  user: string
+  startTimeMs: number
+  durationMs: number
+  rows?: number
+  bytesScanned?: number
+  error?: string
+}
+
+export interface WarehouseInfo {
+  id: string
+  name: string
+  size: string
+  state: string
+  serverless: boolean
+  path: string
+  default: boolean
+  configured?: string
+}
+
+export interface SqlFunction {
+  name: string
+  signature?: string
+  description?: string
+  kind: string
+}
+
+export interface FunctionCatalog {
+  functions: SqlFunction[]
+  keywords: string[]
+}
+
 export interface Column {
   name: string
   type: string
+  comment?: string
+  tags?: Record<string, string>
+}
+
+export interface TableInfo {
+  name: string
+  comment?: string
+  owner?: string
+  tags?: Record<string, string>
+}
+
+export interface ColumnStats {
+  column: string
+  type?: string
+  rowCount: number
+  nullCount: number
+  nullFraction?: number
+  distinctCount: number
+  min?: string
+  max?: string
+  topValues?: Array<{value: string, count: number}>
+  samplePercent?: number
+  suggestedFilters: string[]
+}
+
+export interface LintWarning {
+  code: 'missing_time_filter' | 'select_star' | 'cross_join' | 'missing_limit'
+  message: string
+  statement: number
+}
+
+export interface QueryProgress {
+  state: 'queued' | 'running' | 'finished'
+  status: string
+  elapsed: number
+  statement: number
+  statements: number
+  statementId: string
+  bytesRead?: number
+  rowsRead?: number
+  filesRead?: number
+}
+
+export interface MacroInfo {
+  name: string
+  signature: string
+  description: string
+  example?: string
+  kind: 'builtin' | 'user' | 'variable'
 }
 
 export interface Suggestions {